@@ -0,0 +1,31 @@
+package v1
+
+import (
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// JobSpec 一次性任务, 如数据库迁移
+type JobSpec struct {
+	// 镜像名称, 默认与任务同名; 与apps中同名应用共用镜像仓库及凭证配置
+	Image   string          `json:"image,omitempty"`
+	Tag     string          `json:"tag,omitempty"`
+	Command []string        `json:"command,omitempty"`
+	Args    []string        `json:"args,omitempty"`
+	Env     []corev1.EnvVar `json:"env,omitempty"`
+	// 未配置时使用spec.resources
+	Resources             *corev1.ResourceRequirements `json:"resources,omitempty"`
+	BackoffLimit          *int32                       `json:"backoffLimit,omitempty"`
+	ActiveDeadlineSeconds *int64                       `json:"activeDeadlineSeconds,omitempty"`
+}
+
+// CronJobSpec 定时任务, 如每日报表
+type CronJobSpec struct {
+	JobSpec                    `json:",inline"`
+	Schedule                   string                    `json:"schedule"`
+	TimeZone                   *string                   `json:"timeZone,omitempty"`
+	ConcurrencyPolicy          batchv1.ConcurrencyPolicy `json:"concurrencyPolicy,omitempty"`
+	Suspend                    *bool                     `json:"suspend,omitempty"`
+	SuccessfulJobsHistoryLimit *int32                    `json:"successfulJobsHistoryLimit,omitempty"`
+	FailedJobsHistoryLimit     *int32                    `json:"failedJobsHistoryLimit,omitempty"`
+}
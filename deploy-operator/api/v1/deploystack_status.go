@@ -40,8 +40,115 @@ type DeployStackCondition struct {
 	Message            string                 `json:"message,omitempty"`
 }
 
+// 应用状态
+const (
+	AppPhasePreDeployHookRunning  = "PreDeployHookRunning"
+	AppPhasePreDeployHookFailed   = "PreDeployHookFailed"
+	AppPhasePostDeployHookRunning = "PostDeployHookRunning"
+	AppPhasePostDeployHookFailed  = "PostDeployHookFailed"
+	AppPhaseSuspended             = "Suspended"
+	AppPhaseRolledBack            = "RolledBack"
+	AppPhaseWaitingForDependency  = "WaitingForDependency"
+	AppPhaseRolloutQueued         = "RolloutQueued"
+	// 调谐失败, 修改spec前不再重试
+	AppPhaseFailed = "Failed"
+	// 调谐遇到暂时性错误, 按退避时间重试
+	AppPhaseRetrying = "Retrying"
+)
+
+// 回滚原因
+const (
+	RollbackReasonManual                   = "Manual"
+	RollbackReasonProgressDeadlineExceeded = "ProgressDeadlineExceeded"
+)
+
+// ReconcileRequestAnnotation 值变化时触发一次调谐, spec.paused 时同样生效
+const ReconcileRequestAnnotation = "gopron.online/reconcile-at"
+
+// RetryHooksAnnotation 值变化时重新执行应用中失败的发布钩子, 每个值只重试一次
+const RetryHooksAnnotation = "gopron.online/retry-hooks-at"
+
+// RollbackAnnotationPrefix rollback.gopron.online/<app>: "<revision>" 将应用回滚到指定版本, 值变化时执行一次
+const RollbackAnnotationPrefix = "rollback.gopron.online/"
+
+// AppStatus appsList中单个应用的状态
+type AppStatus struct {
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message,omitempty"`
+	// 发布版本记录, 按版本号升序, 数量不超过spec.revisionHistoryLimit
+	Revisions []AppRevision `json:"revisions,omitempty"`
+	// 生效中的回滚
+	Rollback *AppRollback `json:"rollback,omitempty"`
+	// 最近一次处理的回滚注解值
+	LastHandledRollback string `json:"lastHandledRollback,omitempty"`
+	// 镜像仓库的检查结果
+	Image *AppImageStatus `json:"image,omitempty"`
+}
+
+// AppImageStatus 应用镜像在仓库中的状态
+type AppImageStatus struct {
+	// imageUpdate策略匹配的最新tag
+	LatestTag string       `json:"latestTag,omitempty"`
+	CheckedAt *metav1.Time `json:"checkedAt,omitempty"`
+	// pinDigest开启时, 发布的镜像地址(含tag)及解析得到的digest
+	Image      string       `json:"image,omitempty"`
+	Digest     string       `json:"digest,omitempty"`
+	ResolvedAt *metav1.Time `json:"resolvedAt,omitempty"`
+	// 最近一次检查失败的原因
+	Message string `json:"message,omitempty"`
+}
+
+// AppRevision 应用的一次发布, tag或Pod模版变化时记录
+type AppRevision struct {
+	Revision     int64       `json:"revision"`
+	Tag          string      `json:"tag"`
+	TemplateHash string      `json:"templateHash,omitempty"`
+	Time         metav1.Time `json:"time,omitempty"`
+	// 最近一次修改DeployStack spec的field manager
+	ChangedBy string `json:"changedBy,omitempty"`
+}
+
+// AppRollback 应用回滚到的版本, appsList中的tag变化后失效
+type AppRollback struct {
+	Revision int64  `json:"revision"`
+	Tag      string `json:"tag"`
+	// 回滚时appsList中的tag
+	FromTag string `json:"fromTag"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// CertificateStatus ingress域名的证书状态
+type CertificateStatus struct {
+	Host       string `json:"host"`
+	SecretName string `json:"secretName"`
+	Ready      bool   `json:"ready"`
+	Message    string `json:"message,omitempty"`
+}
+
 // DeployStackStatus defines the observed state of DeployStack
 type DeployStackStatus struct {
-	Status     string                 `json:"status,omitempty"`
-	Conditions []DeployStackCondition `json:"conditions,omitempty"`
+	Status       string                 `json:"status,omitempty"`
+	Conditions   []DeployStackCondition `json:"conditions,omitempty"`
+	Apps         map[string]AppStatus   `json:"apps,omitempty"`
+	Certificates []CertificateStatus    `json:"certificates,omitempty"`
+	// 是否已暂停调谐
+	Paused bool `json:"paused,omitempty"`
+	// 最近一次处理的 gopron.online/reconcile-at 注解值
+	LastHandledReconcileAt string `json:"lastHandledReconcileAt,omitempty"`
+	// 配置了spec.rolloutPolicy时的发布进度
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
+}
+
+// RolloutStatus 分批发布的进度
+type RolloutStatus struct {
+	// 正在发布的波次, 未配置waves时为空
+	Wave string `json:"wave,omitempty"`
+	// 已按当前tag发布完成的应用数量及应用总数
+	Done  int32 `json:"done"`
+	Total int32 `json:"total"`
+	// 已发布完成数量/总数, 如 3/10
+	Progress string `json:"progress,omitempty"`
+	// 正在发布及排队等待发布的应用
+	InProgress []string `json:"inProgress,omitempty"`
+	Queued     []string `json:"queued,omitempty"`
 }
@@ -2,8 +2,11 @@ package v1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -20,7 +23,7 @@ type DeployStackSpec struct {
 	Service           DeployStackServiceSpec       `json:"service,omitempty"`
 	Resources         *corev1.ResourceRequirements `json:"resources,omitempty"`
 	Affinity          *corev1.Affinity             `json:"affinity,omitempty"`
-	Toleration        *corev1.Toleration           `json:"toleration,omitempty"`
+	Toleration        *corev1.Toleration           `json:"toleration,omitempty"` // Deprecated: use Tolerations
 	Default           map[string]string            `json:"default,omitempty"`
 	Ports             []DefaultPorts               `json:"ports,omitempty"`
 	Configs           map[string]string            `json:"configs,omitempty"`
@@ -31,11 +34,37 @@ type DeployStackSpec struct {
 	ResourcesMemory   string                       `json:"resourcesMemory,omitempty"`
 	ResourcesCpu      string                       `json:"resourcesCpu,omitempty"`
 	ProbeReadyTcpPort int32                        `json:"probeReadyTcpPort,omitempty"`
+	// 调度配置, apps[name]中可单独覆盖
+	Tolerations               []corev1.Toleration               `json:"tolerations,omitempty"`
+	NodeSelector              map[string]string                 `json:"nodeSelector,omitempty"`
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+	TopologySpreadPreset      TopologySpreadPreset              `json:"topologySpreadPreset,omitempty"`
+	// 可复用的sidecar模版, apps[name].sidecarRefs 按名称引用
+	SidecarTemplates map[string]SidecarTemplate `json:"sidecarTemplates,omitempty"`
+	// 批处理任务, 与应用共用镜像仓库、global-config及global-secret
+	Jobs     map[string]JobSpec     `json:"jobs,omitempty"`
+	CronJobs map[string]CronJobSpec `json:"cronJobs,omitempty"`
+	// ingress默认的ingressClass及控制器类型, ingress[]中可单独配置
+	IngressClassName  string            `json:"ingressClassName,omitempty"`
+	IngressController IngressController `json:"ingressController,omitempty"`
+	// 路由方式, 默认ingress
+	Routing *RoutingSpec `json:"routing,omitempty"`
+	// 暂停调谐, 不再创建、更新或删除任何资源; 可通过 gopron.online/reconcile-at 注解触发单次调谐
+	Paused bool `json:"paused,omitempty"`
+	// 每个应用保留的发布版本数量, 默认10
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+	// Deployment超过progressDeadlineSeconds仍未发布完成时, 自动回滚到上一个不同tag的版本
+	AutoRollback bool `json:"autoRollback,omitempty"`
+	// 发布时将镜像tag解析为digest, 所有副本运行相同的镜像, apps[name].pinDigest 可单独配置
+	PinDigest bool `json:"pinDigest,omitempty"`
+	// 分批发布, 限制同时发布的应用数量
+	RolloutPolicy *RolloutPolicy `json:"rolloutPolicy,omitempty"`
 	// Override        DeployStackOverrideSpec      `json:"override,omitempty"`
 
 }
 
 type IngressSpec struct {
+	// 同名规则合并为一个Ingress(<name>-ingress), 名称与应用无关
 	Name        string            `json:"name,omitempty"`
 	Https       bool              `json:"https,omitempty"`
 	Host        string            `json:"host,omitempty"`
@@ -43,9 +72,105 @@ type IngressSpec struct {
 	Exact       map[string]string `json:"exact,omitempty"`
 	Match       map[string]string `json:"match,omitempty"`
 	Annotations map[string]string `json:"annotations,omitempty"`
+	// 结构化的路径配置, 按配置顺序生成; prefix、exact、match中的"service port"写法仍然支持
+	Paths []IngressPath `json:"paths,omitempty"`
+	// https证书, 未配置时使用默认证书
+	TLS *IngressTLS `json:"tls,omitempty"`
+	// 未配置或与spec.ingressClassName相同时使用spec中的配置, 控制器类型未配置时根据集群中IngressClass的spec.controller确定
+	IngressClassName string            `json:"ingressClassName,omitempty"`
+	Controller       IngressController `json:"controller,omitempty"`
+	// 内网访问, 用于ALB的scheme
+	Internal bool `json:"internal,omitempty"`
+	// gateway模式下的gRPC路由
+	Grpc []GRPCRouteMatch `json:"grpc,omitempty"`
+	// gateway模式下按路径配置带权重的后端, 用于流量拆分
+	WeightedBackends map[string][]WeightedBackend `json:"weightedBackends,omitempty"`
 	// Port        int32             `json:"port,omitempty"`
 }
 
+// RoutingMode 路由方式
+// +kubebuilder:validation:Enum=ingress;gateway
+type RoutingMode string
+
+const (
+	RoutingModeIngress RoutingMode = "ingress"
+	RoutingModeGateway RoutingMode = "gateway"
+)
+
+// RoutingSpec gateway模式下由ingress[]生成HTTPRoute及GRPCRoute
+type RoutingSpec struct {
+	Mode    RoutingMode `json:"mode,omitempty"`
+	Gateway *GatewayRef `json:"gateway,omitempty"`
+}
+
+// GatewayRef 路由挂载的Gateway
+type GatewayRef struct {
+	Name        string `json:"name"`
+	Namespace   string `json:"namespace,omitempty"`
+	SectionName string `json:"sectionName,omitempty"`
+}
+
+// GRPCRouteMatch 按gRPC服务及方法匹配, 为空时匹配所有
+type GRPCRouteMatch struct {
+	Service  string            `json:"service,omitempty"`
+	Method   string            `json:"method,omitempty"`
+	Backends []WeightedBackend `json:"backends"`
+}
+
+// WeightedBackend 带权重的后端Service
+type WeightedBackend struct {
+	Service string `json:"service"`
+	Port    int32  `json:"port,omitempty"`
+	Weight  *int32 `json:"weight,omitempty"`
+}
+
+// IngressController ingress控制器类型, 用于生成控制器相关的默认注解
+// +kubebuilder:validation:Enum=nginx;traefik;alb
+type IngressController string
+
+const (
+	IngressControllerNginx   IngressController = "nginx"
+	IngressControllerTraefik IngressController = "traefik"
+	IngressControllerALB     IngressController = "alb"
+)
+
+// IngressPath ingress路径及后端
+type IngressPath struct {
+	Path string `json:"path"`
+	// 未配置时为Prefix
+	// +kubebuilder:validation:Enum=Prefix;Exact;ImplementationSpecific
+	PathType networkingv1.PathType `json:"pathType,omitempty"`
+	Backend  IngressBackend        `json:"backend"`
+}
+
+// IngressBackend 路径后端, service与app二选一
+type IngressBackend struct {
+	Service string `json:"service,omitempty"`
+	// 应用名称, 解析为该应用生成的Service, 端口名称解析为Service中的端口名称
+	App  string             `json:"app,omitempty"`
+	Port IngressBackendPort `json:"port,omitempty"`
+}
+
+// IngressBackendPort 端口号与端口名称二选一, 都未配置时使用portForHttp
+type IngressBackendPort struct {
+	Number int32  `json:"number,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+// IngressTLS 域名证书, 指定secretName或由cert-manager签发
+type IngressTLS struct {
+	// 证书secret名称, 使用issuer时默认为 <host>-tls; 同一个证书secret只能在一个ingress名称中配置issuer
+	SecretName string     `json:"secretName,omitempty"`
+	Issuer     *IssuerRef `json:"issuer,omitempty"`
+}
+
+// IssuerRef cert-manager的Issuer或ClusterIssuer
+type IssuerRef struct {
+	Name string `json:"name"`
+	// +kubebuilder:validation:Enum=Issuer;ClusterIssuer
+	Kind string `json:"kind,omitempty"`
+}
+
 type AppsName struct {
 	Name            string         `json:"name,omitempty"`
 	Replicas        *int32         `json:"replicas,omitempty"`
@@ -53,14 +178,153 @@ type AppsName struct {
 	ImageRegistry   string         `json:"imageRegistry,omitempty"`
 	RegistrySecrets string         `json:"registrySecrets,omitempty"`
 	Ports           []DefaultPorts `json:"ports,omitempty"`
+	// 调度配置, 覆盖spec中的同名配置, tolerations 与spec中的合并
+	Affinity                  *corev1.Affinity                  `json:"affinity,omitempty"`
+	Tolerations               []corev1.Toleration               `json:"tolerations,omitempty"`
+	NodeSelector              map[string]string                 `json:"nodeSelector,omitempty"`
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+	TopologySpreadPreset      TopologySpreadPreset              `json:"topologySpreadPreset,omitempty"`
+	// 附加容器及存储卷, volumeMounts 挂载到应用主容器
+	InitContainers []corev1.Container   `json:"initContainers,omitempty"`
+	Sidecars       []corev1.Container   `json:"sidecars,omitempty"`
+	SidecarRefs    []string             `json:"sidecarRefs,omitempty"`
+	Volumes        []corev1.Volume      `json:"volumes,omitempty"`
+	VolumeMounts   []corev1.VolumeMount `json:"volumeMounts,omitempty"`
+	// 持久化存储, 为每一项创建PVC并挂载到应用主容器
+	Storage []StorageSpec `json:"storage,omitempty"`
+	// 发布钩子, tag变化时执行
+	Hooks *AppHooks `json:"hooks,omitempty"`
+	// 应用Service的配置及额外的Service
+	Service  *AppServiceSpec  `json:"service,omitempty"`
+	Services []AppServiceSpec `json:"services,omitempty"`
+	// 暂停应用, Deployment副本数缩为0, 保留其余配置
+	Suspended bool `json:"suspended,omitempty"`
+	// 跟随镜像仓库中符合策略的最新tag, 自动更新appsList
+	ImageUpdate *ImageUpdateSpec `json:"imageUpdate,omitempty"`
+	// 覆盖spec.pinDigest
+	PinDigest *bool `json:"pinDigest,omitempty"`
+	// 依赖的应用, 依赖的Deployment发布完成后才创建或更新应用的Deployment
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// 指标采集, 安装了prometheus-operator时创建ServiceMonitor或PodMonitor, 否则在Pod上添加prometheus.io注解
+	Metrics *AppMetricsSpec `json:"metrics,omitempty"`
 }
+
+// MonitorKind 采集应用指标的prometheus-operator资源类型
+// +kubebuilder:validation:Enum=ServiceMonitor;PodMonitor
+type MonitorKind string
+
+const (
+	MonitorKindServiceMonitor MonitorKind = "ServiceMonitor"
+	MonitorKindPodMonitor     MonitorKind = "PodMonitor"
+)
+
+// RolloutPolicy 分批发布策略, 应用的Deployment发布完成后才开始发布下一个应用或下一波
+type RolloutPolicy struct {
+	// 同时发布的应用数量, 0表示不限制
+	// +kubebuilder:validation:Minimum=0
+	MaxConcurrentApps int32 `json:"maxConcurrentApps,omitempty"`
+	// 按顺序发布的波次, 前一波的应用全部发布完成后才开始下一波; 未列出的应用在最后一波发布
+	Waves []RolloutWave `json:"waves,omitempty"`
+}
+
+// RolloutWave 同一波发布的应用
+type RolloutWave struct {
+	Name string   `json:"name"`
+	Apps []string `json:"apps"`
+}
+
+// AppMetricsSpec 应用的指标端口及采集配置
+type AppMetricsSpec struct {
+	// 指标端口, ports中的端口名称, 如 http
+	Port string `json:"port"`
+	// 指标路径, 默认 /metrics
+	Path string `json:"path,omitempty"`
+	// 采集间隔, 默认使用prometheus的全局配置
+	Interval *metav1.Duration `json:"interval,omitempty"`
+	// 默认ServiceMonitor, 通过应用的Service采集; PodMonitor直接采集Pod
+	Kind MonitorKind `json:"kind,omitempty"`
+}
+
+// ImageUpdateSpec 镜像tag自动更新策略, semver与pattern二选一
+type ImageUpdateSpec struct {
+	// semver范围, 如 ">=1.2.0 <2.0.0"、"^1.2"、"~1.2.3", 空格分隔的条件需全部满足, 忽略预发布版本
+	Semver string `json:"semver,omitempty"`
+	// 正则表达式, 如 b\d+, 需匹配完整的tag, 匹配的tag按其中的数字排序
+	Pattern string `json:"pattern,omitempty"`
+	// 检查间隔, 默认5m; 开启pinDigest时同时作为digest的解析间隔
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// AppHooks 应用的发布钩子, 镜像默认使用应用自身的镜像及appsList中的tag
+type AppHooks struct {
+	// 执行成功后才更新Deployment, 失败时阻止发布
+	PreDeploy *JobSpec `json:"preDeploy,omitempty"`
+	// Deployment发布完成后执行
+	PostDeploy *JobSpec `json:"postDeploy,omitempty"`
+}
+
+// StorageSpec 应用的持久化存储, PVC名称为 <app>-<name>
+type StorageSpec struct {
+	Name         string                              `json:"name"`
+	Size         resource.Quantity                   `json:"size"`
+	StorageClass *string                             `json:"storageClass,omitempty"`
+	AccessModes  []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+	MountPath    string                              `json:"mountPath"`
+	// 应用从appsList中移除后保留PVC及数据
+	RetainOnDelete bool `json:"retainOnDelete,omitempty"`
+}
+
+// SidecarTemplate 命名的sidecar模版, 如日志采集、代理
+type SidecarTemplate struct {
+	InitContainers []corev1.Container `json:"initContainers,omitempty"`
+	Containers     []corev1.Container `json:"containers,omitempty"`
+	Volumes        []corev1.Volume    `json:"volumes,omitempty"`
+}
+
+// TopologySpreadPreset 预置的pod打散策略
+// +kubebuilder:validation:Enum=zone;hostname
+type TopologySpreadPreset string
+
+const (
+	// TopologySpreadZone 按可用区打散
+	TopologySpreadZone TopologySpreadPreset = "zone"
+	// TopologySpreadHostname 按节点打散
+	TopologySpreadHostname TopologySpreadPreset = "hostname"
+)
+
 type DeployStackServiceSpec struct {
 	Type  corev1.ServiceType  `json:"type,omitempty"`
-	Ports *corev1.ServicePort `json:"ports,omitempty"`
+	Ports *corev1.ServicePort `json:"ports,omitempty"` // Deprecated: use spec.ports or apps[].service.ports
+	// 应用Service的默认配置, apps[].service中的配置优先
+	Headless              bool                                    `json:"headless,omitempty"`
+	SessionAffinity       corev1.ServiceAffinity                  `json:"sessionAffinity,omitempty"`
+	SessionAffinityConfig *corev1.SessionAffinityConfig           `json:"sessionAffinityConfig,omitempty"`
+	ExternalTrafficPolicy corev1.ServiceExternalTrafficPolicyType `json:"externalTrafficPolicy,omitempty"`
+	Annotations           map[string]string                       `json:"annotations,omitempty"`
 }
+
+// AppServiceSpec 应用的Service配置, 未配置的字段使用spec.service中的配置
+// apps[].services 中的Service名称为 <app>-<name>, 选择同一应用的pod
+type AppServiceSpec struct {
+	Name string             `json:"name,omitempty"`
+	Type corev1.ServiceType `json:"type,omitempty"`
+	// clusterIP: None, clusterIP不可变, 已创建的Service需要删除重建
+	Headless *bool `json:"headless,omitempty"`
+	// 未配置时使用spec.ports及apps[].ports
+	Ports                 []DefaultPorts                          `json:"ports,omitempty"`
+	SessionAffinity       corev1.ServiceAffinity                  `json:"sessionAffinity,omitempty"`
+	SessionAffinityConfig *corev1.SessionAffinityConfig           `json:"sessionAffinityConfig,omitempty"`
+	ExternalTrafficPolicy corev1.ServiceExternalTrafficPolicyType `json:"externalTrafficPolicy,omitempty"`
+	Annotations           map[string]string                       `json:"annotations,omitempty"`
+}
+
 type DefaultPorts struct {
 	Name string `json:"name,omitempty"`
 	Port int32  `json:"port,omitempty"`
+	// Service端口配置, 未配置targetPort时与port相同, 为端口号时同时作为容器端口
+	Protocol   corev1.Protocol     `json:"protocol,omitempty"`
+	TargetPort *intstr.IntOrString `json:"targetPort,omitempty"`
+	NodePort   int32               `json:"nodePort,omitempty"`
 }
 type DeployStackOverrideSpec struct {
 	Deployment *Deployment `json:"depoyment,omitempty"`
@@ -87,6 +351,9 @@ type PodTemplateSpec struct {
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Rollout",type=string,JSONPath=`.status.rollout.progress`
+//+kubebuilder:printcolumn:name="Paused",type=boolean,JSONPath=`.status.paused`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // DeployStack is the Schema for the deploystacks API
 type DeployStack struct {
@@ -0,0 +1,84 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DeployStackPromotionSpec 将源DeployStack中appsList的tag晋级到目标DeployStack
+type DeployStackPromotionSpec struct {
+	// 源DeployStack, 所有应用发布完成后才能晋级
+	Source DeployStackReference `json:"source"`
+	// 目标DeployStack
+	Target DeployStackReference `json:"target"`
+	// 只晋级指定的应用, 为空时晋级源DeployStack appsList中的全部应用
+	Apps []string `json:"apps,omitempty"`
+	// 需要审批, approved为true后才更新目标DeployStack; 设置后不能关闭
+	RequireApproval bool `json:"requireApproval,omitempty"`
+	// 源DeployStack发布完成(phase为WaitingForApproval)后才能设置, 审批人由准入webhook记录
+	Approved bool `json:"approved,omitempty"`
+}
+
+// DeployStackReference DeployStack的名称, namespace默认与引用方相同
+type DeployStackReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// 晋级状态
+const (
+	PromotionPhaseWaitingForSource   = "WaitingForSource"
+	PromotionPhaseWaitingForApproval = "WaitingForApproval"
+	PromotionPhaseSucceeded          = "Succeeded"
+	PromotionPhaseFailed             = "Failed"
+)
+
+// 审批注解, 由准入webhook在spec.approved设置为true时写入, 用户设置的值会被忽略
+const (
+	ApprovedByAnnotation = "gopron.online/approved-by"
+	ApprovedAtAnnotation = "gopron.online/approved-at"
+)
+
+// DeployStackPromotionStatus defines the observed state of DeployStackPromotion
+type DeployStackPromotionStatus struct {
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message,omitempty"`
+	// 源DeployStack发布完成时记录的待晋级tag, 审批及晋级都以此为准
+	Tags map[string]string `json:"tags,omitempty"`
+	// 晋级前目标DeployStack中的tag, 新增的应用为空
+	PreviousTags map[string]string `json:"previousTags,omitempty"`
+	// 源DeployStack发布完成、记录tags的时间, 审批需在此之后
+	SourceReadyAt *metav1.Time `json:"sourceReadyAt,omitempty"`
+	// 审批人, 准入webhook记录的设置approved的用户
+	ApprovedBy string       `json:"approvedBy,omitempty"`
+	PromotedAt *metav1.Time `json:"promotedAt,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Source",type=string,JSONPath=`.spec.source.name`
+//+kubebuilder:printcolumn:name="Target",type=string,JSONPath=`.spec.target.name`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Promoted",type=date,JSONPath=`.status.promotedAt`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// DeployStackPromotion is the Schema for the deploystackpromotions API
+type DeployStackPromotion struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DeployStackPromotionSpec   `json:"spec,omitempty"`
+	Status DeployStackPromotionStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DeployStackPromotionList contains a list of DeployStackPromotion
+type DeployStackPromotionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DeployStackPromotion `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DeployStackPromotion{}, &DeployStackPromotionList{})
+}
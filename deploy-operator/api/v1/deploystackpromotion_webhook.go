@@ -0,0 +1,81 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const promotionWebhookPath = "/mutate-gopron-online-v1-deploystackpromotion"
+
+//+kubebuilder:webhook:path=/mutate-gopron-online-v1-deploystackpromotion,mutating=true,failurePolicy=fail,sideEffects=None,groups=gopron.online,resources=deploystackpromotions,verbs=create;update,versions=v1,name=mdeploystackpromotion.gopron.online,admissionReviewVersions=v1
+
+// PromotionApprovalWebhook 记录设置spec.approved的用户, 源DeployStack发布完成前不允许审批
+type PromotionApprovalWebhook struct {
+	decoder *admission.Decoder
+}
+
+func (w *PromotionApprovalWebhook) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register(promotionWebhookPath, &webhook.Admission{Handler: w})
+	return nil
+}
+
+// InjectDecoder 由webhook server注入
+func (w *PromotionApprovalWebhook) InjectDecoder(decoder *admission.Decoder) error {
+	w.decoder = decoder
+	return nil
+}
+
+func (w *PromotionApprovalWebhook) Handle(ctx context.Context, req admission.Request) admission.Response {
+	promotion := &DeployStackPromotion{}
+	if err := w.decoder.Decode(req, promotion); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	old := &DeployStackPromotion{}
+	if req.Operation == admissionv1.Update {
+		if err := w.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+	if old.Spec.RequireApproval && !promotion.Spec.RequireApproval {
+		return admission.Denied("spec.requireApproval cannot be turned off")
+	}
+
+	// 审批注解只能由webhook写入, 忽略用户设置的值
+	annotations := promotion.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	for _, key := range []string{ApprovedByAnnotation, ApprovedAtAnnotation} {
+		if value, ok := old.Annotations[key]; ok {
+			annotations[key] = value
+		} else {
+			delete(annotations, key)
+		}
+	}
+	switch {
+	case !promotion.Spec.RequireApproval || !promotion.Spec.Approved:
+		delete(annotations, ApprovedByAnnotation)
+		delete(annotations, ApprovedAtAnnotation)
+	case !old.Spec.Approved:
+		// 审批的是源DeployStack发布完成时记录的tag, 记录之前的审批无效
+		if old.Status.Tags == nil {
+			return admission.Denied("spec.approved can only be set after the source DeployStack is ready (phase WaitingForApproval)")
+		}
+		annotations[ApprovedByAnnotation] = req.UserInfo.Username
+		annotations[ApprovedAtAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	}
+	promotion.SetAnnotations(annotations)
+
+	data, err := json.Marshal(promotion)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, data)
+}
@@ -0,0 +1,156 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func newPromotionWebhook(t *testing.T) *PromotionApprovalWebhook {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &PromotionApprovalWebhook{}
+	if err := w.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func promotionRequest(t *testing.T, old, promotion *DeployStackPromotion) admission.Request {
+	t.Helper()
+	req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		UserInfo:  authenticationv1.UserInfo{Username: "alice"},
+	}}
+	var err error
+	if req.Object.Raw, err = json.Marshal(promotion); err != nil {
+		t.Fatal(err)
+	}
+	if old != nil {
+		req.Operation = admissionv1.Update
+		if req.OldObject.Raw, err = json.Marshal(old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return req
+}
+
+// patchedAnnotations 将响应中对metadata.annotations的patch应用到请求对象上
+func patchedAnnotations(t *testing.T, promotion *DeployStackPromotion, resp admission.Response) map[string]string {
+	t.Helper()
+	annotations := map[string]string{}
+	for key, value := range promotion.Annotations {
+		annotations[key] = value
+	}
+	for _, patch := range resp.Patches {
+		if patch.Path == "/metadata/annotations" {
+			annotations = map[string]string{}
+			if patch.Operation != "remove" {
+				for key, value := range patch.Value.(map[string]interface{}) {
+					annotations[key] = value.(string)
+				}
+			}
+			continue
+		}
+		if !strings.HasPrefix(patch.Path, "/metadata/annotations/") {
+			continue
+		}
+		key := strings.NewReplacer("~1", "/", "~0", "~").Replace(strings.TrimPrefix(patch.Path, "/metadata/annotations/"))
+		if patch.Operation == "remove" {
+			delete(annotations, key)
+		} else {
+			annotations[key] = patch.Value.(string)
+		}
+	}
+	return annotations
+}
+
+func TestPromotionApprovalWebhook(t *testing.T) {
+	waiting := func() *DeployStackPromotion {
+		promotion := &DeployStackPromotion{}
+		promotion.Name = "promote"
+		promotion.Spec.RequireApproval = true
+		promotion.Status.Tags = map[string]string{"hello": "v2"}
+		return promotion
+	}
+	approved := func(annotations map[string]string) *DeployStackPromotion {
+		promotion := waiting()
+		promotion.Spec.Approved = true
+		promotion.Annotations = annotations
+		return promotion
+	}
+	tests := []struct {
+		name       string
+		old        *DeployStackPromotion
+		promotion  *DeployStackPromotion
+		denied     bool
+		approvedBy string
+	}{
+		{name: "approved at creation", promotion: approved(nil), denied: true},
+		{
+			name: "approved before source ready",
+			old:  func() *DeployStackPromotion { p := waiting(); p.Status.Tags = nil; return p }(),
+			promotion: func() *DeployStackPromotion {
+				p := approved(nil)
+				p.Status.Tags = nil
+				return p
+			}(),
+			denied: true,
+		},
+		{name: "approved after source ready", old: waiting(), promotion: approved(nil), approvedBy: "alice"},
+		{name: "forged approver replaced", old: waiting(), promotion: approved(map[string]string{ApprovedByAnnotation: "bob"}), approvedBy: "alice"},
+		{
+			name:       "approver kept on later updates",
+			old:        approved(map[string]string{ApprovedByAnnotation: "carol", ApprovedAtAnnotation: "2023-01-01T00:00:00Z"}),
+			promotion:  approved(map[string]string{ApprovedByAnnotation: "bob", ApprovedAtAnnotation: "2023-01-01T00:00:00Z"}),
+			approvedBy: "carol",
+		},
+		{
+			name:      "approval withdrawn",
+			old:       approved(map[string]string{ApprovedByAnnotation: "carol", ApprovedAtAnnotation: "2023-01-01T00:00:00Z"}),
+			promotion: waiting(),
+		},
+		{name: "forged approver without approval", promotion: func() *DeployStackPromotion {
+			p := waiting()
+			p.Annotations = map[string]string{ApprovedByAnnotation: "bob", ApprovedAtAnnotation: "2023-01-01T00:00:00Z"}
+			return p
+		}()},
+		{
+			name:      "requireApproval turned off",
+			old:       waiting(),
+			promotion: func() *DeployStackPromotion { p := waiting(); p.Spec.RequireApproval = false; return p }(),
+			denied:    true,
+		},
+	}
+	w := newPromotionWebhook(t)
+	for _, test := range tests {
+		resp := w.Handle(context.Background(), promotionRequest(t, test.old, test.promotion))
+		if resp.Allowed == test.denied {
+			t.Errorf("%s: allowed = %v, want %v (%v)", test.name, resp.Allowed, !test.denied, resp.Result)
+			continue
+		}
+		if test.denied {
+			continue
+		}
+		annotations := patchedAnnotations(t, test.promotion, resp)
+		if got := annotations[ApprovedByAnnotation]; got != test.approvedBy {
+			t.Errorf("%s: approved-by = %q, want %q", test.name, got, test.approvedBy)
+		}
+		if _, ok := annotations[ApprovedAtAnnotation]; ok != (test.approvedBy != "") {
+			t.Errorf("%s: approved-at = %q", test.name, annotations[ApprovedAtAnnotation])
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/render"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/resource"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// errChanges 存在变更时以退出码1结束, 出错时退出码为2, 与 kubectl diff 一致
+var errChanges = errors.New("changes found")
+
+// runDiff 比较DeployStack生成的资源与集群或YAML目录中的资源
+func runDiff(args []string, stdout io.Writer) error {
+	var (
+		file        string
+		dir         string
+		kubeconfig  string
+		kubeContext string
+		showSecrets bool
+		opts        render.Options
+	)
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	flags.StringVar(&file, "f", "", "DeployStack YAML or JSON file, - for stdin.")
+	flags.StringVar(&dir, "dir", "", "Compare with the objects exported to this directory instead of a cluster.")
+	flags.StringVar(&kubeconfig, "kubeconfig", "", "Path to the kubeconfig file, defaults to $KUBECONFIG or ~/.kube/config.")
+	flags.StringVar(&kubeContext, "context", "", "The kubeconfig context to use.")
+	flags.BoolVar(&showSecrets, "show-secrets", false, "Print Secret values instead of masking them.")
+	flags.BoolVar(&opts.CertManager, "cert-manager", false, "Render cert-manager Certificates as if the CRD were installed.")
+	flags.BoolVar(&opts.PrometheusOperator, "prometheus-operator", false, "Render ServiceMonitors and PodMonitors as if the prometheus-operator CRDs were installed.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	reader, err := openFile(file)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	deployStacks, err := render.Decode(reader)
+	if err != nil {
+		return err
+	}
+
+	var source render.Source
+	if dir != "" {
+		if source, err = render.LoadDir(dir); err != nil {
+			return err
+		}
+	} else {
+		config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig, Precedence: clientcmd.NewDefaultClientConfigLoadingRules().Precedence},
+			&clientcmd.ConfigOverrides{CurrentContext: kubeContext},
+		).ClientConfig()
+		if err != nil {
+			return err
+		}
+		kubeClient, err := client.New(config, client.Options{Scheme: render.Scheme})
+		if err != nil {
+			return err
+		}
+		// 与operator一样根据集群中的CRD决定是否生成Certificate、ServiceMonitor及PodMonitor
+		installed := func(gvk schema.GroupVersionKind) bool {
+			_, err := kubeClient.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+			return err == nil
+		}
+		opts.CertManager = opts.CertManager || installed(resource.CertificateGVK)
+		opts.PrometheusOperator = opts.PrometheusOperator || (installed(resource.ServiceMonitorGVK) && installed(resource.PodMonitorGVK))
+		ingressClassList := &networkingv1.IngressClassList{}
+		if err := kubeClient.List(context.Background(), ingressClassList); err != nil {
+			return err
+		}
+		opts.IngressClassControllers = map[string]string{}
+		for _, ingressClass := range ingressClassList.Items {
+			opts.IngressClassControllers[ingressClass.Name] = ingressClass.Spec.Controller
+		}
+		source = &render.ClusterSource{Client: kubeClient}
+	}
+
+	ctx := context.Background()
+	found := false
+	for _, deployStack := range deployStacks {
+		objects, err := render.Render(deployStack, opts)
+		if err != nil {
+			return err
+		}
+		changes, err := render.Diff(ctx, deployStack, objects, source)
+		if err != nil {
+			return err
+		}
+		if err := render.WriteDiff(stdout, changes, showSecrets); err != nil {
+			return err
+		}
+		found = found || len(changes) > 0
+	}
+	if found {
+		return errChanges
+	}
+	return nil
+}
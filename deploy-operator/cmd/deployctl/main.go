@@ -0,0 +1,88 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// deployctl 不启动operator, 在本地处理DeployStack配置
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// command deployctl的子命令
+type command struct {
+	usage string
+	run   func(args []string, stdout io.Writer) error
+}
+
+var commands = map[string]command{
+	"render": {usage: "render DeployStack manifests without a cluster", run: runRender},
+	"diff":   {usage: "show what the operator would create, update or prune", run: runDiff},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run 执行子命令并返回退出码: 0成功或输出帮助, 1存在变更, 2出错
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) > 0 && (args[0] == "-h" || args[0] == "--help" || args[0] == "help") {
+		usage(stdout)
+		return 0
+	}
+	if len(args) < 1 || commands[args[0]].run == nil {
+		usage(stderr)
+		return 2
+	}
+	err := commands[args[0]].run(args[1:], stdout)
+	switch {
+	case err == nil || errors.Is(err, flag.ErrHelp):
+		// -h 时flag包已输出子命令的用法
+		return 0
+	case err == errChanges:
+		return 1
+	default:
+		fmt.Fprintf(stderr, "deployctl %s: %s\n", args[0], err)
+		return 2
+	}
+}
+
+func usage(writer io.Writer) {
+	fmt.Fprintf(writer, "Usage: deployctl <command> [flags]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(writer, "  %-8s %s\n", name, commands[name].usage)
+	}
+}
+
+// openFile 读取 -f 指定的文件, "-" 表示标准输入
+func openFile(path string) (io.ReadCloser, error) {
+	if path == "" {
+		return nil, fmt.Errorf("-f is required")
+	}
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
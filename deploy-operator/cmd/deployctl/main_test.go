@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sample = "../../config/samples/deploystack.yaml"
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		code     int
+		stdout   string
+		stderr   string
+		noStdout bool
+	}{
+		{name: "no command", code: 2, stderr: "Usage: deployctl", noStdout: true},
+		{name: "unknown command", args: []string{"apply"}, code: 2, stderr: "Usage: deployctl", noStdout: true},
+		{name: "help", args: []string{"-h"}, code: 0, stdout: "Usage: deployctl"},
+		{name: "long help", args: []string{"--help"}, code: 0, stdout: "render"},
+		{name: "help command", args: []string{"help"}, code: 0, stdout: "diff"},
+		{name: "command help", args: []string{"render", "-h"}, code: 0},
+		{name: "unknown flag", args: []string{"render", "-x"}, code: 2, stderr: "deployctl render"},
+		{name: "missing file", args: []string{"render"}, code: 2, stderr: "-f is required"},
+		{name: "render", args: []string{"render", "-f", sample}, code: 0, stdout: "kind: Deployment"},
+		{name: "diff without file", args: []string{"diff", "--dir", t.TempDir()}, code: 2, stderr: "-f is required"},
+		{name: "diff with changes", args: []string{"diff", "-f", sample, "--dir", t.TempDir()}, code: 1, stdout: "+ create Deployment"},
+	}
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		if code := run(test.args, &stdout, &stderr); code != test.code {
+			t.Errorf("%s: exit code = %d, want %d (stderr: %s)", test.name, code, test.code, stderr.String())
+		}
+		if !strings.Contains(stdout.String(), test.stdout) {
+			t.Errorf("%s: stdout = %q, want %q", test.name, stdout.String(), test.stdout)
+		}
+		if !strings.Contains(stderr.String(), test.stderr) {
+			t.Errorf("%s: stderr = %q, want %q", test.name, stderr.String(), test.stderr)
+		}
+		if test.noStdout && stdout.Len() > 0 {
+			t.Errorf("%s: unexpected stdout %q", test.name, stdout.String())
+		}
+	}
+}
+
+// TestDiffRendered render输出的资源与diff比较时无变更
+func TestDiffRendered(t *testing.T) {
+	var rendered, stderr bytes.Buffer
+	if code := run([]string{"render", "-f", sample, "--show-secrets"}, &rendered, &stderr); code != 0 {
+		t.Fatalf("render exit code = %d: %s", code, stderr.String())
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "live.yaml"), rendered.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	if code := run([]string{"diff", "-f", sample, "--dir", dir}, &stdout, &stderr); code != 0 {
+		t.Errorf("diff exit code = %d, want 0; stdout: %s stderr: %s", code, stdout.String(), stderr.String())
+	}
+}
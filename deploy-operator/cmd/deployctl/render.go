@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"io"
+
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/render"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// runRender 读取DeployStack, 输出生成的全部资源, Secret默认脱敏
+func runRender(args []string, stdout io.Writer) error {
+	var (
+		file        string
+		output      string
+		showSecrets bool
+		opts        render.Options
+	)
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	flags.StringVar(&file, "f", "", "DeployStack YAML or JSON file, - for stdin.")
+	flags.StringVar(&output, "o", render.FormatYAML, "Output format: yaml or json.")
+	flags.BoolVar(&showSecrets, "show-secrets", false, "Print Secret values instead of masking them.")
+	flags.BoolVar(&opts.CertManager, "cert-manager", false, "Render cert-manager Certificates as if the CRD were installed.")
+	flags.BoolVar(&opts.PrometheusOperator, "prometheus-operator", false, "Render ServiceMonitors and PodMonitors as if the prometheus-operator CRDs were installed.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	reader, err := openFile(file)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	deployStacks, err := render.Decode(reader)
+	if err != nil {
+		return err
+	}
+	var objects []client.Object
+	for _, deployStack := range deployStacks {
+		resourceObjs, err := render.Render(deployStack, opts)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, resourceObjs...)
+	}
+	if !showSecrets {
+		objects = render.MaskSecrets(objects)
+	}
+	return render.Write(stdout, objects, output)
+}
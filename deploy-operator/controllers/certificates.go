@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/resource"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// 证书签发中的重新调谐间隔
+const certificateRequeueInterval = 30 * time.Second
+
+// newResourceBuilder 根据集群中已安装的CRD及IngressClass初始化DeployStackBuild
+func (r *DeployStackReconciler) newResourceBuilder(ctx context.Context, deployStack *apiv1.DeployStack) *resource.DeployStackBuild {
+	return &resource.DeployStackBuild{
+		Instance:    deployStack,
+		Scheme:      r.Scheme,
+		CertManager: r.crdInstalled(resource.CertificateGVK),
+		// 未安装时在Pod上添加prometheus.io注解
+		PrometheusOperator:      r.crdInstalled(resource.ServiceMonitorGVK) && r.crdInstalled(resource.PodMonitorGVK),
+		IngressClassControllers: r.ingressClassControllers(ctx),
+	}
+}
+
+// ingressClassControllers 集群中IngressClass名称及其spec.controller
+func (r *DeployStackReconciler) ingressClassControllers(ctx context.Context) map[string]string {
+	ingressClassList := &networkingv1.IngressClassList{}
+	if err := r.List(ctx, ingressClassList); err != nil {
+		r.Log.Error(err, "Failed to list IngressClasses")
+		return nil
+	}
+	controllers := map[string]string{}
+	for _, ingressClass := range ingressClassList.Items {
+		controllers[ingressClass.Name] = ingressClass.Spec.Controller
+	}
+	return controllers
+}
+
+// crdInstalled 集群中是否已安装该资源类型
+func (r *DeployStackReconciler) crdInstalled(gvk schema.GroupVersionKind) bool {
+	if _, err := r.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+		if !meta.IsNoMatchError(err) {
+			r.Log.Error(err, "Failed to discover resource", "Kind", gvk.String())
+		}
+		return false
+	}
+	return true
+}
+
+// certificateStatuses 汇总ingress域名证书状态, 返回是否有cert-manager证书正在签发
+func (r *DeployStackReconciler) certificateStatuses(ctx context.Context, resourceBuilder *resource.DeployStackBuild) ([]apiv1.CertificateStatus, bool, error) {
+	var (
+		statuses []apiv1.CertificateStatus
+		pending  bool
+	)
+	namespace := resourceBuilder.Instance.Spec.Namespace
+	for _, name := range resourceBuilder.IngressNames() {
+		for _, secret := range resourceBuilder.TLSSecrets(name) {
+			var (
+				ready   bool
+				message string
+				err     error
+			)
+			if resourceBuilder.CertManager && secret.Issuer != nil {
+				ready, message, err = r.certificateReady(ctx, namespace, secret.SecretName)
+				pending = pending || !ready
+			} else {
+				ready, message, err = r.tlsSecretReady(ctx, namespace, secret.SecretName)
+			}
+			if err != nil {
+				return nil, false, err
+			}
+			for _, host := range secret.Hosts {
+				statuses = append(statuses, apiv1.CertificateStatus{
+					Host:       host,
+					SecretName: secret.SecretName,
+					Ready:      ready,
+					Message:    message,
+				})
+			}
+		}
+	}
+	return statuses, pending, nil
+}
+
+// certificateReady cert-manager Certificate的Ready状态
+func (r *DeployStackReconciler) certificateReady(ctx context.Context, namespace, name string) (bool, string, error) {
+	certificate := &unstructured.Unstructured{}
+	certificate.SetGroupVersionKind(resource.CertificateGVK)
+	if err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, certificate); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			return false, "", err
+		}
+		return false, "Certificate not found", nil
+	}
+	conditions, _, _ := unstructured.NestedSlice(certificate.Object, "status", "conditions")
+	for _, condition := range conditions {
+		condition, ok := condition.(map[string]interface{})
+		if !ok || condition["type"] != "Ready" {
+			continue
+		}
+		message, _ := condition["message"].(string)
+		return condition["status"] == string(corev1.ConditionTrue), message, nil
+	}
+	return false, "Certificate is being issued", nil
+}
+
+// tlsSecretReady 证书secret已存在
+func (r *DeployStackReconciler) tlsSecretReady(ctx context.Context, namespace, name string) (bool, string, error) {
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, secret); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			return false, "", err
+		}
+		return false, "Secret not found", nil
+	}
+	if len(secret.Data[corev1.TLSCertKey]) == 0 {
+		return false, "Secret has no " + corev1.TLSCertKey, nil
+	}
+	return true, "", nil
+}
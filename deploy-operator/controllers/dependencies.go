@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"strings"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/resource"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// dependencyCycleMessage 依赖成环时应用状态中的说明
+func dependencyCycleMessage(cycle []string) string {
+	return "dependency cycle: " + strings.Join(cycle, " -> ")
+}
+
+// reportDependencyCycle 依赖成环时产生事件, 环未变化时不重复产生
+func (r *DeployStackReconciler) reportDependencyCycle(deployStack *apiv1.DeployStack, cycle []string) {
+	if len(cycle) == 0 {
+		return
+	}
+	message := dependencyCycleMessage(cycle)
+	if deployStack.Status.Apps[cycle[0]].Message == message {
+		return
+	}
+	r.Recorder.Event(deployStack, corev1.EventTypeWarning, "DependencyCycle", message)
+}
+
+// waitForDependencies 应用的依赖未就绪时将状态设为WaitingForDependency, 返回是否需要等待及是否需要重新调谐
+// deployed 为本次调谐中已发布且钩子执行完成的应用及其tag; 依赖不在appsList中时只能修改spec解决, 无需重新调谐
+// 应用在依赖环中或依赖环中的应用时返回无需重试的错误, 应用状态为Failed
+func (r *DeployStackReconciler) waitForDependencies(ctx context.Context, resourceBuilder *resource.DeployStackBuild, name string, deployed map[string]string, appStatus *apiv1.AppStatus) (bool, bool, error) {
+	if cycle := resourceBuilder.DependencyCycle(name); cycle != nil {
+		message := dependencyCycleMessage(cycle)
+		if cycle[0] != name {
+			message = "blocked by " + message
+		}
+		return false, false, permanent(goerrors.New(message))
+	}
+	var missing, waiting []string
+	for _, dependency := range resourceBuilder.DependsOn(name) {
+		if _, ok := resourceBuilder.Instance.Spec.AppsList[dependency]; !ok {
+			missing = append(missing, dependency)
+			continue
+		}
+		ready, err := r.dependencyReady(ctx, resourceBuilder, dependency, deployed)
+		if err != nil {
+			return false, false, err
+		}
+		if !ready {
+			waiting = append(waiting, dependency)
+		}
+	}
+	if len(missing) == 0 && len(waiting) == 0 {
+		return false, false, nil
+	}
+	appStatus.Phase = apiv1.AppPhaseWaitingForDependency
+	if len(missing) > 0 {
+		appStatus.Message = fmt.Sprintf("dependencies not in appsList: %s", strings.Join(missing, ", "))
+		return true, false, nil
+	}
+	appStatus.Message = fmt.Sprintf("waiting for dependencies: %s", strings.Join(waiting, ", "))
+	return true, true, nil
+}
+
+// dependencyReady 依赖的Deployment已按当前tag发布完成, 暂停或等待中的应用未就绪
+func (r *DeployStackReconciler) dependencyReady(ctx context.Context, resourceBuilder *resource.DeployStackBuild, name string, deployed map[string]string) (bool, error) {
+	tag, ok := deployed[name]
+	if !ok {
+		return false, nil
+	}
+	deploy := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: resourceBuilder.Instance.Spec.Namespace, Name: name}, deploy); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return resourceBuilder.TagDeployed(deploy, name, tag) && deploymentRolledOut(deploy), nil
+}
@@ -3,21 +3,30 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"reflect"
+	"time"
 
 	"github.com/go-logr/logr"
 	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/registry"
 	"github.com/tiamxu/k8s-operator/deploy-operator/internal/resource"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/networking/v1"
+	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 )
 
 // var (
@@ -31,11 +40,24 @@ type DeployStackReconciler struct {
 	Log      logr.Logger
 	Scheme   *runtime.Scheme
 	Recorder record.EventRecorder
+	// 镜像仓库客户端, 为空时不执行apps[name].imageUpdate
+	Registry registry.Client
+	// 同时调谐的DeployStack数量, 为0时使用controller-runtime的默认值1
+	MaxConcurrentReconciles int
+	// 单个DeployStack中同时调谐的应用数量, 为0时使用defaultAppWorkers
+	AppWorkers int
 }
 
 //+kubebuilder:rbac:groups=gopron.online,resources=deploystacks,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=gopron.online,resources=deploystacks/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=gopron.online,resources=deploystacks/finalizers,verbs=update
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=batch,resources=jobs;cronjobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingressclasses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes;grpcroutes,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=monitoring.coreos.com,resources=servicemonitors;podmonitors,verbs=get;list;watch;create;update;patch;delete
 
 func (r *DeployStackReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	// ctx = context.Background()
@@ -46,12 +68,31 @@ func (r *DeployStackReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		// 如果资源不存在，则忽略
 		if errors.IsNotFound(err) {
 			logger.Error(err, "Not Found DeployStack Resource ,Please Create Kind DeployStack resource")
+			forgetDeployStackMetrics(req.NamespacedName)
 			return ctrl.Result{}, client.IgnoreNotFound(err)
 		}
 		logger.Error(err, "Failed to get DeployStack resource")
 		return ctrl.Result{}, err
 	}
 	logger.Info("Kind DeployStack Resource Normal...") //说明deploystack Kind已经创建
+	ctx = withDeployStack(ctx, req.NamespacedName)
+	defer observeReconcile(req.NamespacedName, time.Now())
+	// 暂停时只更新状态, 不写入任何资源; reconcile-at 注解变化时执行一次调谐
+	reconcileAt := deployStackInstance.Annotations[apiv1.ReconcileRequestAnnotation]
+	reconcileRequested := reconcileAt != "" && reconcileAt != deployStackInstance.Status.LastHandledReconcileAt
+	if deployStackInstance.Spec.Paused && !reconcileRequested {
+		logger.Info("DeployStack is paused, skip reconciling")
+		if !deployStackInstance.Status.Paused {
+			r.Recorder.Event(deployStackInstance, corev1.EventTypeNormal, "Paused", "Reconciling is paused")
+		}
+		status := deployStackInstance.Status.DeepCopy()
+		status.Paused = true
+		if err := r.updateStatus(ctx, deployStackInstance, status); err != nil {
+			logger.Error(err, "Failed to update DeployStack status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
 	logger.Info("Start reconciling")
 
 	//序列化depoystack 配置
@@ -63,96 +104,424 @@ func (r *DeployStackReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 	//声明并初始化一个DeployStackBuild的结构体变量
 	// deploymentBuilder = resource.DeployStackBuild{Instance: deployStackInstance, Scheme: r.Scheme}
-	resourceBuilder := resource.DeployStackBuild{Instance: deployStackInstance, Scheme: r.Scheme}
+	resourceBuilder := r.newResourceBuilder(ctx, deployStackInstance)
+	//镜像tag自动更新, 更新appsList后按新的tag调谐
+	imageStatuses, imageRequeue, imageErrs := r.updateImageTags(ctx, resourceBuilder)
 
 	appList := deployStackInstance.Spec.AppsList
-	namespace := deployStackInstance.Spec.Namespace
 	if appList == nil {
 		// appList = map[string]string{"test": "latest"}
 		return ctrl.Result{}, nil
 	}
-	for name, tag := range appList {
-		builders := resourceBuilder.ResourceBuilds()
-		fondResourceName := name
-		var resources client.Object
-		for _, builder := range builders {
-			//获取对于资源类型
-			if resources, err = builder.GetObjectKind(); err != nil {
-				return ctrl.Result{}, err
+	appStatuses := map[string]apiv1.AppStatus{}
+	requeue := false
+	// 按依赖关系排序, 依赖在前, 其余按应用名称排序, 保证每次调谐的执行顺序一致
+	order, cycle := resourceBuilder.RolloutOrder()
+	r.reportDependencyCycle(deployStackInstance, cycle)
+	// 回滚生效时发布回滚版本的tag
+	tags := map[string]string{}
+	for _, name := range order {
+		appStatus := apiv1.AppStatus{}
+		tags[name] = r.appRevisionTag(deployStackInstance, name, appList[name], &appStatus)
+		appStatus.Image = imageStatuses[name]
+		imageRequeue = minRequeue(imageRequeue, r.resolveDigest(ctx, resourceBuilder, name, tags[name], &appStatus))
+		appStatuses[name] = appStatus
+	}
+	// 分批发布, 未轮到的应用暂不发布
+	plan := r.planRollout(ctx, resourceBuilder, order, tags)
+	// 镜像tag更新失败的应用按当前tag调谐, 无法获取发布状态的应用暂不发布, 错误记录在应用状态中
+	appErrs := map[string]error{}
+	for _, planErrs := range []map[string]error{imageErrs, plan.errs} {
+		for name, err := range planErrs {
+			appErrs[name] = err
+		}
+	}
+	// 本次调谐中已发布的应用及其tag, 用于判断依赖是否就绪
+	deployed := map[string]string{}
+	// 单个应用失败时继续调谐其余应用, 错误记录在应用状态中, 汇总后返回
+	var errs []error
+	// 应用共用的配置在调谐应用之前创建或更新
+	if len(order) > 0 {
+		if err := r.reconcileShared(ctx, resourceBuilder, order[0], tags[order[0]]); err != nil {
+			logger.Error(err, "Failed to reconcile shared resources")
+			errs = append(errs, fmt.Errorf("shared: %w", err))
+		}
+	}
+	results := r.reconcileApps(ctx, resourceBuilder, order, tags, appStatuses, deployed, plan)
+	for _, name := range order {
+		result := results[name]
+		appStatus := result.status
+		appErr := result.err
+		if appErr == nil {
+			appErr = appErrs[name]
+		}
+		if appErr != nil {
+			logger.Error(appErr, "Failed to reconcile app", "Name", name)
+			r.setAppError(deployStackInstance, name, appErr, &appStatus)
+			errs = append(errs, fmt.Errorf("app %s: %w", name, appErr))
+		}
+		requeue = requeue || result.requeue
+		appStatuses[name] = appStatus
+		if reflect.DeepEqual(appStatus, apiv1.AppStatus{}) {
+			delete(appStatuses, name)
+		}
+		logger.Info("#####end分割线####", "Name", name)
+	}
+	//Ingress及路由, 按ingress[].name汇总, 与应用无关
+	if err := r.reconcileIngresses(ctx, resourceBuilder); err != nil {
+		logger.Error(err, "Failed to reconcile DeployStack ingress")
+		errs = append(errs, fmt.Errorf("ingress: %w", err))
+	}
+	//批处理任务
+	if err := r.reconcileBatch(ctx, resourceBuilder); err != nil {
+		logger.Error(err, "Failed to reconcile DeployStack jobs")
+		errs = append(errs, fmt.Errorf("jobs: %w", err))
+	}
+	//删除多余服务
+	if err := r.resourcesDelete(ctx, deployStackInstance); err != nil {
+		logger.Error(err, "Failed to Delete DeployStack resource")
+		errs = append(errs, fmt.Errorf("delete: %w", err))
+	}
+	rollingOut, err := r.recordAppMetrics(ctx, deployStackInstance)
+	if err != nil {
+		logger.Error(err, "Failed to record app metrics")
+		errs = append(errs, err)
+	}
+	certificates, certificatePending, err := r.certificateStatuses(ctx, resourceBuilder)
+	if err != nil {
+		logger.Error(err, "Failed to get certificate status")
+		errs = append(errs, err)
+		certificates = deployStackInstance.Status.Certificates
+	}
+	status := deployStackInstance.Status.DeepCopy()
+	status.Apps = appStatuses
+	if len(appStatuses) == 0 {
+		status.Apps = nil
+	}
+	status.Certificates = certificates
+	status.Rollout = plan.status
+	status.Paused = deployStackInstance.Spec.Paused
+	if reconcileAt != "" {
+		status.LastHandledReconcileAt = reconcileAt
+	}
+	if err := r.updateStatus(ctx, deployStackInstance, status); err != nil {
+		logger.Error(err, "Failed to update DeployStack status")
+		return ctrl.Result{}, err
+	}
+	// 暂时性错误按退避时间重试; 其余错误需要修改spec, spec变化时会重新调谐
+	transient, permanentErrs := splitErrors(errs)
+	if len(permanentErrs) > 0 {
+		logger.Error(utilerrors.NewAggregate(permanentErrs), "Reconcile failed, waiting for spec changes")
+	}
+	if len(transient) > 0 {
+		return ctrl.Result{}, utilerrors.NewAggregate(transient)
+	}
+	requeueAfter := imageRequeue
+	if requeue {
+		requeueAfter = minRequeue(requeueAfter, hookRequeueInterval)
+	}
+	if certificatePending {
+		requeueAfter = minRequeue(requeueAfter, certificateRequeueInterval)
+	}
+	// spec.namespace与DeployStack不在同一命名空间时无法设置ownerReference, Owns(&appsv1.Deployment{})收不到Deployment的状态变化;
+	// 同一命名空间时Deployment的状态变化也会触发调谐, 定期检查只是兜底, 发布中按rolloutRequeueInterval检查进度
+	if rollingOut {
+		requeueAfter = minRequeue(requeueAfter, rolloutRequeueInterval)
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// reconcileApp 创建或更新应用的资源并执行发布钩子, 返回应用是否已按tag发布完成及是否需要重新调谐
+func (r *DeployStackReconciler) reconcileApp(ctx context.Context, resourceBuilder *resource.DeployStackBuild, name, tag string, appStatus *apiv1.AppStatus, deployed map[string]string, plan *rolloutPlan) (bool, bool, error) {
+	logger := r.Log.WithValues("DeployStack", client.ObjectKeyFromObject(resourceBuilder.Instance), "App", name)
+	deployStackInstance := resourceBuilder.Instance
+	namespace := deployStackInstance.Spec.Namespace
+	requeue := false
+	var err error
+	builders := resourceBuilder.ResourceBuilds()
+	var resources client.Object
+	// 发布前钩子, 未执行成功时不更新Deployment
+	// 暂停的应用只缩容, 不执行发布钩子
+	suspended := resourceBuilder.Suspended(name)
+	hookDone := true
+	// 依赖未就绪时不执行钩子及更新Deployment
+	var waiting, waitingRequeue bool
+	if suspended {
+		appStatus.Phase = apiv1.AppPhaseSuspended
+	} else if waiting, waitingRequeue, err = r.waitForDependencies(ctx, resourceBuilder, name, deployed, appStatus); err != nil {
+		return false, false, err
+	} else if waiting {
+		hookDone = false
+		requeue = waitingRequeue
+	} else if reason, ok := plan.queued[name]; ok {
+		appStatus.Phase = apiv1.AppPhaseRolloutQueued
+		appStatus.Message = reason
+		hookDone = false
+	} else if hookDone, err = r.preDeployHook(ctx, resourceBuilder, name, tag, appStatus); err != nil {
+		return false, false, err
+	}
+	for _, builder := range builders {
+		// 每个应用对应多个同类资源, 如PVC
+		if listBuilder, ok := builder.(resource.ResourceListBuilder); ok {
+			if err := r.reconcileList(ctx, deployStackInstance, listBuilder, name, tag); err != nil {
+				return false, false, err
 			}
+			continue
+		}
+		//获取对于资源类型
+		if resources, err = builder.GetObjectKind(); err != nil {
+			return false, false, err
+		}
+		if _, ok := resources.(*appsv1.Deployment); ok && !hookDone {
+			continue
+		}
 
-			if _, ok := resources.(*corev1.ConfigMap); ok {
-				fondResourceName = "global-config"
+		// global-config、global-secret 由所有应用共用, 在调谐应用之前由reconcileShared创建或更新
+		switch resources.(type) {
+		case *corev1.ConfigMap, *corev1.Secret:
+			continue
+		}
+		//获取对于资源类型currentResourcesObj:= &appsv1.Deployment{}
+		// resourceObj, ok := r.getObjectKind(resources)
+		// if !ok {
+		// 	continue
+		// }
+		// logger.Info("Begin Fetch getResourceObj")
+		currentResourceObj, err := r.getResourceObj(ctx, namespace, name, resources)
+		if client.IgnoreNotFound(err) != nil {
+			return false, false, err
+		}
+		// 如果 对于 资源对象不存在，则创建
+		resourceObj := resources
+		if errors.IsNotFound(err) {
+			logger.Info("NotFound Resource for DeployStack, Create one", "Name", name, "Kind", reflect.TypeOf(resourceObj))
+			//Create Resource
+			if resourceObj, err = builder.Build(name, tag); err != nil {
+				return false, false, r.builderError(ctx, builder, err)
+			}
+			if err := r.Client.Create(ctx, resourceObj); err != nil {
+				logger.Error(err, "Create Resource  Failed", "Name", name, "Kind", reflect.TypeOf(resourceObj))
+				return false, false, err
+			}
+			r.Recorder.Eventf(resourceObj, corev1.EventTypeNormal, "Created", "Created resource %T", resourceObj)
+		} else {
+			logger.Info("Kind  resource already", "Name", name, "Kind", reflect.TypeOf(currentResourceObj))
+			// 如果资源对象存在，且需要更新，则更新
+			var newResourceObj client.Object
+			oldResourceObj := currentResourceObj.DeepCopyObject()
+			if newResourceObj, err = builder.Update(currentResourceObj, name, tag); err != nil {
+				return false, false, r.builderError(ctx, builder, err)
+			}
+			if reflect.DeepEqual(oldResourceObj, newResourceObj) {
+				continue
+			}
+			if err := r.Client.Update(ctx, newResourceObj); err != nil {
+				logger.Error(err, "Update Resource  Failed", "Name", name, "Kind", reflect.TypeOf(newResourceObj))
+				return false, false, err
+			}
+			logger.Info("Kind Resource Updated", "Name", name, "Kind", reflect.TypeOf(newResourceObj))
+			r.Recorder.Eventf(newResourceObj, corev1.EventTypeNormal, "Update", "Update Resource %T", newResourceObj)
 
-			} else if _, ok := resources.(*corev1.Secret); ok {
-				fondResourceName = "global-secret"
+		}
+	}
+	// Deployment已更新时记录发布版本, 超过发布期限时自动回滚
+	if hookDone {
+		if err := r.recordRevision(resourceBuilder, name, tag, appStatus); err != nil {
+			return false, false, err
+		}
+		rolledBack, err := r.autoRollback(ctx, resourceBuilder, name, tag, appStatus)
+		if err != nil {
+			return false, false, err
+		}
+		if rolledBack {
+			requeue = true
+			hookDone = false
+		}
+	}
+	//发布后钩子
+	if hookDone && !suspended {
+		if hookDone, err = r.postDeployHook(ctx, resourceBuilder, name, tag, appStatus); err != nil {
+			return false, false, err
+		}
+	}
+	if !hookDone && !waiting && appStatus.Phase != apiv1.AppPhasePreDeployHookFailed && appStatus.Phase != apiv1.AppPhasePostDeployHookFailed {
+		requeue = true
+	}
+	return hookDone && !suspended, requeue, nil
+}
 
-			} else if _, ok := resources.(*v1.Ingress); ok {
-				for _, ingress := range resourceBuilder.Instance.Spec.Ingress {
-					if ingress.Name == name {
-						fondResourceName = resource.StringCombin(name, "-", "ingress")
-						break
-					}
+// reconcileShared 创建或更新所有应用共用的global-config、global-secret, 与应用无关, 使用任一应用生成
+func (r *DeployStackReconciler) reconcileShared(ctx context.Context, resourceBuilder *resource.DeployStackBuild, name, tag string) error {
+	for _, builder := range []resource.ResourceBuilder{resourceBuilder.ConfigMap(), resourceBuilder.Secret()} {
+		resourceObj, err := builder.Build(name, tag)
+		if err != nil {
+			return r.builderError(ctx, builder, err)
+		}
+		if err := r.applyObject(ctx, builder, resourceObj, name, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// updateStatus status变化时更新DeployStack
+func (r *DeployStackReconciler) updateStatus(ctx context.Context, deployStack *apiv1.DeployStack, status *apiv1.DeployStackStatus) error {
+	if reflect.DeepEqual(&deployStack.Status, status) {
+		return nil
+	}
+	deployStack.Status = *status
+	return r.Status().Update(ctx, deployStack)
+}
+
+// reconcileList 创建或更新builder为应用生成的所有资源
+func (r *DeployStackReconciler) reconcileList(ctx context.Context, deployStack *apiv1.DeployStack, builder resource.ResourceListBuilder, name, tag string) error {
+	resourceObjs, err := builder.BuildList(name, tag)
+	if err != nil {
+		return r.builderError(ctx, builder, err)
+	}
+	for _, resourceObj := range resourceObjs {
+		// 未引入依赖的资源类型, 集群中未安装CRD时跳过
+		if _, ok := resourceObj.(*unstructured.Unstructured); ok {
+			gvk := resourceObj.GetObjectKind().GroupVersionKind()
+			if !r.crdInstalled(gvk) {
+				r.Recorder.Eventf(deployStack, corev1.EventTypeWarning, "CRDNotInstalled", "%s is not installed, skip %s", gvk.GroupKind().String(), resourceObj.GetName())
+				continue
+			}
+		}
+		if err := r.applyObject(ctx, builder, resourceObj, name, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileIngresses 创建或更新spec.ingress生成的Ingress、Certificate及路由
+func (r *DeployStackReconciler) reconcileIngresses(ctx context.Context, resourceBuilder *resource.DeployStackBuild) error {
+	for _, builder := range resourceBuilder.IngressBuilds() {
+		for _, name := range builder.Names() {
+			if listBuilder, ok := builder.(resource.ResourceListBuilder); ok {
+				if err := r.reconcileList(ctx, resourceBuilder.Instance, listBuilder, name, ""); err != nil {
+					return err
 				}
-				if fondResourceName == "global-secret" {
-					continue
-				}
-			} else {
-				fondResourceName = name
+				continue
 			}
-			//获取对于资源类型currentResourcesObj:= &appsv1.Deployment{}
-			// resourceObj, ok := r.getObjectKind(resources)
-			// if !ok {
-			// 	continue
-			// }
-			// logger.Info("Begin Fetch getResourceObj")
-			currentResourceObj, err := r.getResourceObj(ctx, namespace, fondResourceName, resources)
-			if client.IgnoreNotFound(err) != nil {
-				return ctrl.Result{}, err
+			resourceObj, err := builder.Build(name, "")
+			if err != nil {
+				return r.builderError(ctx, builder, err)
 			}
-			// 如果 对于 资源对象不存在，则创建
-			resourceObj := resources
-			if errors.IsNotFound(err) {
-				logger.Info("NotFound Resource for DeployStack, Create one", "Name", fondResourceName, "Kind", reflect.TypeOf(resourceObj))
-				//Create Resource
-				if resourceObj, err = builder.Build(name, tag); err != nil {
-					return ctrl.Result{}, err
-				}
-				if err := r.Client.Create(ctx, resourceObj); err != nil {
-					logger.Error(err, "Create Resource  Failed", "Name", fondResourceName, "Kind", reflect.TypeOf(resourceObj))
-					return ctrl.Result{}, err
-				}
-				r.Recorder.Eventf(resourceObj, corev1.EventTypeNormal, "Created", "Created resource %T", resourceObj)
-			} else {
-				logger.Info("Kind  resource already", "Name", fondResourceName, "Kind", reflect.TypeOf(currentResourceObj))
-				// 如果资源对象存在，且需要更新，则更新
-				var newResourceObj client.Object
-				if newResourceObj, err = builder.Update(currentResourceObj, name, tag); err != nil {
-					return ctrl.Result{}, err
-				}
-				// if !reflect.DeepEqual(newResourceObj, currentResourceObj) {
-				if err := r.Client.Update(ctx, newResourceObj); err != nil {
-					logger.Error(err, "Update Resource  Failed", "Name", fondResourceName, "Kind", reflect.TypeOf(newResourceObj))
-					return ctrl.Result{}, err
-				}
-				logger.Info("Kind Resource Updated", "Name", fondResourceName, "Kind", reflect.TypeOf(newResourceObj))
-				r.Recorder.Eventf(newResourceObj, corev1.EventTypeNormal, "Update", "Update Resource %T", newResourceObj)
-				// }
+			if err := r.applyObject(ctx, builder, resourceObj, name, ""); err != nil {
+				return err
+			}
+		}
+	}
+	// gateway模式下由HTTPRoute、GRPCRoute代替Ingress, 删除之前创建的Ingress
+	if resourceBuilder.GatewayMode() {
+		for _, name := range resourceBuilder.IngressNames() {
+			if err := r.ingressDelete(ctx, resourceBuilder.Instance.Spec.Namespace, resource.IngressName(name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ingressDelete 删除gateway模式下不再需要的Ingress
+func (r *DeployStackReconciler) ingressDelete(ctx context.Context, namespace, name string) error {
+	ingress := &v1.Ingress{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, ingress); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if err := r.Delete(ctx, ingress); client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	r.Recorder.Eventf(ingress, corev1.EventTypeNormal, "Deleted", "Deleted Resource %T", ingress)
+	return nil
+}
 
+// reconcileBatch 创建或更新spec.jobs、spec.cronJobs中的任务
+func (r *DeployStackReconciler) reconcileBatch(ctx context.Context, resourceBuilder *resource.DeployStackBuild) error {
+	for _, builder := range resourceBuilder.BatchBuilds() {
+		tasks := builder.Tasks()
+		for _, name := range resource.SortedKeys(tasks) {
+			tag := tasks[name]
+			resourceObj, err := builder.Build(name, tag)
+			if err != nil {
+				return r.builderError(ctx, builder, err)
+			}
+			if err := r.applyObject(ctx, builder, resourceObj, name, tag); err != nil {
+				return err
 			}
 		}
-		logger.Info("#####end分割线####", "Name", name)
 	}
-	//删除多余服务
-	if err := r.resourcesDelete(ctx, deployStackInstance); err != nil {
-		logger.Error(err, "Failed to Delete DeployStack resource")
-		return ctrl.Result{}, err
+	return nil
+}
+
+// applyObject 资源不存在时创建, 存在时通过builder.Update更新, 无变化时跳过
+func (r *DeployStackReconciler) applyObject(ctx context.Context, builder resource.ResourceBuilder, resourceObj client.Object, name, tag string) error {
+	logger := r.Log.WithValues("App", name)
+	currentResourceObj, err := builder.GetObjectKind()
+	if err != nil {
+		return err
+	}
+	err = r.Get(ctx, client.ObjectKeyFromObject(resourceObj), currentResourceObj)
+	if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	if errors.IsNotFound(err) {
+		logger.Info("NotFound Resource for DeployStack, Create one", "Name", resourceObj.GetName(), "Kind", reflect.TypeOf(resourceObj))
+		if err := r.Client.Create(ctx, resourceObj); err != nil {
+			logger.Error(err, "Create Resource  Failed", "Name", resourceObj.GetName(), "Kind", reflect.TypeOf(resourceObj))
+			return err
+		}
+		r.Recorder.Eventf(resourceObj, corev1.EventTypeNormal, "Created", "Created resource %T", resourceObj)
+		return nil
+	}
+	oldResourceObj := currentResourceObj.DeepCopyObject().(client.Object)
+	newResourceObj, err := builder.Update(currentResourceObj, name, tag)
+	if err != nil {
+		return r.builderError(ctx, builder, err)
+	}
+	if pvc, ok := newResourceObj.(*corev1.PersistentVolumeClaim); ok {
+		expand, err := r.allowVolumeExpansion(ctx, oldResourceObj.(*corev1.PersistentVolumeClaim), pvc)
+		if err != nil {
+			return err
+		}
+		if !expand {
+			r.Recorder.Eventf(pvc, corev1.EventTypeWarning, "ResizeSkipped", "StorageClass of %s does not allow volume expansion", pvc.Name)
+			pvc.Spec.Resources.Requests = oldResourceObj.(*corev1.PersistentVolumeClaim).Spec.Resources.Requests
+		}
+	}
+	if reflect.DeepEqual(oldResourceObj, newResourceObj) {
+		return nil
+	}
+	if err := r.Client.Update(ctx, newResourceObj); err != nil {
+		logger.Error(err, "Update Resource  Failed", "Name", newResourceObj.GetName(), "Kind", reflect.TypeOf(newResourceObj))
+		return err
+	}
+	logger.Info("Kind Resource Updated", "Name", newResourceObj.GetName(), "Kind", reflect.TypeOf(newResourceObj))
+	r.Recorder.Eventf(newResourceObj, corev1.EventTypeNormal, "Update", "Update Resource %T", newResourceObj)
+	return nil
+}
+
+// allowVolumeExpansion PVC扩容时检查StorageClass是否允许扩容, 未扩容时返回true
+func (r *DeployStackReconciler) allowVolumeExpansion(ctx context.Context, current, desired *corev1.PersistentVolumeClaim) (bool, error) {
+	currentSize := current.Spec.Resources.Requests[corev1.ResourceStorage]
+	desiredSize := desired.Spec.Resources.Requests[corev1.ResourceStorage]
+	if desiredSize.Cmp(currentSize) <= 0 {
+		return true, nil
+	}
+	if current.Spec.StorageClassName == nil || *current.Spec.StorageClassName == "" {
+		return false, nil
 	}
-	return ctrl.Result{}, nil
+	storageClass := &storagev1.StorageClass{}
+	if err := r.Get(ctx, types.NamespacedName{Name: *current.Spec.StorageClassName}, storageClass); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return storageClass.AllowVolumeExpansion != nil && *storageClass.AllowVolumeExpansion, nil
 }
+
 func (r *DeployStackReconciler) resourcesDelete(ctx context.Context, deployStack *apiv1.DeployStack) error {
-	resourceBuilder := resource.DeployStackBuild{Instance: deployStack, Scheme: r.Scheme}
+	resourceBuilder := r.newResourceBuilder(ctx, deployStack)
 	builders := resourceBuilder.ResourceBuilds()
 	var (
 		err       error
@@ -171,7 +540,7 @@ func (r *DeployStackReconciler) resourcesDelete(ctx context.Context, deployStack
 				return err
 			}
 			for _, resourceObj := range resourceObjList.Items {
-				if _, ok := deployStack.Spec.AppsList[resourceObj.Name]; !ok {
+				if resource.AppResourceOrphaned(deployStack, resourceObj.Name) {
 					if err := r.Delete(ctx, &resourceObj); err != nil {
 						return err
 					}
@@ -179,25 +548,37 @@ func (r *DeployStackReconciler) resourcesDelete(ctx context.Context, deployStack
 				}
 			}
 		case *corev1.Service:
+			// 应用可以有多个Service, 按builder生成的名称判断
+			desired := map[string]bool{}
+			for name, tag := range deployStack.Spec.AppsList {
+				resourceObjs, err := builder.(resource.ResourceListBuilder).BuildList(name, tag)
+				if err != nil {
+					return permanent(err)
+				}
+				for _, resourceObj := range resourceObjs {
+					desired[resourceObj.GetName()] = true
+				}
+			}
 			resourceObjList := &corev1.ServiceList{}
 			if err := r.List(ctx, resourceObjList, listOps); err != nil {
 				return err
 			}
 			for _, resourceObj := range resourceObjList.Items {
-				if _, ok := deployStack.Spec.AppsList[resourceObj.Name]; !ok {
+				if !desired[resourceObj.Name] {
 					if err := r.Delete(ctx, &resourceObj); err != nil {
 						return err
 					}
 					r.Recorder.Eventf(&resourceObj, corev1.EventTypeNormal, "Deleted", "Deleted Resource %T", resourceObj)
 				}
 			}
+		// global-secret、global-config 由所有应用共用, 不按appsList删除
 		case *corev1.Secret:
 			resourceObjList := &corev1.SecretList{}
 			if err := r.List(ctx, resourceObjList, listOps); err != nil {
 				return err
 			}
 			for _, resourceObj := range resourceObjList.Items {
-				if _, ok := deployStack.Spec.AppsList[resourceObj.Name]; !ok {
+				if resource.AppResourceOrphaned(deployStack, resourceObj.Name) {
 					if err := r.Delete(ctx, &resourceObj); err != nil {
 						return err
 					}
@@ -210,30 +591,242 @@ func (r *DeployStackReconciler) resourcesDelete(ctx context.Context, deployStack
 				return err
 			}
 			for _, resourceObj := range resourceObjList.Items {
-				if _, ok := deployStack.Spec.AppsList[resourceObj.Name]; !ok {
+				if resource.AppResourceOrphaned(deployStack, resourceObj.Name) {
 					if err := r.Delete(ctx, &resourceObj); err != nil {
 						return err
 					}
 					r.Recorder.Eventf(&resourceObj, corev1.EventTypeNormal, "Deleted", "Deleted Resource %T", resourceObj)
 				}
 			}
-		case *v1.Ingress:
-			resourceObjList := &v1.IngressList{}
-			if err := r.List(ctx, resourceObjList, listOps); err != nil {
+		case *corev1.PersistentVolumeClaim:
+			if err := r.persistentVolumeClaimsDelete(ctx, deployStack, builder, listOps); err != nil {
 				return err
 			}
-			for _, resourceObj := range resourceObjList.Items {
-				if _, ok := deployStack.Spec.AppsList[resourceObj.Name]; !ok {
-					if err := r.Delete(ctx, &resourceObj); err != nil {
-						return err
-					}
-					r.Recorder.Eventf(&resourceObj, corev1.EventTypeNormal, "Deleted", "Deleted Resource %T", resourceObj)
-				}
+		case *unstructured.Unstructured:
+			if err := r.unstructuredDelete(ctx, deployStack, builder, listOps); err != nil {
+				return err
+			}
+		}
+
+	}
+	for _, builder := range resourceBuilder.IngressBuilds() {
+		if err := r.ingressResourcesDelete(ctx, builder, listOps); err != nil {
+			return err
+		}
+	}
+	for _, builder := range resourceBuilder.BatchBuilds() {
+		if err := r.batchDelete(ctx, builder, listOps); err != nil {
+			return err
+		}
+	}
+	if err := r.appHooksDelete(ctx, deployStack, listOps); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// unstructuredDelete 删除应用已不再需要的ServiceMonitor、PodMonitor等未引入依赖的资源
+func (r *DeployStackReconciler) unstructuredDelete(ctx context.Context, deployStack *apiv1.DeployStack, builder resource.ResourceBuilder, listOps *client.ListOptions) error {
+	listBuilder, ok := builder.(resource.ResourceListBuilder)
+	if !ok {
+		return nil
+	}
+	resources, err := builder.GetObjectKind()
+	if err != nil {
+		return err
+	}
+	// 集群中未安装CRD时无需删除
+	gvk := resources.GetObjectKind().GroupVersionKind()
+	if !r.crdInstalled(gvk) {
+		return nil
+	}
+	desired := map[string]bool{}
+	for name, tag := range deployStack.Spec.AppsList {
+		resourceObjs, err := listBuilder.BuildList(name, tag)
+		if err != nil {
+			return permanent(err)
+		}
+		for _, resourceObj := range resourceObjs {
+			desired[resourceObj.GetName()] = true
+		}
+	}
+	resourceObjList := &unstructured.UnstructuredList{}
+	resourceObjList.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	if err := r.List(ctx, resourceObjList, listOps); err != nil {
+		return err
+	}
+	for i := range resourceObjList.Items {
+		resourceObj := &resourceObjList.Items[i]
+		if desired[resourceObj.GetName()] {
+			continue
+		}
+		if err := r.Delete(ctx, resourceObj); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		r.Recorder.Eventf(resourceObj, corev1.EventTypeNormal, "Deleted", "Deleted Resource %s", gvk.Kind)
+	}
+	return nil
+}
+
+// ingressResourcesDelete 删除已从spec.ingress中移除的Ingress、Certificate及路由
+func (r *DeployStackReconciler) ingressResourcesDelete(ctx context.Context, builder resource.IngressBuilder, listOps *client.ListOptions) error {
+	desired := map[string]bool{}
+	for _, name := range builder.Names() {
+		var resourceObjs []client.Object
+		if listBuilder, ok := builder.(resource.ResourceListBuilder); ok {
+			objs, err := listBuilder.BuildList(name, "")
+			if err != nil {
+				return permanent(err)
+			}
+			resourceObjs = objs
+		} else {
+			resourceObj, err := builder.Build(name, "")
+			if err != nil {
+				return permanent(err)
 			}
+			resourceObjs = append(resourceObjs, resourceObj)
+		}
+		for _, resourceObj := range resourceObjs {
+			desired[resourceObj.GetName()] = true
+		}
+	}
+	resources, err := builder.GetObjectKind()
+	if err != nil {
+		return err
+	}
+	var (
+		resourceObjs []client.Object
+		kind         string
+	)
+	switch resources.(type) {
+	case *v1.Ingress:
+		kind = "Ingress"
+		resourceObjList := &v1.IngressList{}
+		if err := r.List(ctx, resourceObjList, listOps); err != nil {
+			return err
 		}
+		for i := range resourceObjList.Items {
+			resourceObjs = append(resourceObjs, &resourceObjList.Items[i])
+		}
+	case *unstructured.Unstructured:
+		// 未引入依赖的资源类型, 集群中未安装CRD时无需删除
+		gvk := resources.GetObjectKind().GroupVersionKind()
+		if !r.crdInstalled(gvk) {
+			return nil
+		}
+		kind = gvk.Kind
+		resourceObjList := &unstructured.UnstructuredList{}
+		resourceObjList.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		if err := r.List(ctx, resourceObjList, listOps); err != nil {
+			return err
+		}
+		for i := range resourceObjList.Items {
+			resourceObjs = append(resourceObjs, &resourceObjList.Items[i])
+		}
+	}
+	for _, resourceObj := range resourceObjs {
+		if desired[resourceObj.GetName()] {
+			continue
+		}
+		if err := r.Delete(ctx, resourceObj); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		r.Recorder.Eventf(resourceObj, corev1.EventTypeNormal, "Deleted", "Deleted Resource %s", kind)
+	}
+	return nil
+}
 
+// batchDelete 删除已从spec.jobs、spec.cronJobs中移除或配置已变化的任务
+func (r *DeployStackReconciler) batchDelete(ctx context.Context, builder resource.BatchBuilder, listOps *client.ListOptions) error {
+	desired := map[string]bool{}
+	for name, tag := range builder.Tasks() {
+		resourceObj, err := builder.Build(name, tag)
+		if err != nil {
+			return permanent(err)
+		}
+		desired[resourceObj.GetName()] = true
+	}
+	resources, err := builder.GetObjectKind()
+	if err != nil {
+		return err
+	}
+	var resourceObjs []client.Object
+	switch resources.(type) {
+	case *batchv1.Job:
+		resourceObjList := &batchv1.JobList{}
+		if err := r.List(ctx, resourceObjList, listOps, client.MatchingLabels{resource.ComponentLabel: resource.ComponentJob}); err != nil {
+			return err
+		}
+		for i := range resourceObjList.Items {
+			resourceObjs = append(resourceObjs, &resourceObjList.Items[i])
+		}
+	case *batchv1.CronJob:
+		resourceObjList := &batchv1.CronJobList{}
+		if err := r.List(ctx, resourceObjList, listOps, client.MatchingLabels{resource.ComponentLabel: resource.ComponentCronJob}); err != nil {
+			return err
+		}
+		for i := range resourceObjList.Items {
+			resourceObjs = append(resourceObjs, &resourceObjList.Items[i])
+		}
 	}
+	for _, resourceObj := range resourceObjs {
+		if desired[resourceObj.GetName()] {
+			continue
+		}
+		if err := r.Delete(ctx, resourceObj, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		r.Recorder.Eventf(resourceObj, corev1.EventTypeNormal, "Deleted", "Deleted Resource %T", resourceObj)
+	}
+	return nil
+}
 
+// persistentVolumeClaimsDelete 删除不再需要的PVC, 标记保留的PVC只解除与DeployStack的关联
+// 只处理带有本DeployStack标签的PVC, 由其他DeployStack管理的PVC跳过
+func (r *DeployStackReconciler) persistentVolumeClaimsDelete(ctx context.Context, deployStack *apiv1.DeployStack, builder resource.ResourceBuilder, listOps *client.ListOptions) error {
+	listBuilder, ok := builder.(resource.ResourceListBuilder)
+	if !ok {
+		return nil
+	}
+	desired := map[string]bool{}
+	for name, tag := range deployStack.Spec.AppsList {
+		resourceObjs, err := listBuilder.BuildList(name, tag)
+		if err != nil {
+			return permanent(err)
+		}
+		for _, resourceObj := range resourceObjs {
+			desired[resourceObj.GetName()] = true
+		}
+	}
+	resourceObjList := &corev1.PersistentVolumeClaimList{}
+	if err := r.List(ctx, resourceObjList, listOps, client.MatchingLabels(resource.StackLabels(deployStack))); err != nil {
+		return err
+	}
+	for i := range resourceObjList.Items {
+		resourceObj := &resourceObjList.Items[i]
+		if desired[resourceObj.Name] {
+			continue
+		}
+		if owner := metav1.GetControllerOf(resourceObj); owner != nil && owner.UID != deployStack.UID {
+			continue
+		}
+		if resourceObj.Annotations[resource.RetainAnnotation] == "true" {
+			if len(resourceObj.OwnerReferences) == 0 {
+				continue
+			}
+			resourceObj.OwnerReferences = nil
+			if err := r.Update(ctx, resourceObj); err != nil {
+				return err
+			}
+			r.Recorder.Eventf(resourceObj, corev1.EventTypeNormal, "Retained", "Retained Resource %T", resourceObj)
+			continue
+		}
+		if err := r.Delete(ctx, resourceObj); err != nil {
+			return err
+		}
+		r.Recorder.Eventf(resourceObj, corev1.EventTypeNormal, "Deleted", "Deleted Resource %T", resourceObj)
+	}
 	return nil
 }
 
@@ -292,6 +885,8 @@ func (r *DeployStackReconciler) getResourceObj(ctx context.Context, namespace, n
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *DeployStackReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// 统计调谐过程中变更的对象
+	r.Client = &metricsClient{Client: r.Client, scheme: r.Scheme}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&apiv1.DeployStack{}).
 		Owns(&appsv1.Deployment{}).
@@ -299,5 +894,9 @@ func (r *DeployStackReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Owns(&corev1.ConfigMap{}).
 		Owns(&corev1.Secret{}).
 		Owns(&v1.Ingress{}).
+		Owns(&corev1.PersistentVolumeClaim{}).
+		Owns(&batchv1.Job{}).
+		Owns(&batchv1.CronJob{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		Complete(r)
 }
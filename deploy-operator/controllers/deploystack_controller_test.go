@@ -0,0 +1,401 @@
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/registry"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/resource"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8sresource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+)
+
+// changeClient 统计调谐过程中创建、更新、删除的对象
+type changeClient struct {
+	client.Client
+	mu      sync.Mutex
+	changes []string
+}
+
+func (c *changeClient) record(action string, obj client.Object) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.changes = append(c.changes, action+" "+objectKind(obj, c.Scheme())+"/"+obj.GetName())
+}
+
+func (c *changeClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	c.record(actionCreate, obj)
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *changeClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	c.record(actionUpdate, obj)
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *changeClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.record(actionUpdate, obj)
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *changeClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	c.record(actionDelete, obj)
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+// takeChanges 返回并清空已记录的变更
+func (c *changeClient) takeChanges() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changes := c.changes
+	c.changes = nil
+	return changes
+}
+
+func testScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := apiv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+// newTestReconciler 使用fake client及testdata中的DeployStack创建reconciler
+func newTestReconciler(t *testing.T, appWorkers int) (*DeployStackReconciler, *changeClient, types.NamespacedName) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "internal", "resource", "testdata", "deploystack.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployStack := &apiv1.DeployStack{}
+	if err := yaml.UnmarshalStrict(data, deployStack); err != nil {
+		t.Fatal(err)
+	}
+	scheme := testScheme(t)
+	c := &changeClient{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(deployStack).Build()}
+	r := &DeployStackReconciler{
+		Client:     c,
+		Log:        ctrl.Log.WithName("test"),
+		Scheme:     scheme,
+		Recorder:   &record.FakeRecorder{},
+		AppWorkers: appWorkers,
+	}
+	return r, c, client.ObjectKeyFromObject(deployStack)
+}
+
+func TestReconcileIdempotent(t *testing.T) {
+	r, c, key := newTestReconciler(t, 1)
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	if changes := c.takeChanges(); len(changes) == 0 {
+		t.Fatal("first reconcile created nothing")
+	}
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	if changes := c.takeChanges(); len(changes) != 0 {
+		t.Errorf("second reconcile changed objects: %v", changes)
+	}
+}
+
+// storageStack 在dev中部署一个带PVC的应用的DeployStack
+func storageStack(name, uid, app string) *apiv1.DeployStack {
+	deployStack := &apiv1.DeployStack{ObjectMeta: metav1.ObjectMeta{Namespace: "dev", Name: name, UID: types.UID(uid)}}
+	deployStack.Spec.Namespace = "dev"
+	deployStack.Spec.PortForHttp = 8800
+	deployStack.Spec.AppsList = map[string]string{app: "v1"}
+	deployStack.Spec.Apps = map[string]apiv1.AppsName{
+		app: {Storage: []apiv1.StorageSpec{{Name: "data", Size: k8sresource.MustParse("1Gi"), MountPath: "/data"}}},
+	}
+	return deployStack
+}
+
+// TestPersistentVolumeClaimsDeleteSharedNamespace 部署到同一namespace的DeployStack不删除彼此的PVC
+func TestPersistentVolumeClaimsDeleteSharedNamespace(t *testing.T) {
+	ctx := context.Background()
+	first, second := storageStack("first", "uid-first", "hello"), storageStack("second", "uid-second", "world")
+	scheme := testScheme(t)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(first, second).Build()
+	r := &DeployStackReconciler{Client: c, Log: ctrl.Log.WithName("test"), Scheme: scheme, Recorder: &record.FakeRecorder{}}
+	for _, deployStack := range []*apiv1.DeployStack{first, second, first} {
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(deployStack)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pvcs := func() []string {
+		pvcList := &corev1.PersistentVolumeClaimList{}
+		if err := c.List(ctx, pvcList, client.InNamespace("dev")); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, pvc := range pvcList.Items {
+			names = append(names, pvc.Name)
+		}
+		return names
+	}
+	if names, want := pvcs(), []string{"hello-data", "world-data"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("pvcs = %v, want %v", names, want)
+	}
+
+	// first中的hello替换为不带存储的应用, 只删除first的PVC
+	if err := c.Get(ctx, client.ObjectKeyFromObject(first), first); err != nil {
+		t.Fatal(err)
+	}
+	first.Spec.AppsList = map[string]string{"other": "v1"}
+	if err := c.Update(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(first)}); err != nil {
+		t.Fatal(err)
+	}
+	if names, want := pvcs(), []string{"world-data"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("pvcs after removing hello = %v, want %v", names, want)
+	}
+}
+
+// tagsRegistry 返回固定tag的镜像仓库
+type tagsRegistry []string
+
+func (tags tagsRegistry) Tags(ctx context.Context, repository string, auth *registry.Auth) ([]string, error) {
+	return tags, nil
+}
+
+func (tags tagsRegistry) Digest(ctx context.Context, repository, tag string, auth *registry.Auth) (string, error) {
+	return "", goerrors.New("not implemented")
+}
+
+// appFailClient 更新DeployStack的appsList及查询指定Deployment时返回错误
+type appFailClient struct {
+	client.Client
+	deployment string
+}
+
+func (c *appFailClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if _, ok := obj.(*apiv1.DeployStack); ok {
+		return goerrors.New("patch rejected")
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *appFailClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if _, ok := obj.(*appsv1.Deployment); ok && key.Name == c.deployment {
+		return goerrors.New("get failed")
+	}
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+// TestReconcileContinuesOnAppErrors 镜像tag更新失败及无法获取发布状态时, 只影响对应的应用
+func TestReconcileContinuesOnAppErrors(t *testing.T) {
+	ctx := context.Background()
+	deployStack := &apiv1.DeployStack{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "stack"}}
+	deployStack.Spec.Namespace = "dev"
+	deployStack.Spec.PortForHttp = 8800
+	deployStack.Spec.AppsList = map[string]string{"hello": "1.0.0", "test": "v1", "world": "v1"}
+	deployStack.Spec.Apps = map[string]apiv1.AppsName{"hello": {ImageUpdate: &apiv1.ImageUpdateSpec{Semver: "^1.0"}}}
+	deployStack.Spec.RolloutPolicy = &apiv1.RolloutPolicy{}
+	scheme := testScheme(t)
+	c := &appFailClient{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(deployStack).Build(), deployment: "world"}
+	r := &DeployStackReconciler{
+		Client:   c,
+		Log:      ctrl.Log.WithName("test"),
+		Scheme:   scheme,
+		Recorder: &record.FakeRecorder{},
+		Registry: tagsRegistry{"1.0.0", "1.1.0"},
+	}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(deployStack)}); err == nil {
+		t.Fatal("expected the app errors to be retried")
+	}
+	for name, image := range map[string]string{"hello": "hello:1.0.0", "test": "test:v1"} {
+		deploy := &appsv1.Deployment{}
+		if err := c.Client.Get(ctx, types.NamespacedName{Namespace: "dev", Name: name}, deploy); err != nil {
+			t.Fatalf("deployment %s: %v", name, err)
+		}
+		if got := deploy.Spec.Template.Spec.Containers[0].Image; !strings.HasSuffix(got, image) {
+			t.Errorf("deployment %s image = %s, want %s", name, got, image)
+		}
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(deployStack), deployStack); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"hello", "world"} {
+		if status := deployStack.Status.Apps[name]; status.Phase != apiv1.AppPhaseRetrying {
+			t.Errorf("app %s phase = %q (%s), want %q", name, status.Phase, status.Message, apiv1.AppPhaseRetrying)
+		}
+	}
+	if status := deployStack.Status.Apps["test"]; status.Phase == apiv1.AppPhaseRetrying || status.Phase == apiv1.AppPhaseFailed {
+		t.Errorf("app test phase = %q (%s)", status.Phase, status.Message)
+	}
+}
+
+// TestReconcilePaused 暂停时不写入任何资源, reconcile-at 注解变化时执行一次调谐
+func TestReconcilePaused(t *testing.T) {
+	ctx := context.Background()
+	r, c, key := newTestReconciler(t, 1)
+	deployStack := &apiv1.DeployStack{}
+	update := func(mutate func()) {
+		t.Helper()
+		if err := c.Client.Get(ctx, key, deployStack); err != nil {
+			t.Fatal(err)
+		}
+		mutate()
+		if err := c.Client.Update(ctx, deployStack); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reconcile := func() []string {
+		t.Helper()
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Requeue {
+			t.Errorf("result = %+v, reconcile-at must not requeue", result)
+		}
+		if err := c.Client.Get(ctx, key, deployStack); err != nil {
+			t.Fatal(err)
+		}
+		var changes []string
+		for _, change := range c.takeChanges() {
+			// 暂停时只更新DeployStack的状态
+			if !strings.HasPrefix(change, actionUpdate+" DeployStack/") {
+				changes = append(changes, change)
+			}
+		}
+		return changes
+	}
+
+	update(func() { deployStack.Spec.Paused = true })
+	if changes := reconcile(); len(changes) != 0 {
+		t.Errorf("paused reconcile changed objects: %v", changes)
+	}
+	if !deployStack.Status.Paused {
+		t.Error("status.paused = false for a paused DeployStack")
+	}
+
+	update(func() { deployStack.Annotations = map[string]string{apiv1.ReconcileRequestAnnotation: "1"} })
+	if changes := reconcile(); len(changes) == 0 {
+		t.Error("reconcile-at did not reconcile a paused DeployStack")
+	}
+	if deployStack.Status.LastHandledReconcileAt != "1" {
+		t.Errorf("status.lastHandledReconcileAt = %q, want 1", deployStack.Status.LastHandledReconcileAt)
+	}
+	if !deployStack.Status.Paused {
+		t.Error("status.paused = false after a requested reconcile")
+	}
+
+	// 同一个注解值只调谐一次, spec变化也不会写入资源
+	update(func() { deployStack.Spec.AppsList["hello"] = "b12" })
+	if changes := reconcile(); len(changes) != 0 {
+		t.Errorf("paused reconcile with a handled reconcile-at changed objects: %v", changes)
+	}
+	update(func() { deployStack.Annotations[apiv1.ReconcileRequestAnnotation] = "2" })
+	if changes := reconcile(); len(changes) == 0 {
+		t.Error("changed reconcile-at did not reconcile the new tag")
+	}
+
+	// 恢复后正常调谐
+	update(func() {
+		deployStack.Spec.AppsList["hello"] = "b13"
+		deployStack.Spec.Paused = false
+	})
+	if changes := reconcile(); len(changes) == 0 {
+		t.Error("resumed DeployStack did not reconcile")
+	}
+	if deployStack.Status.Paused {
+		t.Error("status.paused = true after resuming")
+	}
+}
+
+// TestBatchDelete 任务配置变化时删除旧hash的Job, 从spec中移除的任务被删除
+func TestBatchDelete(t *testing.T) {
+	ctx := context.Background()
+	r, c, key := newTestReconciler(t, 1)
+	reconcile := func(mutate func(deployStack *apiv1.DeployStack)) {
+		t.Helper()
+		deployStack := &apiv1.DeployStack{}
+		if err := c.Client.Get(ctx, key, deployStack); err != nil {
+			t.Fatal(err)
+		}
+		mutate(deployStack)
+		if err := c.Client.Update(ctx, deployStack); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jobs := func() []string {
+		t.Helper()
+		jobList := &batchv1.JobList{}
+		if err := c.List(ctx, jobList, client.InNamespace("dev"), client.MatchingLabels{resource.ComponentLabel: resource.ComponentJob}); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, job := range jobList.Items {
+			names = append(names, job.Name)
+		}
+		return names
+	}
+	cronJobs := func() int {
+		t.Helper()
+		cronJobList := &batchv1.CronJobList{}
+		if err := c.List(ctx, cronJobList, client.InNamespace("dev")); err != nil {
+			t.Fatal(err)
+		}
+		return len(cronJobList.Items)
+	}
+
+	reconcile(func(deployStack *apiv1.DeployStack) {
+		deployStack.Spec.Jobs = map[string]apiv1.JobSpec{"migrate": {Tag: "v1", Command: []string{"migrate", "up"}}}
+		deployStack.Spec.CronJobs = map[string]apiv1.CronJobSpec{"report": {JobSpec: apiv1.JobSpec{Tag: "v1"}, Schedule: "0 1 * * *"}}
+	})
+	first := jobs()
+	if len(first) != 1 || cronJobs() != 1 {
+		t.Fatalf("jobs = %v, cronJobs = %d, want one of each", first, cronJobs())
+	}
+
+	// 配置不变时不重建
+	reconcile(func(deployStack *apiv1.DeployStack) {})
+	if got := jobs(); !reflect.DeepEqual(got, first) {
+		t.Errorf("jobs = %v after an unchanged reconcile, want %v", got, first)
+	}
+
+	reconcile(func(deployStack *apiv1.DeployStack) {
+		deployStack.Spec.Jobs["migrate"] = apiv1.JobSpec{Tag: "v1", Command: []string{"migrate", "down"}}
+	})
+	second := jobs()
+	if len(second) != 1 || second[0] == first[0] {
+		t.Errorf("jobs = %v after a spec change, want only a job replacing %s", second, first[0])
+	}
+
+	reconcile(func(deployStack *apiv1.DeployStack) {
+		deployStack.Spec.Jobs = nil
+		deployStack.Spec.CronJobs = nil
+	})
+	if got := jobs(); len(got) != 0 || cronJobs() != 0 {
+		t.Errorf("jobs = %v, cronJobs = %d after removal from spec", got, cronJobs())
+	}
+}
@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/resource"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type DeployStackPromotionReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=gopron.online,resources=deploystackpromotions,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=gopron.online,resources=deploystackpromotions/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=gopron.online,resources=deploystackpromotions/finalizers,verbs=update
+
+// Reconcile 源DeployStack发布完成后记录待晋级的tag, 审批通过后更新目标DeployStack的appsList
+func (r *DeployStackPromotionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := r.Log.WithValues("DeployStackPromotion", req.NamespacedName)
+
+	promotion := &apiv1.DeployStackPromotion{}
+	if err := r.Get(ctx, req.NamespacedName, promotion); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	// 晋级只执行一次, 再次晋级需要新建DeployStackPromotion
+	if promotion.Status.Phase == apiv1.PromotionPhaseSucceeded || promotion.Status.Phase == apiv1.PromotionPhaseFailed {
+		return ctrl.Result{}, nil
+	}
+	status := promotion.Status.DeepCopy()
+	if status.Tags == nil {
+		source, err := r.getStack(ctx, promotion, promotion.Spec.Source)
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, r.promotionFailed(ctx, promotion, status, err)
+		} else if err != nil {
+			return ctrl.Result{}, err
+		}
+		tags, err := promotionTags(source, promotion.Spec.Apps)
+		if err != nil {
+			return ctrl.Result{}, r.promotionFailed(ctx, promotion, status, err)
+		}
+		message, err := r.sourceNotReady(ctx, source, tags)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if message != "" {
+			status.Phase = apiv1.PromotionPhaseWaitingForSource
+			status.Message = message
+			if err := r.updatePromotionStatus(ctx, promotion, status); err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{RequeueAfter: hookRequeueInterval}, nil
+		}
+		now := metav1.Now()
+		status.Tags = tags
+		status.SourceReadyAt = &now
+	}
+	if promotion.Spec.RequireApproval {
+		approvedBy, message := promotionApproval(promotion, status.SourceReadyAt)
+		if message != "" {
+			if status.Phase != apiv1.PromotionPhaseWaitingForApproval {
+				r.Recorder.Eventf(promotion, corev1.EventTypeNormal, "WaitingForApproval", "Waiting for approval to promote %s", formatTags(status.Tags))
+			}
+			status.Phase = apiv1.PromotionPhaseWaitingForApproval
+			status.Message = message
+			return ctrl.Result{}, r.updatePromotionStatus(ctx, promotion, status)
+		}
+		status.ApprovedBy = approvedBy
+	}
+
+	target, err := r.getStack(ctx, promotion, promotion.Spec.Target)
+	if errors.IsNotFound(err) {
+		return ctrl.Result{}, r.promotionFailed(ctx, promotion, status, err)
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+	// 先在status中记录晋级前的tag再更新目标, 更新目标后status更新失败时重试不会用已晋级的tag覆盖previousTags
+	if status.PreviousTags == nil {
+		status.PreviousTags = map[string]string{}
+		for app := range status.Tags {
+			status.PreviousTags[app] = target.Spec.AppsList[app]
+		}
+		if err := r.updatePromotionStatus(ctx, promotion, status); err != nil {
+			return ctrl.Result{}, err
+		}
+		status = promotion.Status.DeepCopy()
+	}
+	// merge patch只修改spec.appsList中晋级的应用, 不覆盖目标DeployStack的并发修改
+	previous := target.DeepCopy()
+	if target.Spec.AppsList == nil {
+		target.Spec.AppsList = map[string]string{}
+	}
+	applied := true
+	for app, tag := range status.Tags {
+		applied = applied && target.Spec.AppsList[app] == tag
+		target.Spec.AppsList[app] = tag
+	}
+	if !applied {
+		if err := r.Patch(ctx, target, client.MergeFrom(previous)); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	logger.Info("Promoted", "Target", target.Name, "Tags", status.Tags)
+	r.Recorder.Eventf(target, corev1.EventTypeNormal, "Promoted", "Promoted %s from %s by %s", formatTags(status.Tags), promotion.Spec.Source.Name, promotion.Name)
+	r.Recorder.Eventf(promotion, corev1.EventTypeNormal, "Promoted", "Promoted %s to %s", formatTags(status.Tags), target.Name)
+	now := metav1.Now()
+	status.Phase = apiv1.PromotionPhaseSucceeded
+	status.Message = ""
+	status.PromotedAt = &now
+	return ctrl.Result{}, r.updatePromotionStatus(ctx, promotion, status)
+}
+
+func (r *DeployStackPromotionReconciler) getStack(ctx context.Context, promotion *apiv1.DeployStackPromotion, ref apiv1.DeployStackReference) (*apiv1.DeployStack, error) {
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = promotion.Namespace
+	}
+	deployStack := &apiv1.DeployStack{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, deployStack); err != nil {
+		return nil, err
+	}
+	return deployStack, nil
+}
+
+// promotionTags 源DeployStack中待晋级应用的tag
+func promotionTags(source *apiv1.DeployStack, apps []string) (map[string]string, error) {
+	tags := map[string]string{}
+	if len(apps) == 0 {
+		for app, tag := range source.Spec.AppsList {
+			tags[app] = tag
+		}
+		return tags, nil
+	}
+	for _, app := range apps {
+		tag, ok := source.Spec.AppsList[app]
+		if !ok {
+			return nil, fmt.Errorf("app %s not found in DeployStack %s", app, source.Name)
+		}
+		tags[app] = tag
+	}
+	return tags, nil
+}
+
+// sourceNotReady 返回源DeployStack中未发布完成的应用, 全部完成时返回空
+func (r *DeployStackPromotionReconciler) sourceNotReady(ctx context.Context, source *apiv1.DeployStack, tags map[string]string) (string, error) {
+	resourceBuilder := &resource.DeployStackBuild{Instance: source, Scheme: r.Scheme}
+	var pending []string
+	for _, app := range resource.SortedKeys(tags) {
+		deploy := &appsv1.Deployment{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: source.Spec.Namespace, Name: app}, deploy)
+		if client.IgnoreNotFound(err) != nil {
+			return "", err
+		}
+		if errors.IsNotFound(err) || !resourceBuilder.TagDeployed(deploy, app, tags[app]) || !deploymentRolledOut(deploy) {
+			pending = append(pending, app)
+		}
+	}
+	if len(pending) == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("waiting for %s in %s to be ready", strings.Join(pending, ", "), source.Name), nil
+}
+
+// promotionApproval 返回准入webhook记录的审批人; 未审批或审批早于源DeployStack发布完成时返回等待原因
+func promotionApproval(promotion *apiv1.DeployStackPromotion, sourceReadyAt *metav1.Time) (string, string) {
+	if !promotion.Spec.Approved {
+		return "", "set spec.approved to true to promote"
+	}
+	approvedBy := promotion.Annotations[apiv1.ApprovedByAnnotation]
+	approvedAt, err := time.Parse(time.RFC3339, promotion.Annotations[apiv1.ApprovedAtAnnotation])
+	if approvedBy == "" || err != nil {
+		return "", "approval not recorded by the admission webhook, set spec.approved to false and back to true"
+	}
+	// metav1.Time序列化后精度为秒
+	if sourceReadyAt != nil && approvedAt.Before(sourceReadyAt.Time.Truncate(time.Second)) {
+		return "", "approved before the source was ready, set spec.approved to false and back to true"
+	}
+	return approvedBy, ""
+}
+
+func (r *DeployStackPromotionReconciler) promotionFailed(ctx context.Context, promotion *apiv1.DeployStackPromotion, status *apiv1.DeployStackPromotionStatus, err error) error {
+	r.Recorder.Eventf(promotion, corev1.EventTypeWarning, "PromotionFailed", "%v", err)
+	status.Phase = apiv1.PromotionPhaseFailed
+	status.Message = err.Error()
+	return r.updatePromotionStatus(ctx, promotion, status)
+}
+
+// updatePromotionStatus status变化时更新DeployStackPromotion
+func (r *DeployStackPromotionReconciler) updatePromotionStatus(ctx context.Context, promotion *apiv1.DeployStackPromotion, status *apiv1.DeployStackPromotionStatus) error {
+	if reflect.DeepEqual(&promotion.Status, status) {
+		return nil
+	}
+	promotion.Status = *status
+	return r.Status().Update(ctx, promotion)
+}
+
+// formatTags 按应用名称排序输出 app:tag
+func formatTags(tags map[string]string) string {
+	var items []string
+	for _, app := range resource.SortedKeys(tags) {
+		items = append(items, app+":"+tags[app])
+	}
+	return strings.Join(items, ", ")
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DeployStackPromotionReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&apiv1.DeployStackPromotion{}).
+		Complete(r)
+}
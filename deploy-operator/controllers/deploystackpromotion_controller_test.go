@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// conflictClient patch DeployStack后, 下一次status更新返回冲突
+type conflictClient struct {
+	client.Client
+	fail bool
+}
+
+func (c *conflictClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if _, ok := obj.(*apiv1.DeployStack); ok {
+		c.fail = true
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *conflictClient) Status() client.StatusWriter {
+	return &conflictStatusWriter{StatusWriter: c.Client.Status(), client: c}
+}
+
+type conflictStatusWriter struct {
+	client.StatusWriter
+	client *conflictClient
+}
+
+func (w *conflictStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if w.client.fail {
+		w.client.fail = false
+		return errors.New("conflict")
+	}
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func TestPromotionKeepsPreviousTagsOnRetry(t *testing.T) {
+	target := &apiv1.DeployStack{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "prod"}}
+	target.Spec.AppsList = map[string]string{"hello": "v1"}
+	promotion := &apiv1.DeployStackPromotion{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "promote"}}
+	promotion.Spec.Source.Name = "dev"
+	promotion.Spec.Target.Name = "prod"
+	promotion.Status.Tags = map[string]string{"hello": "v2"}
+	c := &conflictClient{Client: fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(target, promotion).Build()}
+	r := &DeployStackPromotionReconciler{Client: c, Log: ctrl.Log.WithName("test"), Scheme: c.Scheme(), Recorder: &record.FakeRecorder{}}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(promotion)}
+
+	if _, err := r.Reconcile(context.Background(), req); err == nil {
+		t.Fatal("expected the status update after promoting to fail")
+	}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(context.Background(), req.NamespacedName, promotion); err != nil {
+		t.Fatal(err)
+	}
+	if promotion.Status.Phase != apiv1.PromotionPhaseSucceeded {
+		t.Errorf("phase = %q, want %q", promotion.Status.Phase, apiv1.PromotionPhaseSucceeded)
+	}
+	if want := map[string]string{"hello": "v1"}; !reflect.DeepEqual(promotion.Status.PreviousTags, want) {
+		t.Errorf("previousTags = %v, want %v", promotion.Status.PreviousTags, want)
+	}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(target), target); err != nil {
+		t.Fatal(err)
+	}
+	if target.Spec.AppsList["hello"] != "v2" {
+		t.Errorf("target tag = %q, want v2", target.Spec.AppsList["hello"])
+	}
+}
+
+// concurrentEditClient 读取目标DeployStack后, 模拟其他用户修改world的tag
+type concurrentEditClient struct {
+	client.Client
+	edited bool
+}
+
+func (c *concurrentEditClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if err := c.Client.Get(ctx, key, obj, opts...); err != nil {
+		return err
+	}
+	if _, ok := obj.(*apiv1.DeployStack); !ok || c.edited {
+		return nil
+	}
+	c.edited = true
+	target := &apiv1.DeployStack{}
+	if err := c.Client.Get(ctx, key, target); err != nil {
+		return err
+	}
+	target.Spec.AppsList["world"] = "w2"
+	return c.Client.Update(ctx, target)
+}
+
+func TestPromotionApproval(t *testing.T) {
+	sourceReadyAt := metav1.NewTime(time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC))
+	tests := []struct {
+		name        string
+		approved    bool
+		annotations map[string]string
+		approvedBy  string
+	}{
+		{name: "not approved"},
+		{name: "approval without webhook annotations", approved: true},
+		{name: "approved before source ready", approved: true, annotations: map[string]string{
+			apiv1.ApprovedByAnnotation: "alice", apiv1.ApprovedAtAnnotation: "2023-01-01T09:59:59Z",
+		}},
+		{name: "approved after source ready", approved: true, annotations: map[string]string{
+			apiv1.ApprovedByAnnotation: "alice", apiv1.ApprovedAtAnnotation: "2023-01-01T10:00:00Z",
+		}, approvedBy: "alice"},
+	}
+	for _, test := range tests {
+		target := &apiv1.DeployStack{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "prod"}}
+		target.Spec.AppsList = map[string]string{"hello": "v1", "world": "w1"}
+		promotion := &apiv1.DeployStackPromotion{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "promote", Annotations: test.annotations}}
+		promotion.Spec.Source.Name = "dev"
+		promotion.Spec.Target.Name = "prod"
+		promotion.Spec.RequireApproval = true
+		promotion.Spec.Approved = test.approved
+		promotion.Status.Tags = map[string]string{"hello": "v2"}
+		promotion.Status.SourceReadyAt = &sourceReadyAt
+		c := &concurrentEditClient{Client: fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(target, promotion).Build()}
+		r := &DeployStackPromotionReconciler{Client: c, Log: ctrl.Log.WithName("test"), Scheme: c.Scheme(), Recorder: &record.FakeRecorder{}}
+		ctx := context.Background()
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(promotion)}); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(promotion), promotion); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(target), target); err != nil {
+			t.Fatal(err)
+		}
+		if test.approvedBy == "" {
+			if promotion.Status.Phase != apiv1.PromotionPhaseWaitingForApproval || target.Spec.AppsList["hello"] != "v1" {
+				t.Errorf("%s: phase = %q, target tag = %q, want waiting for approval", test.name, promotion.Status.Phase, target.Spec.AppsList["hello"])
+			}
+			continue
+		}
+		if promotion.Status.Phase != apiv1.PromotionPhaseSucceeded || promotion.Status.ApprovedBy != test.approvedBy {
+			t.Errorf("%s: phase = %q, approvedBy = %q, want %q approved by %q", test.name, promotion.Status.Phase, promotion.Status.ApprovedBy, apiv1.PromotionPhaseSucceeded, test.approvedBy)
+		}
+		// 晋级只修改hello, 保留并发修改的world
+		if want := map[string]string{"hello": "v2", "world": "w2"}; !reflect.DeepEqual(target.Spec.AppsList, want) {
+			t.Errorf("%s: target appsList = %v, want %v", test.name, target.Spec.AppsList, want)
+		}
+	}
+}
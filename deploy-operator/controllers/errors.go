@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"net"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// permanentError 修改spec之前重试也无法成功的错误, 如builder生成资源时的配置校验失败
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// permanent 将错误标记为无需重试
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// isPermanent 错误是否无需重试: builder的配置错误、apiserver拒绝的对象、集群中不存在的资源类型
+// 其余错误(冲突、超时、限流、网络错误等)视为暂时性错误, 按退避时间重试
+func isPermanent(err error) bool {
+	var permanentErr *permanentError
+	if goerrors.As(err, &permanentErr) {
+		return true
+	}
+	var netErr net.Error
+	if goerrors.As(err, &netErr) || goerrors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return errors.IsInvalid(err) || errors.IsBadRequest(err) || errors.IsMethodNotSupported(err) ||
+		errors.IsRequestEntityTooLargeError(err) || meta.IsNoMatchError(err)
+}
+
+// setAppError 在应用状态中记录调谐失败的原因, 错误变化时产生事件
+func (r *DeployStackReconciler) setAppError(deployStack *apiv1.DeployStack, name string, err error, appStatus *apiv1.AppStatus) {
+	appStatus.Phase = apiv1.AppPhaseRetrying
+	if isPermanent(err) {
+		appStatus.Phase = apiv1.AppPhaseFailed
+	}
+	appStatus.Message = err.Error()
+	previous := deployStack.Status.Apps[name]
+	if previous.Phase == appStatus.Phase && previous.Message == appStatus.Message {
+		return
+	}
+	r.Recorder.Eventf(deployStack, corev1.EventTypeWarning, "ReconcileFailed", "Failed to reconcile %s: %v", name, err)
+}
+
+// splitErrors 按是否需要重试拆分调谐过程中的错误
+func splitErrors(errs []error) (transient, permanent []error) {
+	for _, err := range errs {
+		if isPermanent(err) {
+			permanent = append(permanent, err)
+		} else {
+			transient = append(transient, err)
+		}
+	}
+	return transient, permanent
+}
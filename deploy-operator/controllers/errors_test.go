@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestIsPermanent(t *testing.T) {
+	resource := schema.GroupResource{Group: "apps", Resource: "deployments"}
+	kind := schema.GroupKind{Group: "apps", Kind: "Deployment"}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"builder error", permanent(goerrors.New("invalid port")), true},
+		{"wrapped builder error", fmt.Errorf("app hello: %w", permanent(goerrors.New("invalid port"))), true},
+		{"invalid", errors.NewInvalid(kind, "hello", nil), true},
+		{"bad request", errors.NewBadRequest("bad"), true},
+		{"method not supported", errors.NewMethodNotSupported(resource, "patch"), true},
+		{"request entity too large", errors.NewRequestEntityTooLargeError("too large"), true},
+		{"no match", &meta.NoKindMatchError{GroupKind: kind}, true},
+		{"conflict", errors.NewConflict(resource, "hello", goerrors.New("changed")), false},
+		{"too many requests", errors.NewTooManyRequests("slow down", 1), false},
+		{"timeout", errors.NewServerTimeout(resource, "get", 1), false},
+		{"net error", &net.OpError{Op: "dial", Err: goerrors.New("connection refused")}, false},
+		{"deadline exceeded", fmt.Errorf("registry: %w", context.DeadlineExceeded), false},
+		{"unknown", goerrors.New("boom"), false},
+	}
+	for _, test := range tests {
+		if got := isPermanent(test.err); got != test.want {
+			t.Errorf("%s: isPermanent(%v) = %v, want %v", test.name, test.err, got, test.want)
+		}
+	}
+	if permanent(nil) != nil {
+		t.Errorf("permanent(nil) is not nil")
+	}
+}
+
+func TestSplitErrors(t *testing.T) {
+	invalid := permanent(goerrors.New("invalid"))
+	conflict := errors.NewConflict(schema.GroupResource{Resource: "services"}, "hello", goerrors.New("changed"))
+	transient, permanentErrs := splitErrors([]error{invalid, conflict, fmt.Errorf("app hello: %w", invalid)})
+	if !reflect.DeepEqual(transient, []error{conflict}) {
+		t.Errorf("transient = %v, want %v", transient, []error{conflict})
+	}
+	if len(permanentErrs) != 2 {
+		t.Errorf("permanent = %v, want 2 errors", permanentErrs)
+	}
+}
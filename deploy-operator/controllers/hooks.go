@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/resource"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// 钩子执行中或等待发布完成时的重新调谐间隔
+const hookRequeueInterval = 15 * time.Second
+
+// preDeployHook Deployment不存在或tag变化时执行发布前钩子, 返回是否可以更新Deployment
+func (r *DeployStackReconciler) preDeployHook(ctx context.Context, resourceBuilder *resource.DeployStackBuild, name, tag string, appStatus *apiv1.AppStatus) (bool, error) {
+	hook, err := resourceBuilder.Hook().Build(name, tag, resource.ComponentPreDeployHook)
+	if err != nil || hook == nil {
+		return err == nil, err
+	}
+	deploy := &appsv1.Deployment{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: resourceBuilder.Instance.Spec.Namespace, Name: name}, deploy)
+	if client.IgnoreNotFound(err) != nil {
+		return false, err
+	}
+	if err == nil && resourceBuilder.TagDeployed(deploy, name, tag) {
+		return true, nil
+	}
+	return r.runHook(ctx, resourceBuilder.Instance, hook, name, appStatus)
+}
+
+// postDeployHook Deployment发布完成后执行发布后钩子, 返回是否已执行完成
+func (r *DeployStackReconciler) postDeployHook(ctx context.Context, resourceBuilder *resource.DeployStackBuild, name, tag string, appStatus *apiv1.AppStatus) (bool, error) {
+	hook, err := resourceBuilder.Hook().Build(name, tag, resource.ComponentPostDeployHook)
+	if err != nil || hook == nil {
+		return err == nil, err
+	}
+	deploy := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: resourceBuilder.Instance.Spec.Namespace, Name: name}, deploy); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	if !resourceBuilder.TagDeployed(deploy, name, tag) || !deploymentRolledOut(deploy) {
+		return false, nil
+	}
+	return r.runHook(ctx, resourceBuilder.Instance, hook, name, appStatus)
+}
+
+// runHook 创建钩子Job并检查执行结果, 失败时记录到应用状态并产生事件
+func (r *DeployStackReconciler) runHook(ctx context.Context, deployStack *apiv1.DeployStack, hook *batchv1.Job, name string, appStatus *apiv1.AppStatus) (bool, error) {
+	logger := r.Log.WithValues("App", name, "Hook", hook.Name)
+	runningPhase, failedPhase := apiv1.AppPhasePreDeployHookRunning, apiv1.AppPhasePreDeployHookFailed
+	if hook.Labels[resource.ComponentLabel] == resource.ComponentPostDeployHook {
+		runningPhase, failedPhase = apiv1.AppPhasePostDeployHookRunning, apiv1.AppPhasePostDeployHookFailed
+	}
+	if err := r.hooksDelete(ctx, hook); err != nil {
+		return false, err
+	}
+	current := &batchv1.Job{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(hook), current)
+	if client.IgnoreNotFound(err) != nil {
+		return false, err
+	}
+	// 记录创建钩子时的重试注解, 注解变化后失败的钩子重新执行
+	retryAt := deployStack.Annotations[apiv1.RetryHooksAnnotation]
+	if errors.IsNotFound(err) {
+		logger.Info("Start hook")
+		if retryAt != "" {
+			hook.Annotations[apiv1.RetryHooksAnnotation] = retryAt
+		}
+		if err := r.Create(ctx, hook); err != nil {
+			return false, err
+		}
+		r.Recorder.Eventf(deployStack, corev1.EventTypeNormal, "HookStarted", "Started %s for %s with tag %s", hook.Name, name, hook.Annotations[resource.TagAnnotation])
+		appStatus.Phase = runningPhase
+		return false, nil
+	}
+	condition := jobFinishedCondition(current)
+	switch {
+	case condition == nil:
+		appStatus.Phase = runningPhase
+		return false, nil
+	case condition.Type == batchv1.JobFailed && retryAt != "" && retryAt != current.Annotations[apiv1.RetryHooksAnnotation]:
+		// 删除失败的Job, 下次调谐时重新创建
+		logger.Info("Retry hook", "RetryAt", retryAt)
+		if err := r.Delete(ctx, current, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return false, err
+		}
+		r.Recorder.Eventf(deployStack, corev1.EventTypeNormal, "HookRetried", "Retrying %s for %s", hook.Name, name)
+		appStatus.Phase = runningPhase
+		return false, nil
+	case condition.Type == batchv1.JobFailed:
+		if deployStack.Status.Apps[name].Phase != failedPhase {
+			logger.Info("Hook failed", "Reason", condition.Reason)
+			r.Recorder.Eventf(deployStack, corev1.EventTypeWarning, "HookFailed", "%s for %s failed: %s", hook.Name, name, condition.Message)
+		}
+		appStatus.Phase = failedPhase
+		appStatus.Message = condition.Message
+		return false, nil
+	}
+	return true, nil
+}
+
+// hooksDelete 删除应用旧的同类钩子Job
+func (r *DeployStackReconciler) hooksDelete(ctx context.Context, hook *batchv1.Job) error {
+	jobList := &batchv1.JobList{}
+	listOps := []client.ListOption{
+		client.InNamespace(hook.Namespace),
+		client.MatchingLabels{
+			"app":                   hook.Labels["app"],
+			resource.ComponentLabel: hook.Labels[resource.ComponentLabel],
+		},
+	}
+	if err := r.List(ctx, jobList, listOps...); err != nil {
+		return err
+	}
+	for i := range jobList.Items {
+		if jobList.Items[i].Name == hook.Name {
+			continue
+		}
+		if err := r.Delete(ctx, &jobList.Items[i], client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jobFinishedCondition 返回Job的完成或失败状态, 执行中返回nil
+func jobFinishedCondition(job *batchv1.Job) *batchv1.JobCondition {
+	for i, condition := range job.Status.Conditions {
+		if (condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) && condition.Status == corev1.ConditionTrue {
+			return &job.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// deploymentRolledOut Deployment的所有副本都已更新并可用
+func deploymentRolledOut(deploy *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if deploy.Spec.Replicas != nil {
+		replicas = *deploy.Spec.Replicas
+	}
+	return deploy.Status.ObservedGeneration >= deploy.Generation &&
+		deploy.Status.UpdatedReplicas == replicas &&
+		deploy.Status.AvailableReplicas == replicas &&
+		deploy.Status.Replicas == replicas
+}
+
+// appHooksDelete 删除已从appsList中移除的应用的钩子Job
+func (r *DeployStackReconciler) appHooksDelete(ctx context.Context, deployStack *apiv1.DeployStack, listOps *client.ListOptions) error {
+	for _, component := range []string{resource.ComponentPreDeployHook, resource.ComponentPostDeployHook} {
+		jobList := &batchv1.JobList{}
+		if err := r.List(ctx, jobList, listOps, client.MatchingLabels{resource.ComponentLabel: component}); err != nil {
+			return err
+		}
+		for i := range jobList.Items {
+			if _, ok := deployStack.Spec.AppsList[jobList.Items[i].Labels["app"]]; ok {
+				continue
+			}
+			if err := r.Delete(ctx, &jobList.Items[i], client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/resource"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// hookTest 带发布前、发布后钩子的应用hello
+type hookTest struct {
+	t   *testing.T
+	ctx context.Context
+	c   client.Client
+	r   *DeployStackReconciler
+	key types.NamespacedName
+}
+
+func newHookTest(t *testing.T) *hookTest {
+	t.Helper()
+	deployStack := &apiv1.DeployStack{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "stack"}}
+	deployStack.Spec.Namespace = "dev"
+	deployStack.Spec.PortForHttp = 8800
+	deployStack.Spec.AppsList = map[string]string{"hello": "v1"}
+	deployStack.Spec.Apps = map[string]apiv1.AppsName{"hello": {Hooks: &apiv1.AppHooks{
+		PreDeploy:  &apiv1.JobSpec{Command: []string{"migrate"}},
+		PostDeploy: &apiv1.JobSpec{Command: []string{"smoke-test"}},
+	}}}
+	scheme := testScheme(t)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(deployStack).Build()
+	return &hookTest{
+		t:   t,
+		ctx: context.Background(),
+		c:   c,
+		r:   &DeployStackReconciler{Client: c, Log: ctrl.Log.WithName("test"), Scheme: scheme, Recorder: &record.FakeRecorder{}},
+		key: client.ObjectKeyFromObject(deployStack),
+	}
+}
+
+// reconcile 调谐一次并返回应用hello的状态
+func (h *hookTest) reconcile() apiv1.AppStatus {
+	h.t.Helper()
+	if _, err := h.r.Reconcile(h.ctx, ctrl.Request{NamespacedName: h.key}); err != nil {
+		h.t.Fatal(err)
+	}
+	return h.deployStack().Status.Apps["hello"]
+}
+
+func (h *hookTest) deployStack() *apiv1.DeployStack {
+	h.t.Helper()
+	deployStack := &apiv1.DeployStack{}
+	if err := h.c.Get(h.ctx, h.key, deployStack); err != nil {
+		h.t.Fatal(err)
+	}
+	return deployStack
+}
+
+// hooks 返回应用hello的钩子Job
+func (h *hookTest) hooks(component string) []batchv1.Job {
+	h.t.Helper()
+	jobList := &batchv1.JobList{}
+	if err := h.c.List(h.ctx, jobList, client.InNamespace("dev"), client.MatchingLabels{"app": "hello", resource.ComponentLabel: component}); err != nil {
+		h.t.Fatal(err)
+	}
+	return jobList.Items
+}
+
+// finishHook 将钩子Job标记为完成或失败
+func (h *hookTest) finishHook(component string, conditionType batchv1.JobConditionType) {
+	h.t.Helper()
+	hooks := h.hooks(component)
+	if len(hooks) != 1 {
+		h.t.Fatalf("%s jobs = %d, want 1", component, len(hooks))
+	}
+	hook := &hooks[0]
+	hook.Status.Conditions = []batchv1.JobCondition{{Type: conditionType, Status: corev1.ConditionTrue, Message: "exit 1"}}
+	if err := h.c.Update(h.ctx, hook); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// deployment 返回应用hello的Deployment, 不存在时为nil
+func (h *hookTest) deployment() *appsv1.Deployment {
+	h.t.Helper()
+	deploy := &appsv1.Deployment{}
+	err := h.c.Get(h.ctx, types.NamespacedName{Namespace: "dev", Name: "hello"}, deploy)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return deploy
+}
+
+// rollOut 将Deployment的副本标记为已全部更新并可用
+func (h *hookTest) rollOut() {
+	h.t.Helper()
+	deploy := h.deployment()
+	replicas := int32(1)
+	if deploy.Spec.Replicas != nil {
+		replicas = *deploy.Spec.Replicas
+	}
+	deploy.Status = appsv1.DeploymentStatus{Replicas: replicas, UpdatedReplicas: replicas, AvailableReplicas: replicas}
+	if err := h.c.Update(h.ctx, deploy); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+func (h *hookTest) setTag(tag string) {
+	h.t.Helper()
+	deployStack := h.deployStack()
+	deployStack.Spec.AppsList["hello"] = tag
+	if err := h.c.Update(h.ctx, deployStack); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// TestHookGating 发布前钩子完成后才创建Deployment, Deployment发布完成后才执行发布后钩子
+func TestHookGating(t *testing.T) {
+	h := newHookTest(t)
+	if status := h.reconcile(); status.Phase != apiv1.AppPhasePreDeployHookRunning {
+		t.Fatalf("phase = %q, want %q", status.Phase, apiv1.AppPhasePreDeployHookRunning)
+	}
+	if h.deployment() != nil {
+		t.Fatal("deployment created before the pre-deploy hook finished")
+	}
+	if hooks := h.hooks(resource.ComponentPostDeployHook); len(hooks) != 0 {
+		t.Fatal("post-deploy hook started before the deployment")
+	}
+
+	h.finishHook(resource.ComponentPreDeployHook, batchv1.JobComplete)
+	h.reconcile()
+	if h.deployment() == nil {
+		t.Fatal("deployment not created after the pre-deploy hook completed")
+	}
+	if hooks := h.hooks(resource.ComponentPostDeployHook); len(hooks) != 0 {
+		t.Fatal("post-deploy hook started before the deployment rolled out")
+	}
+
+	h.rollOut()
+	if status := h.reconcile(); status.Phase != apiv1.AppPhasePostDeployHookRunning {
+		t.Fatalf("phase = %q, want %q", status.Phase, apiv1.AppPhasePostDeployHookRunning)
+	}
+	h.finishHook(resource.ComponentPostDeployHook, batchv1.JobComplete)
+	if status := h.reconcile(); status.Phase == apiv1.AppPhasePostDeployHookRunning {
+		t.Fatalf("phase = %q after the post-deploy hook completed", status.Phase)
+	}
+
+	// tag变化后重新执行发布前钩子, 旧的钩子Job被删除, Deployment保持旧的tag
+	previous := h.hooks(resource.ComponentPreDeployHook)[0].Name
+	h.setTag("v2")
+	if status := h.reconcile(); status.Phase != apiv1.AppPhasePreDeployHookRunning {
+		t.Fatalf("phase = %q, want %q", status.Phase, apiv1.AppPhasePreDeployHookRunning)
+	}
+	hooks := h.hooks(resource.ComponentPreDeployHook)
+	if len(hooks) != 1 || hooks[0].Name == previous || hooks[0].Annotations[resource.TagAnnotation] != "v2" {
+		t.Fatalf("pre-deploy hooks after the tag change = %v", hooks)
+	}
+	if tag := h.deployment().Annotations[resource.TagAnnotation]; tag != "v1" {
+		t.Errorf("deployment tag = %q before the pre-deploy hook completed, want v1", tag)
+	}
+}
+
+// TestHookRetry 失败的钩子阻止发布, 修改重试注解后重新执行一次
+func TestHookRetry(t *testing.T) {
+	h := newHookTest(t)
+	h.reconcile()
+	h.finishHook(resource.ComponentPreDeployHook, batchv1.JobFailed)
+	if status := h.reconcile(); status.Phase != apiv1.AppPhasePreDeployHookFailed || status.Message != "exit 1" {
+		t.Fatalf("status = %+v, want phase %q", status, apiv1.AppPhasePreDeployHookFailed)
+	}
+	// 未修改注解时不重试
+	if status := h.reconcile(); status.Phase != apiv1.AppPhasePreDeployHookFailed {
+		t.Fatalf("phase = %q, want %q", status.Phase, apiv1.AppPhasePreDeployHookFailed)
+	}
+	if h.deployment() != nil {
+		t.Fatal("deployment created after the pre-deploy hook failed")
+	}
+
+	deployStack := h.deployStack()
+	deployStack.Annotations = map[string]string{apiv1.RetryHooksAnnotation: "1"}
+	if err := h.c.Update(h.ctx, deployStack); err != nil {
+		t.Fatal(err)
+	}
+	if status := h.reconcile(); status.Phase != apiv1.AppPhasePreDeployHookRunning {
+		t.Fatalf("phase = %q after retry, want %q", status.Phase, apiv1.AppPhasePreDeployHookRunning)
+	}
+	if hooks := h.hooks(resource.ComponentPreDeployHook); len(hooks) != 0 {
+		t.Fatalf("failed hook not deleted: %v", hooks)
+	}
+	h.reconcile()
+	hooks := h.hooks(resource.ComponentPreDeployHook)
+	if len(hooks) != 1 || hooks[0].Annotations[apiv1.RetryHooksAnnotation] != "1" {
+		t.Fatalf("retried hooks = %v", hooks)
+	}
+
+	// 同一个注解值只重试一次
+	h.finishHook(resource.ComponentPreDeployHook, batchv1.JobFailed)
+	if status := h.reconcile(); status.Phase != apiv1.AppPhasePreDeployHookFailed {
+		t.Fatalf("phase = %q, want %q", status.Phase, apiv1.AppPhasePreDeployHookFailed)
+	}
+	if hooks := h.hooks(resource.ComponentPreDeployHook); len(hooks) != 1 {
+		t.Fatalf("hook retried twice for the same annotation: %v", hooks)
+	}
+}
+
+// TestAppHooksDelete 应用从appsList中移除后删除其钩子Job
+func TestAppHooksDelete(t *testing.T) {
+	h := newHookTest(t)
+	h.reconcile()
+	deployStack := h.deployStack()
+	deployStack.Spec.AppsList = map[string]string{"world": "v1"}
+	if err := h.c.Update(h.ctx, deployStack); err != nil {
+		t.Fatal(err)
+	}
+	h.reconcile()
+	if hooks := h.hooks(resource.ComponentPreDeployHook); len(hooks) != 0 {
+		t.Errorf("hooks of removed app = %v", hooks)
+	}
+}
@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/registry"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/resource"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// 镜像tag默认检查间隔
+const defaultImageUpdateInterval = 5 * time.Minute
+
+// updateImageTags 按apps[name].imageUpdate检查镜像仓库中最新的tag并更新appsList, 返回应用的镜像状态及距下次检查的间隔
+// appsList更新失败时保留当前tag, 返回受影响应用的错误
+func (r *DeployStackReconciler) updateImageTags(ctx context.Context, resourceBuilder *resource.DeployStackBuild) (map[string]*apiv1.AppImageStatus, time.Duration, map[string]error) {
+	deployStack := resourceBuilder.Instance
+	imageStatuses := map[string]*apiv1.AppImageStatus{}
+	tags := map[string]string{}
+	var requeueAfter time.Duration
+	for _, name := range resource.SortedKeys(deployStack.Spec.AppsList) {
+		policy := deployStack.Spec.Apps[name].ImageUpdate
+		if policy == nil || r.Registry == nil {
+			continue
+		}
+		interval := defaultImageUpdateInterval
+		if policy.Interval != nil && policy.Interval.Duration > 0 {
+			interval = policy.Interval.Duration
+		}
+		// 未到检查时间时沿用上次的结果
+		imageStatus := deployStack.Status.Apps[name].Image
+		if imageStatus != nil && imageStatus.CheckedAt != nil {
+			if wait := interval - time.Since(imageStatus.CheckedAt.Time); wait > 0 {
+				imageStatuses[name] = imageStatus.DeepCopy()
+				requeueAfter = minRequeue(requeueAfter, wait)
+				continue
+			}
+		}
+		requeueAfter = minRequeue(requeueAfter, interval)
+		now := metav1.Now()
+		imageStatus = &apiv1.AppImageStatus{CheckedAt: &now}
+		imageStatuses[name] = imageStatus
+		latest, err := r.latestTag(ctx, resourceBuilder, name, registry.Policy{Semver: policy.Semver, Pattern: policy.Pattern})
+		if err != nil {
+			imageStatus.Message = err.Error()
+			r.Recorder.Eventf(deployStack, corev1.EventTypeWarning, "ImageCheckFailed", "Failed to check image tags of %s: %v", name, err)
+			continue
+		}
+		imageStatus.LatestTag = latest
+		if latest != "" && latest != deployStack.Spec.AppsList[name] {
+			tags[name] = latest
+		}
+	}
+	if len(tags) == 0 {
+		return imageStatuses, requeueAfter, nil
+	}
+	previous := deployStack.DeepCopy()
+	for name, tag := range tags {
+		deployStack.Spec.AppsList[name] = tag
+	}
+	if err := r.Patch(ctx, deployStack, client.MergeFrom(previous)); err != nil {
+		errs := map[string]error{}
+		for name, tag := range tags {
+			deployStack.Spec.AppsList[name] = previous.Spec.AppsList[name]
+			errs[name] = fmt.Errorf("update image tag to %s: %w", tag, err)
+		}
+		return imageStatuses, requeueAfter, errs
+	}
+	for _, name := range resource.SortedKeys(tags) {
+		r.Recorder.Eventf(deployStack, corev1.EventTypeNormal, "ImageUpdated", "Updated %s to tag %s", name, tags[name])
+	}
+	return imageStatuses, requeueAfter, nil
+}
+
+// latestTag 使用应用的镜像仓库凭证获取符合策略的最新tag
+func (r *DeployStackReconciler) latestTag(ctx context.Context, resourceBuilder *resource.DeployStackBuild, name string, policy registry.Policy) (string, error) {
+	repository := resourceBuilder.ImageRepository(name)
+	auth, err := r.registryAuth(ctx, resourceBuilder, name, repository)
+	if err != nil {
+		return "", err
+	}
+	tags, err := r.Registry.Tags(ctx, repository, auth)
+	if err != nil {
+		return "", err
+	}
+	return policy.Latest(tags)
+}
+
+// registryAuth 从应用的imagePullSecrets中查找镜像仓库的账号, 未找到时匿名访问
+func (r *DeployStackReconciler) registryAuth(ctx context.Context, resourceBuilder *resource.DeployStackBuild, name, repository string) (*registry.Auth, error) {
+	host, _ := registry.ParseRepository(repository)
+	for _, reference := range resourceBuilder.ImagePullSecrets(name) {
+		secret := &corev1.Secret{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: resourceBuilder.Instance.Spec.Namespace, Name: reference.Name}, secret)
+		if client.IgnoreNotFound(err) != nil {
+			return nil, err
+		}
+		if err != nil || secret.Type != corev1.SecretTypeDockerConfigJson {
+			continue
+		}
+		auth, err := registry.DockerConfigAuth(secret.Data[corev1.DockerConfigJsonKey], host)
+		if err != nil || auth != nil {
+			return auth, err
+		}
+	}
+	return nil, nil
+}
+
+// resolveDigest 开启pinDigest时将应用镜像的tag解析为digest, 返回距下次解析的间隔
+// 解析失败时沿用同一tag上次的digest, 没有时按tag发布
+func (r *DeployStackReconciler) resolveDigest(ctx context.Context, resourceBuilder *resource.DeployStackBuild, name, tag string, appStatus *apiv1.AppStatus) time.Duration {
+	deployStack := resourceBuilder.Instance
+	if !resourceBuilder.PinDigest(name) || r.Registry == nil {
+		return 0
+	}
+	image, tag := resourceBuilder.ImageTag(name, tag)
+	if appStatus.Image == nil {
+		appStatus.Image = &apiv1.AppImageStatus{}
+	}
+	imageStatus := appStatus.Image
+	if previous := deployStack.Status.Apps[name].Image; previous != nil && previous.Image == image {
+		imageStatus.Image, imageStatus.Digest, imageStatus.ResolvedAt = previous.Image, previous.Digest, previous.ResolvedAt
+	} else {
+		imageStatus.Image, imageStatus.Digest, imageStatus.ResolvedAt = image, "", nil
+	}
+	interval := defaultImageUpdateInterval
+	if policy := deployStack.Spec.Apps[name].ImageUpdate; policy != nil && policy.Interval != nil && policy.Interval.Duration > 0 {
+		interval = policy.Interval.Duration
+	}
+	if resourceBuilder.Digests == nil {
+		resourceBuilder.Digests = map[string]string{}
+	}
+	if imageStatus.Digest != "" && imageStatus.ResolvedAt != nil {
+		if wait := interval - time.Since(imageStatus.ResolvedAt.Time); wait > 0 {
+			resourceBuilder.Digests[image] = imageStatus.Digest
+			return wait
+		}
+	}
+	repository := resourceBuilder.ImageRepository(name)
+	auth, err := r.registryAuth(ctx, resourceBuilder, name, repository)
+	var digest string
+	if err == nil {
+		digest, err = r.Registry.Digest(ctx, repository, tag, auth)
+	}
+	if err != nil {
+		r.Recorder.Eventf(deployStack, corev1.EventTypeWarning, "DigestResolveFailed", "Failed to resolve digest of %s: %v", image, err)
+		imageStatus.Message = strings.TrimPrefix(imageStatus.Message+"; "+err.Error(), "; ")
+		if imageStatus.Digest != "" {
+			resourceBuilder.Digests[image] = imageStatus.Digest
+		}
+		return interval
+	}
+	if imageStatus.Digest != "" && imageStatus.Digest != digest {
+		r.Recorder.Eventf(deployStack, corev1.EventTypeNormal, "DigestChanged", "Digest of %s changed to %s", image, digest)
+	}
+	now := metav1.Now()
+	imageStatus.Digest, imageStatus.ResolvedAt = digest, &now
+	resourceBuilder.Digests[image] = digest
+	return interval
+}
+
+// minRequeue 返回两个重新调谐间隔中较小的一个, 0表示未设置
+func minRequeue(a, b time.Duration) time.Duration {
+	if a == 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/resource"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// 对象变更的类型
+const (
+	actionCreate = "create"
+	actionUpdate = "update"
+	actionDelete = "delete"
+)
+
+var (
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "deploystack_reconcile_duration_seconds",
+		Help:    "Duration of DeployStack reconciles.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"namespace", "name"})
+	objectChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deploystack_object_changes_total",
+		Help: "Objects created, updated or deleted by the DeployStack controller.",
+	}, []string{"namespace", "name", "kind", "action"})
+	builderErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deploystack_builder_errors_total",
+		Help: "Errors returned by resource builders, by the kind of object being built.",
+	}, []string{"namespace", "name", "kind"})
+	appDesiredReplicas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "deploystack_app_desired_replicas",
+		Help: "Desired replicas of an app Deployment.",
+	}, []string{"namespace", "name", "app"})
+	appReadyReplicas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "deploystack_app_ready_replicas",
+		Help: "Ready replicas of an app Deployment.",
+	}, []string{"namespace", "name", "app"})
+	appRolloutInProgress = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "deploystack_app_rollout_in_progress",
+		Help: "Whether an app Deployment is still rolling out (1) or fully rolled out (0).",
+	}, []string{"namespace", "name", "app"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(reconcileDuration, objectChanges, builderErrors, appDesiredReplicas, appReadyReplicas, appRolloutInProgress)
+}
+
+type deployStackKey struct{}
+
+// withDeployStack 在ctx中记录当前调谐的DeployStack, 用于指标的标签
+func withDeployStack(ctx context.Context, deployStack types.NamespacedName) context.Context {
+	return context.WithValue(ctx, deployStackKey{}, deployStack)
+}
+
+func deployStackFrom(ctx context.Context) (types.NamespacedName, bool) {
+	deployStack, ok := ctx.Value(deployStackKey{}).(types.NamespacedName)
+	return deployStack, ok
+}
+
+// observeReconcile 记录调谐耗时
+func observeReconcile(deployStack types.NamespacedName, start time.Time) {
+	reconcileDuration.WithLabelValues(deployStack.Namespace, deployStack.Name).Observe(time.Since(start).Seconds())
+}
+
+// metricsClient 统计调谐过程中按类型创建、更新、删除的对象
+type metricsClient struct {
+	client.Client
+	scheme *runtime.Scheme
+}
+
+func (c *metricsClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	err := c.Client.Create(ctx, obj, opts...)
+	c.record(ctx, obj, actionCreate, err)
+	return err
+}
+
+func (c *metricsClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	err := c.Client.Update(ctx, obj, opts...)
+	c.record(ctx, obj, actionUpdate, err)
+	return err
+}
+
+func (c *metricsClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	err := c.Client.Patch(ctx, obj, patch, opts...)
+	c.record(ctx, obj, actionUpdate, err)
+	return err
+}
+
+func (c *metricsClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	err := c.Client.Delete(ctx, obj, opts...)
+	c.record(ctx, obj, actionDelete, err)
+	return err
+}
+
+func (c *metricsClient) record(ctx context.Context, obj client.Object, action string, err error) {
+	deployStack, ok := deployStackFrom(ctx)
+	if !ok || err != nil {
+		return
+	}
+	kind := objectKind(obj, c.scheme)
+	objectChanges.WithLabelValues(deployStack.Namespace, deployStack.Name, kind, action).Inc()
+	stackSeries.add(deployStack, objectChanges, kind, action)
+}
+
+func objectKind(obj runtime.Object, scheme *runtime.Scheme) string {
+	gvk, err := apiutil.GVKForObject(obj, scheme)
+	if err != nil {
+		return "Unknown"
+	}
+	return gvk.Kind
+}
+
+// builderError 记录builder生成资源失败的次数, builder的错误来自配置, 标记为无需重试
+func (r *DeployStackReconciler) builderError(ctx context.Context, builder resource.ResourceBuilder, err error) error {
+	deployStack, ok := deployStackFrom(ctx)
+	if err == nil || !ok {
+		return permanent(err)
+	}
+	kind := "Unknown"
+	if obj, kindErr := builder.GetObjectKind(); kindErr == nil {
+		kind = objectKind(obj, r.Scheme)
+	}
+	builderErrors.WithLabelValues(deployStack.Namespace, deployStack.Name, kind).Inc()
+	stackSeries.add(deployStack, builderErrors, kind)
+	return permanent(err)
+}
+
+// stackSeries 每个DeployStack在objectChanges、builderErrors中已上报的标签, DeployStack删除时清理
+// client_golang v1.12 的Vec不支持按部分标签删除
+var stackSeries = seriesTracker{series: map[types.NamespacedName]map[*prometheus.CounterVec]map[string][]string{}}
+
+type seriesTracker struct {
+	sync.Mutex
+	series map[types.NamespacedName]map[*prometheus.CounterVec]map[string][]string
+}
+
+// add 记录DeployStack在vec中除namespace、name外的标签值
+func (t *seriesTracker) add(key types.NamespacedName, vec *prometheus.CounterVec, labels ...string) {
+	t.Lock()
+	defer t.Unlock()
+	if t.series[key] == nil {
+		t.series[key] = map[*prometheus.CounterVec]map[string][]string{}
+	}
+	if t.series[key][vec] == nil {
+		t.series[key][vec] = map[string][]string{}
+	}
+	t.series[key][vec][strings.Join(labels, "/")] = labels
+}
+
+// forget 删除DeployStack已上报的所有标签
+func (t *seriesTracker) forget(key types.NamespacedName) {
+	t.Lock()
+	defer t.Unlock()
+	for vec, series := range t.series[key] {
+		for _, labels := range series {
+			vec.DeleteLabelValues(append([]string{key.Namespace, key.Name}, labels...)...)
+		}
+	}
+	delete(t.series, key)
+}
+
+// appMetricApps 每个DeployStack已上报副本数指标的应用, 应用移除或DeployStack删除时清理
+var appMetricApps = struct {
+	sync.Mutex
+	apps map[types.NamespacedName]map[string]bool
+}{apps: map[types.NamespacedName]map[string]bool{}}
+
+// recordAppMetrics 上报appsList中应用Deployment的副本数及发布状态, 返回是否有应用正在发布
+func (r *DeployStackReconciler) recordAppMetrics(ctx context.Context, deployStack *apiv1.DeployStack) (bool, error) {
+	key := client.ObjectKeyFromObject(deployStack)
+	apps := map[string]bool{}
+	rollingOut := false
+	for _, name := range resource.SortedKeys(deployStack.Spec.AppsList) {
+		deploy := &appsv1.Deployment{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: deployStack.Spec.Namespace, Name: name}, deploy)
+		if client.IgnoreNotFound(err) != nil {
+			return false, err
+		}
+		if err != nil {
+			continue
+		}
+		desired := int32(1)
+		if deploy.Spec.Replicas != nil {
+			desired = *deploy.Spec.Replicas
+		}
+		rollout := 0.0
+		if !deploymentRolledOut(deploy) {
+			rollout = 1
+			rollingOut = true
+		}
+		appDesiredReplicas.WithLabelValues(key.Namespace, key.Name, name).Set(float64(desired))
+		appReadyReplicas.WithLabelValues(key.Namespace, key.Name, name).Set(float64(deploy.Status.ReadyReplicas))
+		appRolloutInProgress.WithLabelValues(key.Namespace, key.Name, name).Set(rollout)
+		apps[name] = true
+	}
+	forgetAppMetrics(key, apps)
+	return rollingOut, nil
+}
+
+// forgetAppMetrics 删除不在apps中的应用指标
+func forgetAppMetrics(key types.NamespacedName, apps map[string]bool) {
+	appMetricApps.Lock()
+	defer appMetricApps.Unlock()
+	for name := range appMetricApps.apps[key] {
+		if apps[name] {
+			continue
+		}
+		for _, gauge := range []*prometheus.GaugeVec{appDesiredReplicas, appReadyReplicas, appRolloutInProgress} {
+			gauge.DeleteLabelValues(key.Namespace, key.Name, name)
+		}
+	}
+	appMetricApps.apps[key] = apps
+}
+
+// forgetDeployStackMetrics DeployStack删除后清理其指标
+func forgetDeployStackMetrics(key types.NamespacedName) {
+	forgetAppMetrics(key, nil)
+	appMetricApps.Lock()
+	delete(appMetricApps.apps, key)
+	appMetricApps.Unlock()
+	reconcileDuration.DeleteLabelValues(key.Namespace, key.Name)
+	stackSeries.forget(key)
+}
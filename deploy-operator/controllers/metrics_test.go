@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"testing"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/resource"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestForgetDeployStackMetrics(t *testing.T) {
+	key := types.NamespacedName{Namespace: "metrics-test", Name: "stack"}
+	ctx := withDeployStack(context.Background(), key)
+	c := &metricsClient{Client: fake.NewClientBuilder().Build(), scheme: scheme.Scheme}
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "dev", Name: "config"}}
+	if err := c.Create(ctx, configMap); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(ctx, configMap); err != nil {
+		t.Fatal(err)
+	}
+	r := &DeployStackReconciler{Scheme: scheme.Scheme}
+	r.builderError(ctx, (&resource.DeployStackBuild{Instance: &apiv1.DeployStack{}}).ConfigMap(), goerrors.New("invalid"))
+
+	forgetDeployStackMetrics(key)
+	for _, labels := range [][]string{{"ConfigMap", actionCreate}, {"ConfigMap", actionDelete}} {
+		if objectChanges.DeleteLabelValues(key.Namespace, key.Name, labels[0], labels[1]) {
+			t.Errorf("objectChanges%v not deleted", labels)
+		}
+	}
+	if builderErrors.DeleteLabelValues(key.Namespace, key.Name, "ConfigMap") {
+		t.Errorf("builderErrors not deleted")
+	}
+}
@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/resource"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// 每个应用默认保留的发布版本数量
+const defaultRevisionHistoryLimit = 10
+
+// appRevisionTag 沿用应用的版本记录, 处理回滚注解, 返回实际发布的tag
+func (r *DeployStackReconciler) appRevisionTag(deployStack *apiv1.DeployStack, name, tag string, appStatus *apiv1.AppStatus) string {
+	current := deployStack.Status.Apps[name]
+	appStatus.Revisions = current.Revisions
+	appStatus.Rollback = current.Rollback
+	appStatus.LastHandledRollback = current.LastHandledRollback
+	// appsList中的tag变化后, 之前的回滚失效
+	if appStatus.Rollback != nil && appStatus.Rollback.FromTag != tag {
+		appStatus.Rollback = nil
+	}
+	request := deployStack.Annotations[apiv1.RollbackAnnotationPrefix+name]
+	if request != "" && request != appStatus.LastHandledRollback {
+		appStatus.LastHandledRollback = request
+		revision, err := strconv.ParseInt(request, 10, 64)
+		target := findRevision(appStatus.Revisions, revision)
+		if err != nil || target == nil {
+			r.Recorder.Eventf(deployStack, corev1.EventTypeWarning, "RollbackFailed", "Revision %q of %s not found", request, name)
+		} else {
+			appStatus.Rollback = &apiv1.AppRollback{Revision: target.Revision, Tag: target.Tag, FromTag: tag, Reason: apiv1.RollbackReasonManual}
+			r.Recorder.Eventf(deployStack, corev1.EventTypeNormal, "RolledBack", "Rolled back %s to revision %d with tag %s", name, target.Revision, target.Tag)
+		}
+	}
+	if appStatus.Rollback == nil {
+		return tag
+	}
+	setRolledBack(appStatus)
+	return appStatus.Rollback.Tag
+}
+
+func setRolledBack(appStatus *apiv1.AppStatus) {
+	rollback := appStatus.Rollback
+	appStatus.Phase = apiv1.AppPhaseRolledBack
+	appStatus.Message = fmt.Sprintf("rolled back from tag %s to revision %d (%s): %s", rollback.FromTag, rollback.Revision, rollback.Tag, rollback.Reason)
+}
+
+// recordRevision Deployment更新后, tag或Pod模版与最新版本不同时记录新版本
+func (r *DeployStackReconciler) recordRevision(resourceBuilder *resource.DeployStackBuild, name, tag string, appStatus *apiv1.AppStatus) error {
+	templateHash, err := resourceBuilder.Deployment().TemplateHash(name, tag)
+	if err != nil {
+		return err
+	}
+	var revision int64 = 1
+	if count := len(appStatus.Revisions); count > 0 {
+		latest := appStatus.Revisions[count-1]
+		if latest.Tag == tag && latest.TemplateHash == templateHash {
+			return nil
+		}
+		revision = latest.Revision + 1
+	}
+	revisions := append([]apiv1.AppRevision{}, appStatus.Revisions...)
+	revisions = append(revisions, apiv1.AppRevision{
+		Revision:     revision,
+		Tag:          tag,
+		TemplateHash: templateHash,
+		Time:         metav1.Now(),
+		ChangedBy:    specManager(resourceBuilder.Instance),
+	})
+	limit := defaultRevisionHistoryLimit
+	if resourceBuilder.Instance.Spec.RevisionHistoryLimit != nil {
+		limit = int(*resourceBuilder.Instance.Spec.RevisionHistoryLimit)
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	if len(revisions) > limit {
+		revisions = revisions[len(revisions)-limit:]
+	}
+	appStatus.Revisions = revisions
+	return nil
+}
+
+// autoRollback Deployment超过发布期限时回滚到上一个不同tag的版本, 返回是否已回滚
+func (r *DeployStackReconciler) autoRollback(ctx context.Context, resourceBuilder *resource.DeployStackBuild, name, tag string, appStatus *apiv1.AppStatus) (bool, error) {
+	deployStack := resourceBuilder.Instance
+	if !deployStack.Spec.AutoRollback || appStatus.Rollback != nil {
+		return false, nil
+	}
+	deploy := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: deployStack.Spec.Namespace, Name: name}, deploy); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	if !resourceBuilder.TagDeployed(deploy, name, tag) || !progressDeadlineExceeded(deploy) {
+		return false, nil
+	}
+	var target *apiv1.AppRevision
+	for i := len(appStatus.Revisions) - 1; i >= 0; i-- {
+		if appStatus.Revisions[i].Tag != tag {
+			target = &appStatus.Revisions[i]
+			break
+		}
+	}
+	if target == nil {
+		return false, nil
+	}
+	appStatus.Rollback = &apiv1.AppRollback{Revision: target.Revision, Tag: target.Tag, FromTag: tag, Reason: apiv1.RollbackReasonProgressDeadlineExceeded}
+	setRolledBack(appStatus)
+	r.Recorder.Eventf(deployStack, corev1.EventTypeWarning, "RolledBack", "Rollout of %s with tag %s exceeded its progress deadline, rolled back to revision %d with tag %s", name, tag, target.Revision, target.Tag)
+	return true, nil
+}
+
+func findRevision(revisions []apiv1.AppRevision, revision int64) *apiv1.AppRevision {
+	for i := range revisions {
+		if revisions[i].Revision == revision {
+			return &revisions[i]
+		}
+	}
+	return nil
+}
+
+// progressDeadlineExceeded Deployment的Progressing状态为ProgressDeadlineExceeded
+func progressDeadlineExceeded(deploy *appsv1.Deployment) bool {
+	for _, condition := range deploy.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Status == corev1.ConditionFalse && condition.Reason == "ProgressDeadlineExceeded" {
+			return true
+		}
+	}
+	return false
+}
+
+// specManager 最近一次修改spec的field manager
+func specManager(object metav1.Object) string {
+	var (
+		manager string
+		latest  *metav1.Time
+	)
+	for _, entry := range object.GetManagedFields() {
+		if entry.Subresource != "" || entry.FieldsV1 == nil || !fieldsContain(entry.FieldsV1.Raw, "f:spec") {
+			continue
+		}
+		if latest == nil || (entry.Time != nil && latest.Before(entry.Time)) {
+			manager, latest = entry.Manager, entry.Time
+		}
+	}
+	return manager
+}
+
+func fieldsContain(raw []byte, field string) bool {
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return false
+	}
+	_, ok := fields[field]
+	return ok
+}
@@ -0,0 +1,270 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/resource"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// revisionStack 应用hello的版本记录为 1:v1 2:v2
+func revisionStack() *apiv1.DeployStack {
+	deployStack := &apiv1.DeployStack{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "stack"}}
+	deployStack.Spec.Namespace = "dev"
+	deployStack.Spec.AppsList = map[string]string{"hello": "v2"}
+	deployStack.Status.Apps = map[string]apiv1.AppStatus{"hello": {Revisions: []apiv1.AppRevision{
+		{Revision: 1, Tag: "v1"},
+		{Revision: 2, Tag: "v2"},
+	}}}
+	return deployStack
+}
+
+func TestAppRevisionTag(t *testing.T) {
+	tests := []struct {
+		name         string
+		annotation   string
+		handled      string
+		rollback     *apiv1.AppRollback
+		tag          string
+		wantTag      string
+		wantRollback *apiv1.AppRollback
+		wantHandled  string
+	}{
+		{name: "no rollback", tag: "v2", wantTag: "v2"},
+		{
+			name:         "rollback annotation",
+			annotation:   "1",
+			tag:          "v2",
+			wantTag:      "v1",
+			wantRollback: &apiv1.AppRollback{Revision: 1, Tag: "v1", FromTag: "v2", Reason: apiv1.RollbackReasonManual},
+			wantHandled:  "1",
+		},
+		{
+			name:         "handled annotation keeps rollback",
+			annotation:   "1",
+			handled:      "1",
+			rollback:     &apiv1.AppRollback{Revision: 1, Tag: "v1", FromTag: "v2", Reason: apiv1.RollbackReasonManual},
+			tag:          "v2",
+			wantTag:      "v1",
+			wantRollback: &apiv1.AppRollback{Revision: 1, Tag: "v1", FromTag: "v2", Reason: apiv1.RollbackReasonManual},
+			wantHandled:  "1",
+		},
+		{name: "handled annotation without rollback", annotation: "1", handled: "1", tag: "v2", wantTag: "v2", wantHandled: "1"},
+		{name: "unknown revision", annotation: "7", tag: "v2", wantTag: "v2", wantHandled: "7"},
+		{name: "invalid revision", annotation: "latest", tag: "v2", wantTag: "v2", wantHandled: "latest"},
+		{
+			name:        "new tag clears rollback",
+			annotation:  "1",
+			handled:     "1",
+			rollback:    &apiv1.AppRollback{Revision: 1, Tag: "v1", FromTag: "v2", Reason: apiv1.RollbackReasonManual},
+			tag:         "v3",
+			wantTag:     "v3",
+			wantHandled: "1",
+		},
+	}
+	for _, test := range tests {
+		deployStack := revisionStack()
+		if test.annotation != "" {
+			deployStack.Annotations = map[string]string{apiv1.RollbackAnnotationPrefix + "hello": test.annotation}
+		}
+		current := deployStack.Status.Apps["hello"]
+		current.LastHandledRollback = test.handled
+		current.Rollback = test.rollback
+		deployStack.Status.Apps["hello"] = current
+		r := &DeployStackReconciler{Recorder: &record.FakeRecorder{}}
+		appStatus := apiv1.AppStatus{}
+		if tag := r.appRevisionTag(deployStack, "hello", test.tag, &appStatus); tag != test.wantTag {
+			t.Errorf("%s: tag = %q, want %q", test.name, tag, test.wantTag)
+		}
+		if !reflect.DeepEqual(appStatus.Rollback, test.wantRollback) {
+			t.Errorf("%s: rollback = %+v, want %+v", test.name, appStatus.Rollback, test.wantRollback)
+		}
+		if appStatus.LastHandledRollback != test.wantHandled {
+			t.Errorf("%s: lastHandledRollback = %q, want %q", test.name, appStatus.LastHandledRollback, test.wantHandled)
+		}
+		if rolledBack := appStatus.Phase == apiv1.AppPhaseRolledBack; rolledBack != (test.wantRollback != nil) {
+			t.Errorf("%s: phase = %q", test.name, appStatus.Phase)
+		}
+	}
+}
+
+func TestRecordRevision(t *testing.T) {
+	revisions := func(tags ...string) []apiv1.AppRevision {
+		var revisions []apiv1.AppRevision
+		for i, tag := range tags {
+			revisions = append(revisions, apiv1.AppRevision{Revision: int64(i + 1), Tag: tag})
+		}
+		return revisions
+	}
+	historyLimit := func(limit int32) *int32 { return &limit }
+	tests := []struct {
+		name      string
+		limit     *int32
+		revisions []apiv1.AppRevision
+		tag       string
+		want      []int64
+	}{
+		{name: "first revision", tag: "v1", want: []int64{1}},
+		{name: "new tag", revisions: revisions("v1"), tag: "v2", want: []int64{1, 2}},
+		{name: "trim to limit", limit: historyLimit(3), revisions: revisions("v1", "v2", "v3"), tag: "v4", want: []int64{2, 3, 4}},
+		{name: "trim after lowering limit", limit: historyLimit(2), revisions: revisions("v1", "v2", "v3", "v4"), tag: "v5", want: []int64{4, 5}},
+		{name: "limit below 1", limit: historyLimit(0), revisions: revisions("v1", "v2"), tag: "v3", want: []int64{3}},
+		{name: "default limit", revisions: revisions("1", "2", "3", "4", "5", "6", "7", "8", "9", "10"), tag: "11", want: []int64{2, 3, 4, 5, 6, 7, 8, 9, 10, 11}},
+	}
+	for _, test := range tests {
+		deployStack := revisionStack()
+		deployStack.Spec.RevisionHistoryLimit = test.limit
+		resourceBuilder := &resource.DeployStackBuild{Instance: deployStack}
+		appStatus := apiv1.AppStatus{Revisions: test.revisions}
+		r := &DeployStackReconciler{}
+		if err := r.recordRevision(resourceBuilder, "hello", test.tag, &appStatus); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		var got []int64
+		for _, revision := range appStatus.Revisions {
+			got = append(got, revision.Revision)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: revisions = %v, want %v", test.name, got, test.want)
+		}
+		if latest := appStatus.Revisions[len(appStatus.Revisions)-1]; latest.Tag != test.tag {
+			t.Errorf("%s: latest tag = %q, want %q", test.name, latest.Tag, test.tag)
+		}
+	}
+
+	// tag及Pod模版未变化时不记录新版本
+	resourceBuilder := &resource.DeployStackBuild{Instance: revisionStack()}
+	appStatus := apiv1.AppStatus{}
+	r := &DeployStackReconciler{}
+	for i := 0; i < 2; i++ {
+		if err := r.recordRevision(resourceBuilder, "hello", "v2", &appStatus); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(appStatus.Revisions) != 1 {
+		t.Errorf("revisions for an unchanged deployment = %v, want 1", appStatus.Revisions)
+	}
+}
+
+func TestAutoRollback(t *testing.T) {
+	exceeded := []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: "ProgressDeadlineExceeded"}}
+	progressing := []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionTrue, Reason: "ReplicaSetUpdated"}}
+	tests := []struct {
+		name         string
+		autoRollback bool
+		deployedTag  string
+		conditions   []appsv1.DeploymentCondition
+		rollback     *apiv1.AppRollback
+		want         *apiv1.AppRollback
+	}{
+		{
+			name:         "progress deadline exceeded",
+			autoRollback: true,
+			deployedTag:  "v2",
+			conditions:   exceeded,
+			want:         &apiv1.AppRollback{Revision: 1, Tag: "v1", FromTag: "v2", Reason: apiv1.RollbackReasonProgressDeadlineExceeded},
+		},
+		{name: "disabled", deployedTag: "v2", conditions: exceeded},
+		{name: "progressing", autoRollback: true, deployedTag: "v2", conditions: progressing},
+		{name: "tag not deployed", autoRollback: true, deployedTag: "v1", conditions: exceeded},
+		{
+			name:         "already rolled back",
+			autoRollback: true,
+			deployedTag:  "v2",
+			conditions:   exceeded,
+			rollback:     &apiv1.AppRollback{Revision: 1, Tag: "v1", FromTag: "v2", Reason: apiv1.RollbackReasonManual},
+			want:         &apiv1.AppRollback{Revision: 1, Tag: "v1", FromTag: "v2", Reason: apiv1.RollbackReasonManual},
+		},
+	}
+	for _, test := range tests {
+		deployStack := revisionStack()
+		deployStack.Spec.AutoRollback = test.autoRollback
+		deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "dev",
+			Name:        "hello",
+			Annotations: map[string]string{resource.TagAnnotation: test.deployedTag},
+		}}
+		deploy.Status.Conditions = test.conditions
+		r := &DeployStackReconciler{
+			Client:   fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(deploy).Build(),
+			Recorder: &record.FakeRecorder{},
+		}
+		appStatus := deployStack.Status.Apps["hello"]
+		appStatus.Rollback = test.rollback
+		rolledBack, err := r.autoRollback(context.Background(), &resource.DeployStackBuild{Instance: deployStack}, "hello", "v2", &appStatus)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if wantRolledBack := test.want != nil && test.rollback == nil; rolledBack != wantRolledBack {
+			t.Errorf("%s: rolledBack = %v, want %v", test.name, rolledBack, wantRolledBack)
+		}
+		if !reflect.DeepEqual(appStatus.Rollback, test.want) {
+			t.Errorf("%s: rollback = %+v, want %+v", test.name, appStatus.Rollback, test.want)
+		}
+	}
+}
+
+// TestAutoRollbackReconcile 超过发布期限后按上一个版本的tag更新Deployment
+func TestAutoRollbackReconcile(t *testing.T) {
+	ctx := context.Background()
+	deployStack := revisionStack()
+	deployStack.Spec.PortForHttp = 8800
+	deployStack.Spec.AutoRollback = true
+	deployStack.Spec.AppsList["hello"] = "v1"
+	scheme := testScheme(t)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(deployStack).Build()
+	r := &DeployStackReconciler{Client: c, Log: ctrl.Log.WithName("test"), Scheme: scheme, Recorder: &record.FakeRecorder{}}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(deployStack)}
+	reconcile := func() {
+		t.Helper()
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Get(ctx, req.NamespacedName, deployStack); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reconcile()
+	deployStack.Spec.AppsList["hello"] = "v2"
+	if err := c.Update(ctx, deployStack); err != nil {
+		t.Fatal(err)
+	}
+	reconcile()
+
+	deploy := &appsv1.Deployment{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "dev", Name: "hello"}, deploy); err != nil {
+		t.Fatal(err)
+	}
+	if tag := deploy.Annotations[resource.TagAnnotation]; tag != "v2" {
+		t.Fatalf("deployment tag = %q, want v2", tag)
+	}
+	deploy.Status.Conditions = []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: "ProgressDeadlineExceeded"}}
+	if err := c.Update(ctx, deploy); err != nil {
+		t.Fatal(err)
+	}
+	reconcile()
+	reconcile()
+	if err := c.Get(ctx, client.ObjectKeyFromObject(deploy), deploy); err != nil {
+		t.Fatal(err)
+	}
+	if tag := deploy.Annotations[resource.TagAnnotation]; tag != "v1" {
+		t.Errorf("deployment tag = %q after auto rollback, want v1", tag)
+	}
+	status := deployStack.Status.Apps["hello"]
+	if status.Phase != apiv1.AppPhaseRolledBack || status.Rollback == nil || status.Rollback.Reason != apiv1.RollbackReasonProgressDeadlineExceeded {
+		t.Errorf("status = %+v, want rolled back for %s", status, apiv1.RollbackReasonProgressDeadlineExceeded)
+	}
+	// 回滚后的发布同样记录为新版本
+	if latest := status.Revisions[len(status.Revisions)-1]; latest.Tag != "v1" {
+		t.Errorf("latest revision = %+v, want the rolled back tag v1", latest)
+	}
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/resource"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// 有应用正在发布时的重新调谐间隔
+const rolloutRequeueInterval = 30 * time.Second
+
+// 应用的发布状态
+const (
+	rolloutDone = iota
+	rolloutInProgress
+	rolloutPending
+	// 钩子执行失败, 不占用发布名额, 但所在波次无法完成
+	rolloutFailed
+)
+
+// rolloutPlan 按spec.rolloutPolicy计算的本次调谐中暂不发布的应用
+type rolloutPlan struct {
+	// 排队等待发布的应用及原因
+	queued map[string]string
+	// 无法获取发布状态的应用, 暂不发布
+	errs   map[string]error
+	status *apiv1.RolloutStatus
+}
+
+// planRollout 统计正在发布的应用, 按波次及maxConcurrentApps决定需要发布的应用中哪些可以开始发布
+// 无法获取发布状态的应用排队等待, 错误记录在plan.errs中
+func (r *DeployStackReconciler) planRollout(ctx context.Context, resourceBuilder *resource.DeployStackBuild, order []string, tags map[string]string) *rolloutPlan {
+	plan := &rolloutPlan{queued: map[string]string{}, errs: map[string]error{}}
+	policy := resourceBuilder.Instance.Spec.RolloutPolicy
+	if policy == nil {
+		return plan
+	}
+	var (
+		done       int32
+		inProgress []string
+		pending    []string
+	)
+	currentWave := -1
+	for _, name := range order {
+		state := rolloutDone
+		if !resourceBuilder.Suspended(name) {
+			var err error
+			if state, err = r.rolloutState(ctx, resourceBuilder, name, tags[name]); err != nil {
+				plan.errs[name] = fmt.Errorf("get rollout state: %w", err)
+				plan.queued[name] = "waiting for rollout state"
+				continue
+			}
+		}
+		switch state {
+		case rolloutDone:
+			done++
+			continue
+		case rolloutInProgress:
+			inProgress = append(inProgress, name)
+		case rolloutPending:
+			pending = append(pending, name)
+		}
+		if wave := resourceBuilder.RolloutWave(name); currentWave < 0 || wave < currentWave {
+			currentWave = wave
+		}
+	}
+	for _, name := range pending {
+		switch {
+		case resourceBuilder.RolloutWave(name) > currentWave:
+			plan.queued[name] = fmt.Sprintf("waiting for wave %s", resourceBuilder.RolloutWaveName(currentWave))
+		case policy.MaxConcurrentApps > 0 && len(inProgress) >= int(policy.MaxConcurrentApps):
+			plan.queued[name] = fmt.Sprintf("waiting for %d apps in progress, maxConcurrentApps is %d", len(inProgress), policy.MaxConcurrentApps)
+		default:
+			inProgress = append(inProgress, name)
+		}
+	}
+	plan.status = &apiv1.RolloutStatus{
+		Done:       done,
+		Total:      int32(len(order)),
+		Progress:   fmt.Sprintf("%d/%d", done, len(order)),
+		InProgress: inProgress,
+	}
+	if currentWave >= 0 {
+		plan.status.Wave = resourceBuilder.RolloutWaveName(currentWave)
+	}
+	sort.Strings(plan.status.InProgress)
+	for _, name := range resource.SortedKeys(plan.queued) {
+		plan.status.Queued = append(plan.status.Queued, name)
+	}
+	return plan
+}
+
+// rolloutState 根据Deployment及上次调谐的钩子状态判断应用的发布状态
+func (r *DeployStackReconciler) rolloutState(ctx context.Context, resourceBuilder *resource.DeployStackBuild, name, tag string) (int, error) {
+	phase := resourceBuilder.Instance.Status.Apps[name].Phase
+	deploy := &appsv1.Deployment{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: resourceBuilder.Instance.Spec.Namespace, Name: name}, deploy)
+	if client.IgnoreNotFound(err) != nil {
+		return 0, err
+	}
+	tagDeployed := err == nil && resourceBuilder.TagDeployed(deploy, name, tag)
+	switch {
+	case phase == apiv1.AppPhasePreDeployHookRunning && !tagDeployed:
+		return rolloutInProgress, nil
+	case phase == apiv1.AppPhasePreDeployHookFailed && !tagDeployed:
+		return rolloutFailed, nil
+	case !tagDeployed:
+		return rolloutPending, nil
+	case !deploymentRolledOut(deploy) || phase == apiv1.AppPhasePostDeployHookRunning:
+		return rolloutInProgress, nil
+	case phase == apiv1.AppPhasePostDeployHookFailed:
+		return rolloutFailed, nil
+	}
+	return rolloutDone, nil
+}
@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/resource"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// testDeployment 按tag发布的Deployment, rolledOut 为false时仍在发布中
+func testDeployment(name, tag string, rolledOut bool) *appsv1.Deployment {
+	replicas := int32(1)
+	deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "dev",
+		Name:        name,
+		Annotations: map[string]string{resource.TagAnnotation: tag},
+	}}
+	deploy.Spec.Replicas = &replicas
+	if rolledOut {
+		deploy.Status = appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	}
+	return deploy
+}
+
+func TestPlanRollout(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      *apiv1.RolloutPolicy
+		suspended   []string
+		deployments []client.Object
+		queued      []string
+		status      *apiv1.RolloutStatus
+	}{
+		{
+			name: "no policy",
+		},
+		{
+			name:        "maxConcurrentApps",
+			policy:      &apiv1.RolloutPolicy{MaxConcurrentApps: 2},
+			deployments: []client.Object{testDeployment("a", "v2", false), testDeployment("b", "v1", true)},
+			queued:      []string{"c", "d"},
+			status:      &apiv1.RolloutStatus{Total: 4, Progress: "0/4", InProgress: []string{"a", "b"}, Queued: []string{"c", "d"}},
+		},
+		{
+			name:        "first wave",
+			policy:      &apiv1.RolloutPolicy{Waves: []apiv1.RolloutWave{{Name: "infra", Apps: []string{"a", "b"}}}},
+			deployments: []client.Object{testDeployment("a", "v2", true)},
+			queued:      []string{"c", "d"},
+			status:      &apiv1.RolloutStatus{Wave: "infra", Done: 1, Total: 4, Progress: "1/4", InProgress: []string{"b"}, Queued: []string{"c", "d"}},
+		},
+		{
+			name:        "last wave with maxConcurrentApps",
+			policy:      &apiv1.RolloutPolicy{MaxConcurrentApps: 1, Waves: []apiv1.RolloutWave{{Name: "infra", Apps: []string{"a"}}}},
+			deployments: []client.Object{testDeployment("a", "v2", true)},
+			queued:      []string{"c", "d"},
+			status:      &apiv1.RolloutStatus{Done: 1, Total: 4, Progress: "1/4", InProgress: []string{"b"}, Queued: []string{"c", "d"}},
+		},
+		{
+			name:        "suspended apps count as done",
+			policy:      &apiv1.RolloutPolicy{MaxConcurrentApps: 1},
+			suspended:   []string{"a", "b"},
+			deployments: []client.Object{testDeployment("c", "v2", true)},
+			status:      &apiv1.RolloutStatus{Done: 3, Total: 4, Progress: "3/4", InProgress: []string{"d"}},
+		},
+		{
+			name:        "all done",
+			policy:      &apiv1.RolloutPolicy{MaxConcurrentApps: 1},
+			deployments: []client.Object{testDeployment("a", "v2", true), testDeployment("b", "v2", true), testDeployment("c", "v2", true), testDeployment("d", "v2", true)},
+			status:      &apiv1.RolloutStatus{Done: 4, Total: 4, Progress: "4/4"},
+		},
+	}
+	order := []string{"a", "b", "c", "d"}
+	tags := map[string]string{"a": "v2", "b": "v2", "c": "v2", "d": "v2"}
+	for _, test := range tests {
+		deployStack := &apiv1.DeployStack{}
+		deployStack.Spec.Namespace = "dev"
+		deployStack.Spec.AppsList = tags
+		deployStack.Spec.RolloutPolicy = test.policy
+		deployStack.Spec.Apps = map[string]apiv1.AppsName{}
+		for _, name := range test.suspended {
+			deployStack.Spec.Apps[name] = apiv1.AppsName{Suspended: true}
+		}
+		r := &DeployStackReconciler{Client: fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(test.deployments...).Build()}
+		plan := r.planRollout(context.Background(), &resource.DeployStackBuild{Instance: deployStack}, order, tags)
+		if len(plan.errs) > 0 {
+			t.Fatalf("%s: %v", test.name, plan.errs)
+		}
+		queued := resource.SortedKeys(plan.queued)
+		if len(queued) == 0 {
+			queued = nil
+		}
+		if !reflect.DeepEqual(queued, test.queued) {
+			t.Errorf("%s: queued = %v, want %v", test.name, queued, test.queued)
+		}
+		if !reflect.DeepEqual(plan.status, test.status) {
+			t.Errorf("%s: status = %+v, want %+v", test.name, plan.status, test.status)
+		}
+	}
+}
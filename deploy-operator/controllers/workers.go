@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/resource"
+)
+
+// 未设置AppWorkers时并发调谐的应用数
+const defaultAppWorkers = 4
+
+// appResult 单个应用的调谐结果
+type appResult struct {
+	status  apiv1.AppStatus
+	done    bool
+	requeue bool
+	err     error
+}
+
+// dependencyLevels 按依赖关系将应用分层, 应用所在层比其依赖所在层大1, 同层应用之间没有依赖, 层内保持order中的顺序
+// 成环或不在appsList中的依赖不参与分层, 由waitForDependencies处理
+func dependencyLevels(resourceBuilder *resource.DeployStackBuild, order []string) [][]string {
+	var levels [][]string
+	level := map[string]int{}
+	for _, name := range order {
+		current := 0
+		for _, dependency := range resourceBuilder.DependsOn(name) {
+			if dependencyLevel, ok := level[dependency]; ok && dependencyLevel+1 > current {
+				current = dependencyLevel + 1
+			}
+		}
+		level[name] = current
+		if current == len(levels) {
+			levels = append(levels, nil)
+		}
+		levels[current] = append(levels[current], name)
+	}
+	return levels
+}
+
+// reconcileApps 按依赖分层调谐应用, 同层应用最多AppWorkers个并发, 上一层结束后再调谐下一层
+// 每个应用使用DeployStack的副本生成资源; deployed 只在每层结束后更新, 调谐中只读
+func (r *DeployStackReconciler) reconcileApps(ctx context.Context, resourceBuilder *resource.DeployStackBuild, order []string, tags map[string]string, appStatuses map[string]apiv1.AppStatus, deployed map[string]string, plan *rolloutPlan) map[string]*appResult {
+	workers := r.AppWorkers
+	if workers < 1 {
+		workers = defaultAppWorkers
+	}
+	results := map[string]*appResult{}
+	for _, names := range dependencyLevels(resourceBuilder, order) {
+		levelResults := make([]*appResult, len(names))
+		semaphore := make(chan struct{}, workers)
+		var wg sync.WaitGroup
+		for i, name := range names {
+			wg.Add(1)
+			semaphore <- struct{}{}
+			go func(i int, name string) {
+				defer func() {
+					<-semaphore
+					wg.Done()
+				}()
+				appBuilder := *resourceBuilder
+				appBuilder.Instance = resourceBuilder.Instance.DeepCopy()
+				result := &appResult{status: appStatuses[name]}
+				result.done, result.requeue, result.err = r.reconcileApp(ctx, &appBuilder, name, tags[name], &result.status, deployed, plan)
+				levelResults[i] = result
+			}(i, name)
+		}
+		wg.Wait()
+		for i, name := range names {
+			results[name] = levelResults[i]
+			if levelResults[i].done {
+				deployed[name] = tags[name]
+			}
+		}
+	}
+	return results
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/resource"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestDependencyLevels(t *testing.T) {
+	tests := []struct {
+		dependsOn map[string][]string
+		levels    [][]string
+	}{
+		{nil, [][]string{{"a", "b", "c", "d"}}},
+		{map[string][]string{"a": {"c"}, "b": {"a", "d"}}, [][]string{{"c", "d"}, {"a"}, {"b"}}},
+		{map[string][]string{"d": {"x"}}, [][]string{{"a", "b", "c", "d"}}},
+		{map[string][]string{"a": {"b"}, "b": {"a"}, "c": {"a"}}, [][]string{{"d", "b"}, {"a"}, {"c"}}},
+	}
+	for _, test := range tests {
+		deployStack := &apiv1.DeployStack{}
+		deployStack.Spec.AppsList = map[string]string{"a": "1", "b": "1", "c": "1", "d": "1"}
+		deployStack.Spec.Apps = map[string]apiv1.AppsName{}
+		for name, dependsOn := range test.dependsOn {
+			deployStack.Spec.Apps[name] = apiv1.AppsName{DependsOn: dependsOn}
+		}
+		builder := &resource.DeployStackBuild{Instance: deployStack}
+		order, _ := builder.RolloutOrder()
+		if levels := dependencyLevels(builder, order); !reflect.DeepEqual(levels, test.levels) {
+			t.Errorf("dependencyLevels(%v) = %v, want %v", test.dependsOn, levels, test.levels)
+		}
+	}
+}
+
+// TestReconcileAppsConcurrently 并发调谐与逐个调谐的结果一致, 使用 go test -race 检查数据竞争
+func TestReconcileAppsConcurrently(t *testing.T) {
+	var results []map[string]apiv1.AppStatus
+	for _, workers := range []int{1, 4} {
+		r, _, key := newTestReconciler(t, workers)
+		ctx := context.Background()
+		deployStack := &apiv1.DeployStack{}
+		if err := r.Get(ctx, key, deployStack); err != nil {
+			t.Fatal(err)
+		}
+		// world 依赖 hello, hello、test 在同一层并发调谐
+		deployStack.Spec.Apps["world"] = apiv1.AppsName{DependsOn: []string{"hello"}}
+		if err := r.Update(ctx, deployStack); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+			t.Fatal(err)
+		}
+		deployments := &appsv1.DeploymentList{}
+		if err := r.List(ctx, deployments, client.InNamespace(deployStack.Spec.Namespace)); err != nil {
+			t.Fatal(err)
+		}
+		// 依赖的Deployment未发布完成, world 等待依赖
+		if len(deployments.Items) != 2 {
+			t.Errorf("workers=%d: created %d Deployments, want 2", workers, len(deployments.Items))
+		}
+		if err := r.Get(ctx, key, deployStack); err != nil {
+			t.Fatal(err)
+		}
+		if phase := deployStack.Status.Apps["world"].Phase; phase != apiv1.AppPhaseWaitingForDependency {
+			t.Errorf("workers=%d: world phase = %q, want %q", workers, phase, apiv1.AppPhaseWaitingForDependency)
+		}
+		for name, status := range deployStack.Status.Apps {
+			for i := range status.Revisions {
+				status.Revisions[i].Time = metav1.Time{}
+			}
+			deployStack.Status.Apps[name] = status
+		}
+		results = append(results, deployStack.Status.Apps)
+	}
+	if !reflect.DeepEqual(results[0], results[1]) {
+		t.Errorf("concurrent reconcile status = %v, want %v", results[1], results[0])
+	}
+}
@@ -0,0 +1,221 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 访问镜像仓库单个请求的超时时间
+const defaultTimeout = 30 * time.Second
+
+var (
+	challengeParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+	linkRegexp           = regexp.MustCompile(`<([^>]+)>;\s*rel="?next"?`)
+)
+
+// manifestMediaTypes 多架构镜像返回index的digest
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// OCIClient 基于OCI distribution API的镜像仓库客户端, 支持Basic及Bearer token认证
+type OCIClient struct {
+	HTTPClient *http.Client
+	// 使用http访问仓库, 用于本地测试仓库
+	PlainHTTP bool
+}
+
+// NewOCIClient 每个请求最长等待defaultTimeout, 避免仓库无响应时阻塞调谐
+func NewOCIClient() *OCIClient {
+	return &OCIClient{HTTPClient: &http.Client{Timeout: defaultTimeout}}
+}
+
+// Tags 分页获取 /v2/<name>/tags/list
+func (c *OCIClient) Tags(ctx context.Context, repository string, auth *Auth) ([]string, error) {
+	host, path := ParseRepository(repository)
+	next := c.url(host, "/v2/"+path+"/tags/list?n=1000")
+	var tags []string
+	for next != "" {
+		resp, err := c.do(ctx, http.MethodGet, next, path, auth, nil)
+		if err != nil {
+			return nil, err
+		}
+		list := struct {
+			Tags []string `json:"tags"`
+		}{}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decode tags of %s: %w", repository, err)
+		}
+		tags = append(tags, list.Tags...)
+		if next, err = nextLink(next, resp.Header.Get("Link")); err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+// Digest 通过 HEAD /v2/<name>/manifests/<tag> 读取 Docker-Content-Digest, 仓库未返回时再GET manifest计算sha256
+func (c *OCIClient) Digest(ctx context.Context, repository, tag string, auth *Auth) (string, error) {
+	host, path := ParseRepository(repository)
+	rawURL := c.url(host, "/v2/"+path+"/manifests/"+tag)
+	header := http.Header{"Accept": {strings.Join(manifestMediaTypes, ", ")}}
+	resp, err := c.do(ctx, http.MethodHead, rawURL, path, auth, header)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	if resp, err = c.do(ctx, http.MethodGet, rawURL, path, auth, header); err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil)), nil
+}
+
+func (c *OCIClient) url(host, path string) string {
+	scheme := "https"
+	if c.PlainHTTP {
+		scheme = "http"
+	}
+	if host == dockerHub {
+		host = dockerHubRegistry
+	}
+	return scheme + "://" + host + path
+}
+
+// do 发送请求, 返回401时按 WWW-Authenticate 认证后重试
+func (c *OCIClient) do(ctx context.Context, method, rawURL, path string, auth *Auth, header http.Header) (*http.Response, error) {
+	resp, err := c.send(ctx, method, rawURL, header, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		authorization, err := c.authorize(ctx, challenge, path, auth)
+		if err != nil {
+			return nil, err
+		}
+		if resp, err = c.send(ctx, method, rawURL, header, authorization); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s %s", method, rawURL, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+func (c *OCIClient) send(ctx context.Context, method, rawURL string, header http.Header, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return c.HTTPClient.Do(req)
+}
+
+// authorize 根据认证方式返回 Authorization 请求头, Bearer 方式先从realm获取token
+func (c *OCIClient) authorize(ctx context.Context, challenge, path string, auth *Auth) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if strings.EqualFold(scheme, "basic") {
+		if auth == nil {
+			return "", fmt.Errorf("registry requires basic auth for %s", path)
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth.Username+":"+auth.Password)), nil
+	}
+	if !strings.EqualFold(scheme, "bearer") {
+		return "", fmt.Errorf("unsupported auth challenge %q", challenge)
+	}
+	values := map[string]string{}
+	for _, match := range challengeParamRegexp.FindAllStringSubmatch(params, -1) {
+		values[match[1]] = match[2]
+	}
+	realm, err := url.Parse(values["realm"])
+	if err != nil || values["realm"] == "" {
+		return "", fmt.Errorf("invalid auth challenge %q", challenge)
+	}
+	query := realm.Query()
+	if values["service"] != "" {
+		query.Set("service", values["service"])
+	}
+	scope := values["scope"]
+	if scope == "" {
+		scope = "repository:" + path + ":pull"
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if auth != nil {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: %s", realm.String(), resp.Status)
+	}
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("decode token: %w", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	return "Bearer " + token.Token, nil
+}
+
+// nextLink 解析分页的 Link 请求头, 没有下一页时返回空
+func nextLink(current, link string) (string, error) {
+	match := linkRegexp.FindStringSubmatch(link)
+	if match == nil {
+		return "", nil
+	}
+	base, err := url.Parse(current)
+	if err != nil {
+		return "", err
+	}
+	next, err := base.Parse(match[1])
+	if err != nil {
+		return "", err
+	}
+	return next.String(), nil
+}
@@ -0,0 +1,167 @@
+package registry
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+var digitsRegexp = regexp.MustCompile(`\d+`)
+
+// Policy 从镜像仓库的tag中选择最新的tag, Semver 与 Pattern 二选一
+type Policy struct {
+	Semver  string
+	Pattern string
+}
+
+// Latest 返回符合策略的最新tag, 没有符合的tag时返回空
+func (p Policy) Latest(tags []string) (string, error) {
+	switch {
+	case p.Semver != "" && p.Pattern != "":
+		return "", fmt.Errorf("only one of semver and pattern can be set")
+	case p.Semver != "":
+		return latestSemver(tags, p.Semver)
+	case p.Pattern != "":
+		return latestPattern(tags, p.Pattern)
+	}
+	return "", fmt.Errorf("one of semver and pattern is required")
+}
+
+// latestSemver 满足范围的最大版本, 忽略预发布版本
+func latestSemver(tags []string, constraint string) (string, error) {
+	constraints, err := parseConstraints(constraint)
+	if err != nil {
+		return "", err
+	}
+	var (
+		latest        string
+		latestVersion *version.Version
+	)
+	for _, tag := range tags {
+		v, err := version.ParseSemantic(tag)
+		if err != nil || v.PreRelease() != "" || !constraints.match(v) {
+			continue
+		}
+		if latestVersion == nil || latestVersion.LessThan(v) {
+			latest, latestVersion = tag, v
+		}
+	}
+	return latest, nil
+}
+
+// latestPattern 完整匹配正则的tag中, 按其中的数字排序最大的tag
+func latestPattern(tags []string, pattern string) (string, error) {
+	re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	latest := ""
+	for _, tag := range tags {
+		if !re.MatchString(tag) {
+			continue
+		}
+		if latest == "" || numericLess(latest, tag) {
+			latest = tag
+		}
+	}
+	return latest, nil
+}
+
+// numericLess 依次比较tag中的数字, 数字相同时按字符串比较
+func numericLess(a, b string) bool {
+	numbersA, numbersB := digitsRegexp.FindAllString(a, -1), digitsRegexp.FindAllString(b, -1)
+	for i := 0; i < len(numbersA) && i < len(numbersB); i++ {
+		x, y := strings.TrimLeft(numbersA[i], "0"), strings.TrimLeft(numbersB[i], "0")
+		if len(x) != len(y) {
+			return len(x) < len(y)
+		}
+		if x != y {
+			return x < y
+		}
+	}
+	if len(numbersA) != len(numbersB) {
+		return len(numbersA) < len(numbersB)
+	}
+	return a < b
+}
+
+type constraint struct {
+	operator string
+	version  *version.Version
+}
+
+type constraints []constraint
+
+func (c constraints) match(v *version.Version) bool {
+	for _, item := range c {
+		compare := compareVersion(v, item.version)
+		var ok bool
+		switch item.operator {
+		case ">=":
+			ok = compare >= 0
+		case ">":
+			ok = compare > 0
+		case "<=":
+			ok = compare <= 0
+		case "<":
+			ok = compare < 0
+		default:
+			ok = compare == 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func compareVersion(a, b *version.Version) int {
+	switch {
+	case a.LessThan(b):
+		return -1
+	case b.LessThan(a):
+		return 1
+	}
+	return 0
+}
+
+// parseConstraints 解析空格或逗号分隔的条件, 支持 >=、>、<=、<、=、^、~
+func parseConstraints(value string) (constraints, error) {
+	var result constraints
+	for _, field := range strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == ',' }) {
+		operator := ""
+		for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+			if strings.HasPrefix(field, prefix) {
+				operator = prefix
+				break
+			}
+		}
+		v, err := version.ParseGeneric(strings.TrimPrefix(field, operator))
+		if err != nil {
+			return nil, fmt.Errorf("invalid semver constraint %q: %w", field, err)
+		}
+		lower := version.MustParseSemantic(fmt.Sprintf("%d.%d.%d", v.Major(), v.Minor(), v.Patch()))
+		switch operator {
+		case "^":
+			// 不改变最左侧的非零版本号, ^0.0 未指定patch时为 <0.1.0
+			upper := lower.WithMajor(v.Major() + 1).WithMinor(0).WithPatch(0)
+			switch {
+			case v.Major() == 0 && v.Minor() == 0 && len(v.Components()) > 2:
+				upper = lower.WithPatch(v.Patch() + 1)
+			case v.Major() == 0:
+				upper = lower.WithMinor(v.Minor() + 1).WithPatch(0)
+			}
+			result = append(result, constraint{">=", lower}, constraint{"<", upper})
+		case "~":
+			result = append(result, constraint{">=", lower}, constraint{"<", lower.WithMinor(v.Minor() + 1).WithPatch(0)})
+		default:
+			result = append(result, constraint{operator, lower})
+		}
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("invalid semver constraint %q", value)
+	}
+	return result, nil
+}
@@ -0,0 +1,79 @@
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	dockerHub         = "docker.io"
+	dockerHubRegistry = "registry-1.docker.io"
+)
+
+// Client 镜像仓库客户端
+type Client interface {
+	// Tags 返回镜像的全部tag, repository 为不含tag的镜像地址
+	Tags(ctx context.Context, repository string, auth *Auth) ([]string, error)
+	// Digest 返回tag对应manifest的digest, 如 sha256:...
+	Digest(ctx context.Context, repository, tag string, auth *Auth) (string, error)
+}
+
+// Auth 镜像仓库账号
+type Auth struct {
+	Username string
+	Password string
+}
+
+// ParseRepository 拆分镜像地址为仓库域名及镜像路径, 未带域名时为docker hub
+func ParseRepository(repository string) (string, string) {
+	parts := strings.SplitN(repository, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return parts[0], parts[1]
+	}
+	if len(parts) == 1 {
+		return dockerHub, "library/" + repository
+	}
+	return dockerHub, repository
+}
+
+// DockerConfigAuth 从 kubernetes.io/dockerconfigjson 格式的凭证中查找仓库域名的账号, 未配置时返回nil
+func DockerConfigAuth(data []byte, host string) (*Auth, error) {
+	config := struct {
+		Auths map[string]struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Auth     string `json:"auth"`
+		} `json:"auths"`
+	}{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	for server, entry := range config.Auths {
+		if configHost(server) != host {
+			continue
+		}
+		if entry.Auth == "" {
+			return &Auth{Username: entry.Username, Password: entry.Password}, nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("invalid auth for %s: %w", server, err)
+		}
+		username, password, _ := strings.Cut(string(decoded), ":")
+		return &Auth{Username: username, Password: password}, nil
+	}
+	return nil, nil
+}
+
+// configHost dockerconfigjson 中的仓库地址可能带协议及路径, 如 https://index.docker.io/v1/
+func configHost(server string) string {
+	server = strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	server, _, _ = strings.Cut(server, "/")
+	if server == "index.docker.io" || server == dockerHubRegistry {
+		return dockerHub
+	}
+	return server
+}
@@ -0,0 +1,115 @@
+package registry_test
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/registry"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/registry/registrytest"
+)
+
+func TestOCIClientTags(t *testing.T) {
+	server := registrytest.NewServer()
+	defer server.Close()
+	server.Username, server.Password = "robot", "secret"
+	server.PageSize = 2
+	server.SetTags("unipal/hello", "b1", "b2", "b10", "latest", "v1.2.0")
+
+	client := &registry.OCIClient{HTTPClient: server.Client(), PlainHTTP: true}
+	got, err := client.Tags(context.Background(), server.Host()+"/unipal/hello", &registry.Auth{Username: "robot", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"b1", "b10", "b2", "latest", "v1.2.0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Tags() = %v, want %v", got, want)
+	}
+	if _, err := client.Tags(context.Background(), server.Host()+"/unipal/hello", &registry.Auth{Username: "robot", Password: "wrong"}); err == nil {
+		t.Error("Tags() with wrong password: want error")
+	}
+}
+
+func TestOCIClientDigest(t *testing.T) {
+	tests := []struct {
+		name             string
+		omitDigestHeader bool
+		gets             int
+	}{
+		{name: "digest header"},
+		{name: "no digest header", omitDigestHeader: true, gets: 1},
+	}
+	for _, test := range tests {
+		server := registrytest.NewServer()
+		server.OmitDigestHeader = test.omitDigestHeader
+		want := server.SetManifest("unipal/hello", "latest", []byte(`{"schemaVersion":2}`))
+
+		client := &registry.OCIClient{HTTPClient: server.Client(), PlainHTTP: true}
+		got, err := client.Digest(context.Background(), server.Host()+"/unipal/hello", "latest", nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if got != want {
+			t.Errorf("%s: Digest() = %s, want %s", test.name, got, want)
+		}
+		if heads, gets := server.Requests(http.MethodHead), server.Requests(http.MethodGet); heads != 1 || gets != test.gets {
+			t.Errorf("%s: HEAD = %d, GET = %d, want 1 and %d", test.name, heads, gets, test.gets)
+		}
+		if _, err := client.Digest(context.Background(), server.Host()+"/unipal/hello", "missing", nil); err == nil {
+			t.Errorf("%s: Digest() of missing tag: want error", test.name)
+		}
+		server.Close()
+	}
+}
+
+func TestPolicyLatest(t *testing.T) {
+	tags := []string{"latest", "b2", "b10", "b9", "v1.2.0", "v1.10.1", "1.11.0-rc.1", "v2.0.0", "0.3.1", "0.4.0", "0.0.3", "0.0.4"}
+	tests := []struct {
+		policy registry.Policy
+		want   string
+	}{
+		{registry.Policy{Pattern: `^b\d+$`}, "b10"},
+		{registry.Policy{Pattern: `b\d+`}, "b10"},
+		{registry.Policy{Pattern: `b`}, ""},
+		{registry.Policy{Pattern: `\d+`}, ""},
+		{registry.Policy{Pattern: `b2|b9`}, "b9"},
+		{registry.Policy{Semver: ">=1.0.0 <2.0.0"}, "v1.10.1"},
+		{registry.Policy{Semver: "^1.2"}, "v1.10.1"},
+		{registry.Policy{Semver: "~1.2.0"}, "v1.2.0"},
+		{registry.Policy{Semver: "^0.3.0"}, "0.3.1"},
+		{registry.Policy{Semver: "^0.0.3"}, "0.0.3"},
+		{registry.Policy{Semver: "^0.0"}, "0.0.4"},
+		{registry.Policy{Semver: ">=3.0.0"}, ""},
+	}
+	for _, test := range tests {
+		got, err := test.policy.Latest(tags)
+		if err != nil {
+			t.Errorf("%+v: %v", test.policy, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%+v: Latest() = %q, want %q", test.policy, got, test.want)
+		}
+	}
+	if _, err := (registry.Policy{Semver: ">=1.0", Pattern: "b"}).Latest(tags); err == nil {
+		t.Error("semver and pattern both set: want error")
+	}
+}
+
+func TestDockerConfigAuth(t *testing.T) {
+	data := []byte(`{"auths":{"https://index.docker.io/v1/":{"auth":"dXNlcjpwYXNz"},"registry.example.com":{"username":"robot","password":"secret"}}}`)
+	tests := map[string]*registry.Auth{
+		"docker.io":            {Username: "user", Password: "pass"},
+		"registry.example.com": {Username: "robot", Password: "secret"},
+		"quay.io":              nil,
+	}
+	for host, want := range tests {
+		got, err := registry.DockerConfigAuth(data, host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("DockerConfigAuth(%s) = %+v, want %+v", host, got, want)
+		}
+	}
+}
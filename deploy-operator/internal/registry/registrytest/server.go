@@ -0,0 +1,153 @@
+// Package registrytest 提供用于测试的本地镜像仓库替身
+package registrytest
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const token = "registrytest-token"
+
+// Server 实现OCI distribution API的 tags/list 及 manifests, 设置了账号时要求Bearer token认证
+type Server struct {
+	*httptest.Server
+	Username string
+	Password string
+	// 非0时每页最多返回的tag数量
+	PageSize int
+	// 不返回 Docker-Content-Digest 响应头, 模拟不支持的仓库
+	OmitDigestHeader bool
+
+	mu           sync.Mutex
+	repositories map[string][]string
+	manifests    map[string][]byte
+	requests     map[string]int
+}
+
+func NewServer() *Server {
+	s := &Server{repositories: map[string][]string{}, manifests: map[string][]byte{}, requests: map[string]int{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/v2/", s.registry)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Host 仓库地址, 用作镜像地址的域名部分
+func (s *Server) Host() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// SetTags 设置镜像的tag, path 为不含域名的镜像路径
+func (s *Server) SetTags(path string, tags ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.repositories[path] = append([]string{}, tags...)
+}
+
+// SetManifest 设置tag对应的manifest并返回其digest, 同时将tag加入tags/list
+func (s *Server) SetManifest(path, tag string, manifest []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.manifests[path+":"+tag] = append([]byte{}, manifest...)
+	for _, existing := range s.repositories[path] {
+		if existing == tag {
+			return digest(manifest)
+		}
+	}
+	s.repositories[path] = append(s.repositories[path], tag)
+	return digest(manifest)
+}
+
+// Requests 返回manifests接口收到的指定方法的请求数
+func (s *Server) Requests(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[method]
+}
+
+func digest(manifest []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	username, password, ok := r.BasicAuth()
+	if !ok || username != s.Username || password != s.Password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+func (s *Server) registry(w http.ResponseWriter, r *http.Request) {
+	if s.Username != "" && r.Header.Get("Authorization") != "Bearer "+token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registrytest"`, s.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	if i := strings.LastIndex(path, "/manifests/"); i >= 0 {
+		s.manifest(w, r, path[:i], path[i+len("/manifests/"):])
+		return
+	}
+	if !strings.HasSuffix(path, "/tags/list") {
+		http.NotFound(w, r)
+		return
+	}
+	path = strings.TrimSuffix(path, "/tags/list")
+	s.mu.Lock()
+	tags, ok := s.repositories[path]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	tags = append([]string{}, tags...)
+	sort.Strings(tags)
+	// 按 last、n 分页
+	if last := r.URL.Query().Get("last"); last != "" {
+		i := sort.SearchStrings(tags, last)
+		if i < len(tags) && tags[i] == last {
+			i++
+		}
+		tags = tags[i:]
+	}
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || (s.PageSize > 0 && s.PageSize < n) {
+		n = s.PageSize
+	}
+	if n > 0 && n < len(tags) {
+		tags = tags[:n]
+		next := url.Values{"n": {strconv.Itoa(n)}, "last": {tags[n-1]}}
+		w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?%s>; rel="next"`, path, next.Encode()))
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": path, "tags": tags})
+}
+
+func (s *Server) manifest(w http.ResponseWriter, r *http.Request, path, tag string) {
+	s.mu.Lock()
+	manifest, ok := s.manifests[path+":"+tag]
+	s.requests[r.Method]++
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+	if !s.OmitDigestHeader {
+		w.Header().Set("Docker-Content-Digest", digest(manifest))
+	}
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+		return
+	}
+	w.Write(manifest)
+}
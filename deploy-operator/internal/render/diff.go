@@ -0,0 +1,278 @@
+package render
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// 变更类型
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionPrune  = "prune"
+)
+
+var (
+	// 由apiserver或其他控制器维护的metadata字段, 比较时忽略
+	serverManagedMetadata = []string{
+		"uid", "resourceVersion", "generation", "creationTimestamp", "deletionTimestamp",
+		"deletionGracePeriodSeconds", "managedFields", "selfLink", "ownerReferences", "finalizers",
+	}
+	serverManagedAnnotations = []string{
+		"kubectl.kubernetes.io/last-applied-configuration",
+		"deployment.kubernetes.io/revision",
+	}
+)
+
+// Change 一个资源的变更
+type Change struct {
+	Action    string
+	GVK       schema.GroupVersionKind
+	Namespace string
+	Name      string
+	// update时变化的字段
+	Fields []FieldChange
+}
+
+// FieldChange 字段路径及变更前后的值, 值为nil表示字段不存在
+type FieldChange struct {
+	Path    string
+	Live    interface{}
+	Desired interface{}
+}
+
+// Diff 比较渲染生成的资源与当前资源
+// 只比较生成的资源中存在的字段, 仅存在于当前资源中的字段视为服务端默认值或其他控制器写入的字段
+// 带 app.kubernetes.io/name=deploystack 标签、但不再生成的资源视为prune
+func Diff(ctx context.Context, deployStack *apiv1.DeployStack, objects []client.Object, source Source) ([]Change, error) {
+	var changes []Change
+	desired := map[string]bool{}
+	for _, object := range objects {
+		gvk := object.GetObjectKind().GroupVersionKind()
+		desired[objectKey(gvk, object.GetNamespace(), object.GetName())] = true
+		live, err := source.Get(ctx, gvk, types.NamespacedName{Namespace: object.GetNamespace(), Name: object.GetName()})
+		if err != nil {
+			return nil, err
+		}
+		change := Change{GVK: gvk, Namespace: object.GetNamespace(), Name: object.GetName()}
+		if live == nil {
+			change.Action = ActionCreate
+			changes = append(changes, change)
+			continue
+		}
+		desiredObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+		if err != nil {
+			return nil, err
+		}
+		compare("", normalize(desiredObj), normalize(live.Object), &change.Fields)
+		if len(change.Fields) > 0 {
+			change.Action = ActionUpdate
+			changes = append(changes, change)
+		}
+	}
+
+	pruneKinds, err := pruneKinds(deployStack)
+	if err != nil {
+		return nil, err
+	}
+	selector := labels.SelectorFromSet(map[string]string{"app.kubernetes.io/name": "deploystack"})
+	for _, gvk := range pruneKinds {
+		lives, err := source.List(ctx, gvk, deployStack.Spec.Namespace, selector)
+		if err != nil {
+			return nil, err
+		}
+		for _, live := range lives {
+			if desired[objectKey(gvk, live.GetNamespace(), live.GetName())] || !prunable(deployStack, &live) {
+				continue
+			}
+			changes = append(changes, Change{Action: ActionPrune, GVK: gvk, Namespace: live.GetNamespace(), Name: live.GetName()})
+		}
+	}
+	return changes, nil
+}
+
+// pruneKinds builder生成的资源类型
+func pruneKinds(deployStack *apiv1.DeployStack) ([]schema.GroupVersionKind, error) {
+	resourceBuilder := &resource.DeployStackBuild{Instance: deployStack, Scheme: Scheme}
+	var builders []resource.ResourceBuilder
+	for _, builder := range resourceBuilder.ResourceBuilds() {
+		builders = append(builders, builder)
+	}
+	for _, builder := range resourceBuilder.IngressBuilds() {
+		builders = append(builders, builder)
+	}
+	for _, builder := range resourceBuilder.BatchBuilds() {
+		builders = append(builders, builder)
+	}
+	var kinds []schema.GroupVersionKind
+	for _, builder := range builders {
+		object, err := builder.GetObjectKind()
+		if err != nil {
+			return nil, err
+		}
+		gvk, err := apiutil.GVKForObject(object, Scheme)
+		if err != nil {
+			return nil, err
+		}
+		kinds = append(kinds, gvk)
+	}
+	return kinds, nil
+}
+
+// prunable 与operator删除资源的判断一致: 与应用同名的资源在应用移除后才删除, 共用资源不删除,
+// 发布钩子的Job由钩子单独清理, 只处理带有本DeployStack标签的PVC, 保留的PVC只解除引用
+func prunable(deployStack *apiv1.DeployStack, live *unstructured.Unstructured) bool {
+	switch live.GetKind() {
+	case "Deployment", "ConfigMap", "Secret":
+		return resource.AppResourceOrphaned(deployStack, live.GetName())
+	case "Job":
+		return live.GetLabels()[resource.ComponentLabel] == resource.ComponentJob
+	case "CronJob":
+		return live.GetLabels()[resource.ComponentLabel] == resource.ComponentCronJob
+	case "PersistentVolumeClaim":
+		if !labels.SelectorFromSet(resource.StackLabels(deployStack)).Matches(labels.Set(live.GetLabels())) {
+			return false
+		}
+		return live.GetAnnotations()[resource.RetainAnnotation] != "true"
+	}
+	return true
+}
+
+// normalize 去掉status及服务端维护的字段
+func normalize(object map[string]interface{}) map[string]interface{} {
+	object = runtime.DeepCopyJSON(object)
+	delete(object, "status")
+	metadata, _ := object["metadata"].(map[string]interface{})
+	for _, field := range serverManagedMetadata {
+		delete(metadata, field)
+	}
+	if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+		for _, annotation := range serverManagedAnnotations {
+			delete(annotations, annotation)
+		}
+	}
+	return object
+}
+
+// compare 递归比较desired中存在的字段, 列表按下标比较, 长度不同时整体视为变更
+func compare(path string, desired, live interface{}, changes *[]FieldChange) {
+	switch desiredValue := desired.(type) {
+	case map[string]interface{}:
+		liveValue, ok := live.(map[string]interface{})
+		if !ok {
+			if len(desiredValue) > 0 {
+				*changes = append(*changes, FieldChange{Path: path, Live: live, Desired: desired})
+			}
+			return
+		}
+		keys := make([]string, 0, len(desiredValue))
+		for key := range desiredValue {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			compare(fieldPath(path, key), desiredValue[key], liveValue[key], changes)
+		}
+	case []interface{}:
+		liveValue, ok := live.([]interface{})
+		if !ok || len(liveValue) != len(desiredValue) {
+			if len(desiredValue) > 0 || len(liveValue) > 0 {
+				*changes = append(*changes, FieldChange{Path: path, Live: live, Desired: desired})
+			}
+			return
+		}
+		for i := range desiredValue {
+			compare(fmt.Sprintf("%s[%d]", path, i), desiredValue[i], liveValue[i], changes)
+		}
+	case nil:
+		// 生成的资源中未设置的字段由服务端决定
+	default:
+		if !scalarEqual(desired, live) {
+			*changes = append(*changes, FieldChange{Path: path, Live: live, Desired: desired})
+		}
+	}
+}
+
+func fieldPath(path, key string) string {
+	if strings.ContainsAny(key, "./") {
+		key = fmt.Sprintf("[%q]", key)
+		return path + key
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// scalarEqual 数字统一按float64比较, 导出的YAML中整数可能被解析为float64
+func scalarEqual(desired, live interface{}) bool {
+	desiredNumber, ok := toFloat(desired)
+	if liveNumber, liveOk := toFloat(live); ok && liveOk {
+		return desiredNumber == liveNumber
+	}
+	return desired == live
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch number := value.(type) {
+	case int64:
+		return float64(number), true
+	case int32:
+		return float64(number), true
+	case int:
+		return float64(number), true
+	case float64:
+		return number, true
+	}
+	return 0, false
+}
+
+// WriteDiff 输出变更, Secret的data默认不输出具体值
+func WriteDiff(writer io.Writer, changes []Change, showSecrets bool) error {
+	symbols := map[string]string{ActionCreate: "+", ActionUpdate: "~", ActionPrune: "-"}
+	for _, change := range changes {
+		name := change.Name
+		if change.Namespace != "" {
+			name = change.Namespace + "/" + change.Name
+		}
+		if _, err := fmt.Fprintf(writer, "%s %s %s %s\n", symbols[change.Action], change.Action, change.GVK.Kind, name); err != nil {
+			return err
+		}
+		secret := change.GVK.Group == "" && change.GVK.Kind == "Secret"
+		for _, field := range change.Fields {
+			live, desired := formatValue(field.Live), formatValue(field.Desired)
+			if secret && !showSecrets && (strings.HasPrefix(field.Path, "data") || strings.HasPrefix(field.Path, "stringData")) {
+				live, desired = maskedValue, maskedValue
+			}
+			if _, err := fmt.Fprintf(writer, "    %s: %s -> %s\n", field.Path, live, desired); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func formatValue(value interface{}) string {
+	if value == nil {
+		return "<none>"
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
@@ -0,0 +1,211 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/resource"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+
+	// secret值脱敏后的内容
+	maskedValue = "******"
+)
+
+// Scheme 渲染使用的scheme, 包含内置资源及DeployStack
+var Scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(Scheme))
+	utilruntime.Must(apiv1.AddToScheme(Scheme))
+}
+
+// Options 渲染选项, 离线渲染无法探测集群中的CRD, 由参数指定
+type Options struct {
+	// 集群中已安装cert-manager, 生成Certificate
+	CertManager bool
+	// 集群中已安装prometheus-operator, 生成ServiceMonitor、PodMonitor, 否则在Pod上添加采集注解
+	PrometheusOperator bool
+	// 集群中IngressClass名称及其spec.controller, 离线渲染时按ingressClass名称确定控制器类型
+	IngressClassControllers map[string]string
+}
+
+// Decode 读取YAML或JSON格式的DeployStack, 支持 --- 分隔的多个文档
+func Decode(reader io.Reader) ([]*apiv1.DeployStack, error) {
+	var deployStacks []*apiv1.DeployStack
+	decoder := utilyaml.NewYAMLOrJSONDecoder(reader, 4096)
+	for {
+		deployStack := &apiv1.DeployStack{}
+		if err := decoder.Decode(deployStack); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if deployStack.Kind == "" {
+			continue
+		}
+		if deployStack.Kind != "DeployStack" {
+			return nil, fmt.Errorf("unexpected kind %s, expected DeployStack", deployStack.Kind)
+		}
+		deployStacks = append(deployStacks, deployStack)
+	}
+	return deployStacks, nil
+}
+
+// Render 不连接集群, 按调谐的顺序生成DeployStack对应的全部资源
+// 多个应用共用的资源(global-config、global-secret)只输出一次
+func Render(deployStack *apiv1.DeployStack, opts Options) ([]client.Object, error) {
+	var objects []client.Object
+	seen := map[string]bool{}
+	add := func(resourceObjs ...client.Object) error {
+		for _, resourceObj := range resourceObjs {
+			if resourceObj == nil {
+				continue
+			}
+			gvk, err := apiutil.GVKForObject(resourceObj, Scheme)
+			if err != nil {
+				return err
+			}
+			resourceObj.GetObjectKind().SetGroupVersionKind(gvk)
+			key := fmt.Sprintf("%s/%s/%s", gvk.Kind, resourceObj.GetNamespace(), resourceObj.GetName())
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			objects = append(objects, resourceObj)
+		}
+		return nil
+	}
+	resourceBuilder := &resource.DeployStackBuild{
+		Instance:           deployStack,
+		Scheme:             Scheme,
+		CertManager:        opts.CertManager,
+		PrometheusOperator: opts.PrometheusOperator,
+		// 与operator一样按IngressClass的spec.controller生成控制器相关的注解
+		IngressClassControllers: opts.IngressClassControllers,
+	}
+	appList := deployStack.Spec.AppsList
+	order, _ := resourceBuilder.RolloutOrder()
+	for _, name := range order {
+		tag := appList[name]
+		for _, builder := range resourceBuilder.ResourceBuilds() {
+			resourceObjs, err := build(builder, name, tag)
+			if err != nil {
+				return nil, fmt.Errorf("app %s: %w", name, err)
+			}
+			if err := add(resourceObjs...); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, builder := range resourceBuilder.IngressBuilds() {
+		for _, name := range builder.Names() {
+			resourceObjs, err := build(builder, name, "")
+			if err != nil {
+				return nil, err
+			}
+			if err := add(resourceObjs...); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, builder := range resourceBuilder.BatchBuilds() {
+		tasks := builder.Tasks()
+		for _, name := range resource.SortedKeys(tasks) {
+			resourceObj, err := builder.Build(name, tasks[name])
+			if err != nil {
+				return nil, err
+			}
+			if err := add(resourceObj); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return objects, nil
+}
+
+func build(builder resource.ResourceBuilder, name, tag string) ([]client.Object, error) {
+	if listBuilder, ok := builder.(resource.ResourceListBuilder); ok {
+		return listBuilder.BuildList(name, tag)
+	}
+	resourceObj, err := builder.Build(name, tag)
+	if err != nil {
+		return nil, err
+	}
+	return []client.Object{resourceObj}, nil
+}
+
+// MaskSecrets 将Secret中的值替换为 ******, 保留key便于审查, 以stringData输出避免再做base64编码
+func MaskSecrets(objects []client.Object) []client.Object {
+	masked := make([]client.Object, 0, len(objects))
+	for _, object := range objects {
+		secret, ok := object.(*corev1.Secret)
+		if !ok {
+			masked = append(masked, object)
+			continue
+		}
+		secret = secret.DeepCopy()
+		stringData := map[string]string{}
+		for key := range secret.Data {
+			stringData[key] = maskedValue
+		}
+		for key := range secret.StringData {
+			stringData[key] = maskedValue
+		}
+		secret.Data = nil
+		secret.StringData = stringData
+		masked = append(masked, secret)
+	}
+	return masked
+}
+
+// Write 按格式输出资源, yaml格式以 --- 分隔, json格式输出为List
+func Write(writer io.Writer, objects []client.Object, format string) error {
+	switch format {
+	case FormatYAML:
+		for i, object := range objects {
+			data, err := yaml.Marshal(object)
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				if _, err := io.WriteString(writer, "---\n"); err != nil {
+					return err
+				}
+			}
+			if _, err := writer.Write(data); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatJSON:
+		list := &corev1.List{}
+		list.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("List"))
+		for _, object := range objects {
+			data, err := json.Marshal(object)
+			if err != nil {
+				return err
+			}
+			list.Items = append(list.Items, runtime.RawExtension{Raw: data})
+		}
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(list)
+	default:
+		return fmt.Errorf("unknown output format %q, expected %s or %s", format, FormatYAML, FormatJSON)
+	}
+}
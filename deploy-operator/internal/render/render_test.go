@@ -0,0 +1,224 @@
+package render
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestRender(t *testing.T) {
+	file, err := os.Open(filepath.Join("..", "resource", "testdata", "deploystack.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	deployStacks, err := Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deployStacks) != 1 {
+		t.Fatalf("decoded %d DeployStacks, want 1", len(deployStacks))
+	}
+	objects, err := Render(deployStacks[0], Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := map[string]int{}
+	for _, object := range objects {
+		kind := object.GetObjectKind().GroupVersionKind().Kind
+		if kind == "" {
+			t.Errorf("%s has no kind", object.GetName())
+		}
+		kinds[kind]++
+	}
+	// global-config、global-secret由所有应用共用, 只输出一次
+	want := map[string]int{"Deployment": 3, "Service": 5, "ConfigMap": 1, "Secret": 1, "Ingress": 2}
+	for kind, count := range want {
+		if kinds[kind] != count {
+			t.Errorf("rendered %d %s, want %d", kinds[kind], kind, count)
+		}
+	}
+
+	for _, object := range MaskSecrets(objects) {
+		secret, ok := object.(*corev1.Secret)
+		if !ok {
+			continue
+		}
+		if len(secret.Data) != 0 {
+			t.Errorf("secret %s data is not masked", secret.Name)
+		}
+		for key, value := range secret.StringData {
+			if value != maskedValue {
+				t.Errorf("secret %s key %s is not masked", secret.Name, key)
+			}
+		}
+	}
+}
+
+func TestRenderMinimal(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		wantErr bool
+	}{
+		{name: "no resources", spec: ""},
+		{name: "resources", spec: "  resourcesMemory: 100Mi-1Gi\n  resourcesCpu: 10m-1\n"},
+		{name: "invalid resources", spec: "  resourcesMemory: 1Gi\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployStacks, err := Decode(strings.NewReader(`
+apiVersion: gopron.online/v1
+kind: DeployStack
+metadata:
+  name: minimal
+spec:
+  namespace: dev
+` + tt.spec + `  appsList:
+    hello: v1
+`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			objects, err := Render(deployStacks[0], Options{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Render() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			for _, object := range objects {
+				if object.GetObjectKind().GroupVersionKind().Kind == "Deployment" {
+					return
+				}
+			}
+			t.Errorf("rendered no Deployment")
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	deployStacks, err := Decode(strings.NewReader(`
+apiVersion: gopron.online/v1
+kind: DeployStack
+metadata:
+  name: sample
+  namespace: default
+spec:
+  namespace: dev
+  resourcesMemory: 100Mi-1Gi
+  resourcesCpu: 10m-1
+  appsList:
+    hello: b2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &DirSource{objects: map[string]*unstructured.Unstructured{}}
+	if err := source.load(strings.NewReader(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: hello
+  namespace: dev
+  uid: 6d7a1c1e
+  resourceVersion: "42"
+  annotations:
+    gopron.online/tag: b1
+    deployment.kubernetes.io/revision: "3"
+  labels:
+    app: hello
+    app.kubernetes.io/name: deploystack
+    env: dev
+spec:
+  progressDeadlineSeconds: 600
+status:
+  replicas: 1
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: old-config
+  namespace: dev
+  labels:
+    app.kubernetes.io/name: deploystack
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: hello
+  namespace: dev
+  labels:
+    app.kubernetes.io/name: deploystack
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: hello
+  namespace: dev
+  labels:
+    app.kubernetes.io/name: deploystack
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: hello-old
+  namespace: dev
+  labels:
+    app.kubernetes.io/name: deploystack
+    gopron.online/deploystack: sample
+    gopron.online/deploystack-namespace: default
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: world-data
+  namespace: dev
+  labels:
+    app.kubernetes.io/name: deploystack
+    gopron.online/deploystack: other
+    gopron.online/deploystack-namespace: default
+`)); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := Render(deployStacks[0], Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := Diff(context.Background(), deployStacks[0], objects, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := map[string]string{}
+	for _, change := range changes {
+		actions[change.GVK.Kind+"/"+change.Name] = change.Action
+		if change.Action != ActionUpdate {
+			continue
+		}
+		for _, field := range change.Fields {
+			if strings.Contains(field.Path, "revision") || strings.HasPrefix(field.Path, "status") || strings.Contains(field.Path, "progressDeadlineSeconds") {
+				t.Errorf("server managed field %s reported as changed", field.Path)
+			}
+		}
+	}
+	// 与operator一致: 应用同名的ConfigMap、Secret保留, 其他DeployStack的PVC不处理
+	want := map[string]string{
+		"Deployment/hello":                 ActionUpdate,
+		"Service/hello":                    ActionCreate,
+		"ConfigMap/old-config":             ActionPrune,
+		"ConfigMap/hello":                  "",
+		"Secret/hello":                     "",
+		"PersistentVolumeClaim/hello-old":  ActionPrune,
+		"PersistentVolumeClaim/world-data": "",
+	}
+	for key, action := range want {
+		if actions[key] != action {
+			t.Errorf("%s: got %q, want %q", key, actions[key], action)
+		}
+	}
+}
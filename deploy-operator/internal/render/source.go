@@ -0,0 +1,143 @@
+package render
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Source 集群中当前的资源, 来自集群或导出的YAML目录
+type Source interface {
+	// Get 资源不存在时返回nil
+	Get(ctx context.Context, gvk schema.GroupVersionKind, key types.NamespacedName) (*unstructured.Unstructured, error)
+	List(ctx context.Context, gvk schema.GroupVersionKind, namespace string, selector labels.Selector) ([]unstructured.Unstructured, error)
+}
+
+// ClusterSource 通过kubeconfig读取集群中的资源
+type ClusterSource struct {
+	Client client.Client
+}
+
+func (source *ClusterSource) Get(ctx context.Context, gvk schema.GroupVersionKind, key types.NamespacedName) (*unstructured.Unstructured, error) {
+	object := &unstructured.Unstructured{}
+	object.SetGroupVersionKind(gvk)
+	if err := source.Client.Get(ctx, key, object); err != nil {
+		// 未安装CRD的资源视为不存在
+		if client.IgnoreNotFound(err) == nil || meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return object, nil
+}
+
+func (source *ClusterSource) List(ctx context.Context, gvk schema.GroupVersionKind, namespace string, selector labels.Selector) ([]unstructured.Unstructured, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	if err := source.Client.List(ctx, list, &client.ListOptions{Namespace: namespace, LabelSelector: selector}); err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// DirSource 离线使用, 读取目录中 kubectl get -o yaml 导出的资源
+type DirSource struct {
+	objects map[string]*unstructured.Unstructured
+}
+
+// LoadDir 读取目录下所有 .yaml、.yml、.json 文件, 支持多文档及List
+func LoadDir(dir string) (*DirSource, error) {
+	source := &DirSource{objects: map[string]*unstructured.Unstructured{}}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml", ".json":
+		default:
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if err := source.load(file); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+func (source *DirSource) load(reader io.Reader) error {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(reader, 4096)
+	for {
+		object := &unstructured.Unstructured{}
+		if err := decoder.Decode(object); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if object.IsList() {
+			if err := object.EachListItem(func(item runtime.Object) error {
+				source.add(item.(*unstructured.Unstructured))
+				return nil
+			}); err != nil {
+				return err
+			}
+			continue
+		}
+		if object.GetKind() != "" {
+			source.add(object)
+		}
+	}
+}
+
+func (source *DirSource) add(object *unstructured.Unstructured) {
+	source.objects[objectKey(object.GroupVersionKind(), object.GetNamespace(), object.GetName())] = object
+}
+
+func (source *DirSource) Get(ctx context.Context, gvk schema.GroupVersionKind, key types.NamespacedName) (*unstructured.Unstructured, error) {
+	return source.objects[objectKey(gvk, key.Namespace, key.Name)], nil
+}
+
+func (source *DirSource) List(ctx context.Context, gvk schema.GroupVersionKind, namespace string, selector labels.Selector) ([]unstructured.Unstructured, error) {
+	var objects []unstructured.Unstructured
+	for _, object := range source.objects {
+		if object.GroupVersionKind().GroupKind() != gvk.GroupKind() || object.GetNamespace() != namespace {
+			continue
+		}
+		if !selector.Matches(labels.Set(object.GetLabels())) {
+			continue
+		}
+		objects = append(objects, *object)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].GetName() < objects[j].GetName() })
+	return objects, nil
+}
+
+// objectKey 导出的资源可能使用其他版本, 只按group及kind匹配
+func objectKey(gvk schema.GroupVersionKind, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", gvk.GroupKind().String(), namespace, name)
+}
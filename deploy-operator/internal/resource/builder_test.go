@@ -0,0 +1,904 @@
+package resource
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// go test ./internal/resource/ -update 重新生成testdata/golden中的文件
+var update = flag.Bool("update", false, "update golden files")
+
+func loadDeployStack(t *testing.T) *apiv1.DeployStack {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "deploystack.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployStack := &apiv1.DeployStack{}
+	if err := yaml.UnmarshalStrict(data, deployStack); err != nil {
+		t.Fatal(err)
+	}
+	return deployStack
+}
+
+func render(t *testing.T, build func(builder *DeployStackBuild) (client.Object, error)) []byte {
+	t.Helper()
+	object, err := build(&DeployStackBuild{Instance: loadDeployStack(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := yaml.Marshal(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestBuildGolden(t *testing.T) {
+	tests := []struct {
+		golden string
+		build  func(builder *DeployStackBuild) (client.Object, error)
+	}{
+		{"deployment-hello.yaml", func(builder *DeployStackBuild) (client.Object, error) {
+			return builder.Deployment().Build("hello", "b11")
+		}},
+		{"deployment-world.yaml", func(builder *DeployStackBuild) (client.Object, error) {
+			return builder.Deployment().Build("world", "b3")
+		}},
+		{"service-hello.yaml", func(builder *DeployStackBuild) (client.Object, error) {
+			return builder.Service().Build("hello", "b11")
+		}},
+		{"service-hello-lb.yaml", func(builder *DeployStackBuild) (client.Object, error) {
+			services, err := builder.Service().BuildList("hello", "b11")
+			if err != nil {
+				return nil, err
+			}
+			return services[1], nil
+		}},
+		{"service-hello-headless.yaml", func(builder *DeployStackBuild) (client.Object, error) {
+			services, err := builder.Service().BuildList("hello", "b11")
+			if err != nil {
+				return nil, err
+			}
+			return services[2], nil
+		}},
+		{"servicemonitor-world.yaml", func(builder *DeployStackBuild) (client.Object, error) {
+			builder.PrometheusOperator = true
+			return builder.ServiceMonitor().Build("world", "b3")
+		}},
+		// hello有额外的Service, ServiceMonitor只选择主Service
+		{"servicemonitor-hello.yaml", func(builder *DeployStackBuild) (client.Object, error) {
+			builder.PrometheusOperator = true
+			app := builder.Instance.Spec.Apps["hello"]
+			app.Metrics = &apiv1.AppMetricsSpec{Port: "http"}
+			builder.Instance.Spec.Apps["hello"] = app
+			return builder.ServiceMonitor().Build("hello", "b11")
+		}},
+		{"ingress-hello.yaml", func(builder *DeployStackBuild) (client.Object, error) {
+			return builder.Ingress().Build("hello", "")
+		}},
+		{"ingress-public.yaml", func(builder *DeployStackBuild) (client.Object, error) {
+			return builder.Ingress().Build("public", "")
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.golden, func(t *testing.T) {
+			got := render(t, test.build)
+			// map的遍历顺序随机, 多次生成的结果必须完全一致
+			for i := 0; i < 20; i++ {
+				if again := render(t, test.build); !bytes.Equal(got, again) {
+					t.Fatalf("render is not deterministic:\n%s\n---\n%s", got, again)
+				}
+			}
+			golden := filepath.Join("testdata", "golden", test.golden)
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s mismatch, run go test ./internal/resource/ -update\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+// TestServiceMonitorSelectsMainService ServiceMonitor只选择应用的主Service, 额外的Service不重复采集
+func TestServiceMonitorSelectsMainService(t *testing.T) {
+	builder := &DeployStackBuild{Instance: loadDeployStack(t), PrometheusOperator: true}
+	app := builder.Instance.Spec.Apps["hello"]
+	app.Metrics = &apiv1.AppMetricsSpec{Port: "http"}
+	builder.Instance.Spec.Apps["hello"] = app
+	monitor, err := builder.ServiceMonitor().Build("hello", "b11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	matchLabels, _, err := unstructured.NestedStringMap(monitor.(*unstructured.Unstructured).Object, "spec", "selector", "matchLabels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	services, err := builder.Service().BuildList("hello", "b11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var selected []string
+	for _, service := range services {
+		if k8slabels.SelectorFromSet(matchLabels).Matches(k8slabels.Set(service.GetLabels())) {
+			selected = append(selected, service.GetName())
+		}
+	}
+	if want := []string{"hello"}; !reflect.DeepEqual(selected, want) {
+		t.Errorf("ServiceMonitor selects %v, want %v", selected, want)
+	}
+}
+
+func TestUpdateMatchesBuild(t *testing.T) {
+	builder := &DeployStackBuild{Instance: loadDeployStack(t)}
+	built, err := builder.Ingress().Build("hello", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := builder.Ingress().Update(built.DeepCopyObject().(client.Object), "hello", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := yaml.Marshal(built)
+	got, _ := yaml.Marshal(updated)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Update changed an up-to-date Ingress:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPinnedDigest(t *testing.T) {
+	builder := &DeployStackBuild{Instance: loadDeployStack(t)}
+	image, _ := builder.ImageTag("hello", "b11")
+	builder.Digests = map[string]string{image: "sha256:0123"}
+	deploy, err := builder.Deployment().Build("hello", "b11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := builder.ImageRepository("hello") + "@sha256:0123"
+	for _, container := range deploy.(*appsv1.Deployment).Spec.Template.Spec.Containers {
+		if container.Name == "hello" && container.Image != want {
+			t.Errorf("image = %s, want %s", container.Image, want)
+		}
+	}
+	if !builder.TagDeployed(deploy.(*appsv1.Deployment), "hello", "b11") {
+		t.Error("TagDeployed() = false for pinned Deployment")
+	}
+}
+
+func TestRolloutOrder(t *testing.T) {
+	tests := []struct {
+		dependsOn map[string][]string
+		order     []string
+		cycle     []string
+	}{
+		{nil, []string{"a", "b", "c", "d"}, nil},
+		{map[string][]string{"a": {"c"}, "b": {"a", "d"}}, []string{"c", "a", "d", "b"}, nil},
+		{map[string][]string{"a": {"x"}}, []string{"a", "b", "c", "d"}, nil},
+		{map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}, "d": {"c"}}, []string{"c", "b", "a", "d"}, []string{"a", "b", "c", "a"}},
+		{map[string][]string{"b": {"b"}, "a": {"b"}}, []string{"c", "d", "b", "a"}, []string{"b", "b"}},
+		{map[string][]string{"a": {"d"}, "d": {"b"}, "b": {"c"}, "c": {"b"}}, []string{"c", "b", "d", "a"}, []string{"b", "c", "b"}},
+	}
+	for _, test := range tests {
+		deployStack := &apiv1.DeployStack{}
+		deployStack.Spec.AppsList = map[string]string{"a": "1", "b": "1", "c": "1", "d": "1"}
+		deployStack.Spec.Apps = map[string]apiv1.AppsName{}
+		for name, dependsOn := range test.dependsOn {
+			deployStack.Spec.Apps[name] = apiv1.AppsName{DependsOn: dependsOn}
+		}
+		order, cycle := (&DeployStackBuild{Instance: deployStack}).RolloutOrder()
+		if !reflect.DeepEqual(order, test.order) || !reflect.DeepEqual(cycle, test.cycle) {
+			t.Errorf("RolloutOrder(%v) = %v, %v, want %v, %v", test.dependsOn, order, cycle, test.order, test.cycle)
+		}
+	}
+}
+
+func TestServiceBackend(t *testing.T) {
+	tests := []struct {
+		name        string
+		portForHttp int32
+		backend     apiv1.IngressBackend
+		want        v1.IngressServiceBackend
+		wantErr     bool
+	}{
+		{"port number", 0, apiv1.IngressBackend{Service: "web", Port: apiv1.IngressBackendPort{Number: 8080}}, v1.IngressServiceBackend{Name: "web", Port: v1.ServiceBackendPort{Number: 8080}}, false},
+		{"app port name", 0, apiv1.IngressBackend{App: "hello", Port: apiv1.IngressBackendPort{Name: "http"}}, v1.IngressServiceBackend{Name: "hello", Port: v1.ServiceBackendPort{Name: "http-hello"}}, false},
+		{"portForHttp", 80, apiv1.IngressBackend{Service: "web"}, v1.IngressServiceBackend{Name: "web", Port: v1.ServiceBackendPort{Number: 80}}, false},
+		{"no port", 0, apiv1.IngressBackend{Service: "web"}, v1.IngressServiceBackend{}, true},
+		{"service and app", 80, apiv1.IngressBackend{Service: "web", App: "hello"}, v1.IngressServiceBackend{}, true},
+		{"app not in appsList", 80, apiv1.IngressBackend{App: "missing"}, v1.IngressServiceBackend{}, true},
+		{"port number and name", 0, apiv1.IngressBackend{Service: "web", Port: apiv1.IngressBackendPort{Number: 80, Name: "http"}}, v1.IngressServiceBackend{}, true},
+	}
+	for _, test := range tests {
+		deployStack := &apiv1.DeployStack{}
+		deployStack.Spec.PortForHttp = test.portForHttp
+		deployStack.Spec.AppsList = map[string]string{"hello": "1"}
+		backend, err := (&DeployStackBuild{Instance: deployStack}).serviceBackend(test.backend)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: serviceBackend() error = %v, wantErr %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(backend, test.want) {
+			t.Errorf("%s: serviceBackend() = %v, want %v", test.name, backend, test.want)
+		}
+	}
+}
+
+// routeSummary 路由名称及每条规则的"匹配 -> 后端:端口"
+func routeSummary(t *testing.T, routes []client.Object) map[string][]string {
+	t.Helper()
+	summary := map[string][]string{}
+	for _, route := range routes {
+		if _, ok := summary[route.GetName()]; ok {
+			t.Errorf("duplicate route %s", route.GetName())
+		}
+		rules, _, err := unstructured.NestedSlice(route.(*unstructured.Unstructured).Object, "spec", "rules")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rule := range rules {
+			rule := rule.(map[string]interface{})
+			match := "*"
+			if matches, ok := rule["matches"].([]interface{}); ok {
+				if value, ok, _ := unstructured.NestedString(matches[0].(map[string]interface{}), "path", "value"); ok {
+					match = value
+				}
+				if value, ok, _ := unstructured.NestedString(matches[0].(map[string]interface{}), "method", "service"); ok {
+					match = value
+				}
+			}
+			var backends []string
+			for _, backendRef := range rule["backendRefs"].([]interface{}) {
+				backendRef := backendRef.(map[string]interface{})
+				backends = append(backends, fmt.Sprintf("%s:%d", backendRef["name"], backendRef["port"]))
+			}
+			summary[route.GetName()] = append(summary[route.GetName()], fmt.Sprintf("%s -> %s", match, strings.Join(backends, ",")))
+		}
+	}
+	return summary
+}
+
+func TestRoutes(t *testing.T) {
+	gateway := &apiv1.RoutingSpec{Mode: apiv1.RoutingModeGateway, Gateway: &apiv1.GatewayRef{Name: "public"}}
+	tests := []struct {
+		name        string
+		routing     *apiv1.RoutingSpec
+		portForHttp int32
+		ingress     []apiv1.IngressSpec
+		http        map[string][]string
+		grpc        map[string][]string
+		wantErr     bool
+	}{
+		{
+			name:    "ingress mode",
+			ingress: []apiv1.IngressSpec{{Name: "web", Host: "a.example.com", Prefix: map[string]string{"/": "hello 80"}}},
+			http:    map[string][]string{},
+			grpc:    map[string][]string{},
+		},
+		{
+			name:    "one route per host",
+			routing: gateway,
+			ingress: []apiv1.IngressSpec{
+				{Name: "web", Host: "a.example.com", Prefix: map[string]string{"/hello/*": "hello 80"}},
+				{Name: "web", Host: "b.example.com", Exact: map[string]string{"/healthz": "hello 80"}},
+				{Name: "web", Host: "a.example.com", Prefix: map[string]string{"/world": "world 8080"}},
+				{Name: "other", Host: "a.example.com", Prefix: map[string]string{"/": "other 80"}},
+			},
+			http: map[string][]string{
+				"web-a-example-com":   {"/hello -> hello:80", "/world -> world:8080"},
+				"web-b-example-com":   {"/healthz -> hello:80"},
+				"other-a-example-com": {"/ -> other:80"},
+			},
+			grpc: map[string][]string{},
+		},
+		{
+			name:        "weighted backends use portForHttp",
+			routing:     gateway,
+			portForHttp: 8800,
+			ingress: []apiv1.IngressSpec{{
+				Name:             "web",
+				Host:             "a.example.com",
+				Prefix:           map[string]string{"/": "hello"},
+				WeightedBackends: map[string][]apiv1.WeightedBackend{"/": {{Service: "hello"}, {Service: "canary", Port: 9090}}},
+			}},
+			http: map[string][]string{"web-a-example-com": {"/ -> hello:8800,canary:9090"}},
+			grpc: map[string][]string{},
+		},
+		{
+			name:    "weighted backend without port",
+			routing: gateway,
+			ingress: []apiv1.IngressSpec{{
+				Name:             "web",
+				Host:             "a.example.com",
+				Prefix:           map[string]string{"/": "hello 80"},
+				WeightedBackends: map[string][]apiv1.WeightedBackend{"/": {{Service: "hello"}}},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "grpc routes merged per host",
+			routing: gateway,
+			ingress: []apiv1.IngressSpec{
+				{Name: "api", Host: "grpc.example.com", Grpc: []apiv1.GRPCRouteMatch{{Service: "hello.Greeter", Backends: []apiv1.WeightedBackend{{Service: "hello"}}}}},
+				{Name: "api", Host: "grpc.example.com", Grpc: []apiv1.GRPCRouteMatch{{Backends: []apiv1.WeightedBackend{{Service: "world", Port: 9000}}}}},
+			},
+			http: map[string][]string{},
+			grpc: map[string][]string{"api-grpc-example-com": {"hello.Greeter -> hello:5010", "* -> world:9000"}},
+		},
+		{
+			name:    "grpc without backends",
+			routing: gateway,
+			ingress: []apiv1.IngressSpec{{Name: "api", Host: "grpc.example.com", Grpc: []apiv1.GRPCRouteMatch{{Service: "hello.Greeter"}}}},
+			wantErr: true,
+		},
+		{
+			name:    "gateway required",
+			routing: &apiv1.RoutingSpec{Mode: apiv1.RoutingModeGateway},
+			ingress: []apiv1.IngressSpec{{Name: "web", Host: "a.example.com", Prefix: map[string]string{"/": "hello 80"}}},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		deployStack := &apiv1.DeployStack{}
+		deployStack.Spec.Namespace = "dev"
+		deployStack.Spec.Routing = test.routing
+		deployStack.Spec.PortForHttp = test.portForHttp
+		deployStack.Spec.Ingress = test.ingress
+		builder := &DeployStackBuild{Instance: deployStack}
+		http, grpc := map[string][]string{}, map[string][]string{}
+		var err error
+		for _, name := range builder.IngressNames() {
+			var routes []client.Object
+			if routes, err = builder.HTTPRoute().BuildList(name, ""); err != nil {
+				break
+			}
+			for key, value := range routeSummary(t, routes) {
+				http[key] = value
+			}
+			if routes, err = builder.GRPCRoute().BuildList(name, ""); err != nil {
+				break
+			}
+			for key, value := range routeSummary(t, routes) {
+				grpc[key] = value
+			}
+		}
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: BuildList() error = %v, wantErr %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(http, test.http) {
+			t.Errorf("%s: HTTPRoutes = %v, want %v", test.name, http, test.http)
+		}
+		if !reflect.DeepEqual(grpc, test.grpc) {
+			t.Errorf("%s: GRPCRoutes = %v, want %v", test.name, grpc, test.grpc)
+		}
+	}
+}
+
+func TestIssuerAnnotations(t *testing.T) {
+	letsencrypt := &apiv1.IssuerRef{Name: "letsencrypt"}
+	tests := []struct {
+		name        string
+		certManager bool
+		issuers     []*apiv1.IssuerRef
+		want        map[string]string
+		wantErr     bool
+	}{
+		{"no issuer", false, []*apiv1.IssuerRef{nil}, map[string]string{}, false},
+		{"cluster issuer", false, []*apiv1.IssuerRef{nil, letsencrypt}, map[string]string{"cert-manager.io/cluster-issuer": "letsencrypt"}, false},
+		{"same issuer", false, []*apiv1.IssuerRef{letsencrypt, {Name: "letsencrypt", Kind: "ClusterIssuer"}}, map[string]string{"cert-manager.io/cluster-issuer": "letsencrypt"}, false},
+		{"issuer", false, []*apiv1.IssuerRef{{Name: "local", Kind: "Issuer"}}, map[string]string{"cert-manager.io/issuer": "local"}, false},
+		{"conflicting issuers", false, []*apiv1.IssuerRef{letsencrypt, {Name: "internal-ca"}}, nil, true},
+		{"conflicting kinds", false, []*apiv1.IssuerRef{letsencrypt, {Name: "letsencrypt", Kind: "Issuer"}}, nil, true},
+		{"cert-manager installed", true, []*apiv1.IssuerRef{letsencrypt, {Name: "internal-ca"}}, map[string]string{}, false},
+	}
+	for _, test := range tests {
+		deployStack := &apiv1.DeployStack{}
+		for i, issuer := range test.issuers {
+			deployStack.Spec.Ingress = append(deployStack.Spec.Ingress, apiv1.IngressSpec{
+				Name: "web",
+				Host: fmt.Sprintf("host%d.example.com", i),
+				TLS:  &apiv1.IngressTLS{Issuer: issuer},
+			})
+		}
+		annotations, err := (&DeployStackBuild{Instance: deployStack, CertManager: test.certManager}).issuerAnnotations("web")
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: issuerAnnotations() error = %v, wantErr %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(annotations, test.want) {
+			t.Errorf("%s: issuerAnnotations() = %v, want %v", test.name, annotations, test.want)
+		}
+	}
+}
+
+func TestScheduling(t *testing.T) {
+	zone := corev1.TopologySpreadConstraint{MaxSkew: 2, TopologyKey: "topology.kubernetes.io/zone", WhenUnsatisfiable: corev1.DoNotSchedule}
+	rack := corev1.TopologySpreadConstraint{MaxSkew: 1, TopologyKey: "rack", WhenUnsatisfiable: corev1.ScheduleAnyway}
+	defaultAffinity := &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{}}
+	stackAffinity := &corev1.Affinity{PodAffinity: &corev1.PodAffinity{}}
+	appAffinity := &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{}}
+	tests := []struct {
+		name            string
+		spec            apiv1.DeployStackSpec
+		tolerations     []corev1.Toleration
+		nodeSelector    map[string]string
+		affinity        *corev1.Affinity
+		topologyKeys    []string
+		topologyMaxSkew []int32
+	}{
+		{
+			name:     "defaults",
+			affinity: defaultAffinity,
+		},
+		{
+			name: "stack and app settings are merged",
+			spec: apiv1.DeployStackSpec{
+				Toleration:   &corev1.Toleration{Key: "legacy"},
+				Tolerations:  []corev1.Toleration{{Key: "stack"}},
+				NodeSelector: map[string]string{"os": "linux", "disk": "hdd"},
+				Affinity:     stackAffinity,
+				Apps: map[string]apiv1.AppsName{"hello": {
+					Tolerations:  []corev1.Toleration{{Key: "app"}},
+					NodeSelector: map[string]string{"disk": "ssd"},
+				}},
+			},
+			tolerations:  []corev1.Toleration{{Key: "legacy"}, {Key: "stack"}, {Key: "app"}},
+			nodeSelector: map[string]string{"os": "linux", "disk": "ssd"},
+			affinity:     stackAffinity,
+		},
+		{
+			name: "app affinity wins",
+			spec: apiv1.DeployStackSpec{
+				Affinity: stackAffinity,
+				Apps:     map[string]apiv1.AppsName{"hello": {Affinity: appAffinity}},
+			},
+			affinity: appAffinity,
+		},
+		{
+			name:            "preset",
+			spec:            apiv1.DeployStackSpec{TopologySpreadPreset: apiv1.TopologySpreadHostname},
+			affinity:        defaultAffinity,
+			topologyKeys:    []string{"kubernetes.io/hostname"},
+			topologyMaxSkew: []int32{1},
+		},
+		{
+			name: "constraints and preset",
+			spec: apiv1.DeployStackSpec{
+				TopologySpreadConstraints: []corev1.TopologySpreadConstraint{rack},
+				TopologySpreadPreset:      apiv1.TopologySpreadZone,
+			},
+			affinity:        defaultAffinity,
+			topologyKeys:    []string{"rack", "topology.kubernetes.io/zone"},
+			topologyMaxSkew: []int32{1, 1},
+		},
+		{
+			name: "constraint overrides preset with the same key",
+			spec: apiv1.DeployStackSpec{
+				TopologySpreadPreset: apiv1.TopologySpreadZone,
+				Apps:                 map[string]apiv1.AppsName{"hello": {TopologySpreadConstraints: []corev1.TopologySpreadConstraint{zone}}},
+			},
+			affinity:        defaultAffinity,
+			topologyKeys:    []string{"topology.kubernetes.io/zone"},
+			topologyMaxSkew: []int32{2},
+		},
+	}
+	for _, test := range tests {
+		builder := &DeployStackBuild{Instance: &apiv1.DeployStack{Spec: test.spec}}
+		if got := builder.tolerations("hello"); !reflect.DeepEqual(got, test.tolerations) {
+			t.Errorf("%s: tolerations = %v, want %v", test.name, got, test.tolerations)
+		}
+		if got := builder.nodeSelector("hello"); !reflect.DeepEqual(got, test.nodeSelector) {
+			t.Errorf("%s: nodeSelector = %v, want %v", test.name, got, test.nodeSelector)
+		}
+		if got := builder.affinity("hello", defaultAffinity); !reflect.DeepEqual(got, test.affinity) {
+			t.Errorf("%s: affinity = %v, want %v", test.name, got, test.affinity)
+		}
+		var keys []string
+		var maxSkew []int32
+		for _, constraint := range builder.topologySpreadConstraints("hello", "dev") {
+			keys = append(keys, constraint.TopologyKey)
+			maxSkew = append(maxSkew, constraint.MaxSkew)
+			if constraint.LabelSelector == nil {
+				t.Errorf("%s: constraint %s has no labelSelector", test.name, constraint.TopologyKey)
+			}
+		}
+		if !reflect.DeepEqual(keys, test.topologyKeys) || !reflect.DeepEqual(maxSkew, test.topologyMaxSkew) {
+			t.Errorf("%s: topology = %v %v, want %v %v", test.name, keys, maxSkew, test.topologyKeys, test.topologyMaxSkew)
+		}
+	}
+}
+
+func TestIngressClassName(t *testing.T) {
+	// 集群中的IngressClass及其spec.controller
+	classes := map[string]string{
+		"traefik-public":     "traefik.io/ingress-controller",
+		"alb-internal":       "ingress.k8s.aws/alb",
+		"internal-nginx-alb": "k8s.io/ingress-nginx",
+		"custom":             "example.com/ingress-controller",
+	}
+	tests := []struct {
+		name       string
+		spec       apiv1.DeployStackSpec
+		className  string
+		controller apiv1.IngressController
+		wantErr    bool
+	}{
+		{"default", apiv1.DeployStackSpec{}, "nginx", apiv1.IngressControllerNginx, false},
+		{"from spec class controller", apiv1.DeployStackSpec{IngressClassName: "traefik-public"}, "traefik-public", apiv1.IngressControllerTraefik, false},
+		{"class name not matched by substring", apiv1.DeployStackSpec{IngressClassName: "internal-nginx-alb"}, "internal-nginx-alb", apiv1.IngressControllerNginx, false},
+		{"unknown controller is nginx", apiv1.DeployStackSpec{IngressClassName: "custom"}, "custom", apiv1.IngressControllerNginx, false},
+		{"class not in cluster named after controller", apiv1.DeployStackSpec{Ingress: []apiv1.IngressSpec{{Name: "web", IngressClassName: "alb"}}}, "alb", apiv1.IngressControllerALB, false},
+		{"class not in cluster is nginx", apiv1.DeployStackSpec{IngressClassName: "traefik-internal"}, "traefik-internal", apiv1.IngressControllerNginx, false},
+		{"spec controller", apiv1.DeployStackSpec{IngressClassName: "public", IngressController: apiv1.IngressControllerTraefik}, "public", apiv1.IngressControllerTraefik, false},
+		{
+			"rule class ignores spec controller",
+			apiv1.DeployStackSpec{IngressController: apiv1.IngressControllerTraefik, Ingress: []apiv1.IngressSpec{{Name: "web", IngressClassName: "alb-internal"}}},
+			"alb-internal", apiv1.IngressControllerALB, false,
+		},
+		{
+			"rule repeating spec class uses spec controller",
+			apiv1.DeployStackSpec{IngressClassName: "public", IngressController: apiv1.IngressControllerTraefik, Ingress: []apiv1.IngressSpec{{Name: "web", IngressClassName: "public"}}},
+			"public", apiv1.IngressControllerTraefik, false,
+		},
+		{
+			"rule repeating default class uses spec controller",
+			apiv1.DeployStackSpec{IngressController: apiv1.IngressControllerALB, Ingress: []apiv1.IngressSpec{{Name: "web", IngressClassName: "nginx"}}},
+			"nginx", apiv1.IngressControllerALB, false,
+		},
+		{
+			"rule controller",
+			apiv1.DeployStackSpec{Ingress: []apiv1.IngressSpec{{Name: "web", IngressClassName: "public", Controller: apiv1.IngressControllerALB}}},
+			"public", apiv1.IngressControllerALB, false,
+		},
+		{
+			"same class in all rules",
+			apiv1.DeployStackSpec{IngressClassName: "traefik", Ingress: []apiv1.IngressSpec{{Name: "web"}, {Name: "web", IngressClassName: "traefik"}}},
+			"traefik", apiv1.IngressControllerTraefik, false,
+		},
+		{
+			"spec class unset in one rule and repeated in another",
+			apiv1.DeployStackSpec{IngressClassName: "public", IngressController: apiv1.IngressControllerALB, Ingress: []apiv1.IngressSpec{{Name: "web"}, {Name: "web", IngressClassName: "public"}}},
+			"public", apiv1.IngressControllerALB, false,
+		},
+		{
+			"conflicting classes",
+			apiv1.DeployStackSpec{Ingress: []apiv1.IngressSpec{{Name: "web"}, {Name: "web", IngressClassName: "traefik"}}},
+			"", "", true,
+		},
+	}
+	for _, test := range tests {
+		builder := (&DeployStackBuild{Instance: &apiv1.DeployStack{Spec: test.spec}, IngressClassControllers: classes}).Ingress()
+		className, controller, err := builder.ingressClassName("web")
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: ingressClassName() error = %v, wantErr %v", test.name, err, test.wantErr)
+			continue
+		}
+		if className != test.className || controller != test.controller {
+			t.Errorf("%s: ingressClassName() = %q, %q, want %q, %q", test.name, className, controller, test.className, test.controller)
+		}
+	}
+}
+
+func TestIngressRules(t *testing.T) {
+	deployStack := &apiv1.DeployStack{}
+	deployStack.Spec.PortForHttp = 8800
+	deployStack.Spec.Ingress = []apiv1.IngressSpec{
+		{Name: "web", Host: "a.example.com", Prefix: map[string]string{"/api": "api 8080"}, Paths: []apiv1.IngressPath{
+			{Path: "/", Backend: apiv1.IngressBackend{Service: "web", Port: apiv1.IngressBackendPort{Number: 80}}},
+		}},
+		{Name: "admin", Host: "a.example.com", Prefix: map[string]string{"/admin": "admin"}},
+		{Name: "web", Host: "b.example.com", Exact: map[string]string{"/": "web"}},
+		{Name: "web", Host: "a.example.com", Exact: map[string]string{"/health": "web"}, Match: map[string]string{"/static": "cdn"}},
+	}
+	rules, err := (&DeployStackBuild{Instance: deployStack}).Ingress().ingressRules("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, rule := range rules {
+		for _, path := range rule.HTTP.Paths {
+			got = append(got, fmt.Sprintf("%s%s %s %s:%d", rule.Host, path.Path, *path.PathType, path.Backend.Service.Name, path.Backend.Service.Port.Number))
+		}
+	}
+	want := []string{
+		"a.example.com/ Prefix web:80",
+		"a.example.com/api Prefix api:8080",
+		"a.example.com/static ImplementationSpecific cdn:8800",
+		"a.example.com/health Exact web:8800",
+		"b.example.com/ Exact web:8800",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ingressRules() = %v, want %v", got, want)
+	}
+	if err := checkRules("web", rules); err != nil {
+		t.Errorf("checkRules() = %v", err)
+	}
+
+	// 同一域名下相同路径指向不同后端
+	deployStack.Spec.Ingress = append(deployStack.Spec.Ingress, apiv1.IngressSpec{Name: "web", Host: "a.example.com", Prefix: map[string]string{"/api": "api 9090"}})
+	if rules, err = (&DeployStackBuild{Instance: deployStack}).Ingress().ingressRules("web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkRules("web", rules); err == nil {
+		t.Errorf("checkRules() accepted conflicting backends for /api")
+	}
+
+	deployStack.Spec.Ingress = []apiv1.IngressSpec{{Name: "web", Host: "a.example.com", Prefix: map[string]string{"/": "web 80 extra"}}}
+	if _, err := (&DeployStackBuild{Instance: deployStack}).Ingress().ingressRules("web"); err == nil {
+		t.Errorf("ingressRules() accepted a malformed backend")
+	}
+}
+
+func TestStatefulSetResources(t *testing.T) {
+	tests := []struct {
+		name            string
+		resourcesMemory string
+		resourcesCpu    string
+		want            corev1.ResourceRequirements
+		wantErr         bool
+	}{
+		{name: "no resources", want: corev1.ResourceRequirements{}},
+		{name: "resources", resourcesMemory: "100Mi-1Gi", resourcesCpu: "10m-1", want: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("100Mi"), corev1.ResourceCPU: resource.MustParse("10m")},
+			Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi"), corev1.ResourceCPU: resource.MustParse("1")},
+		}},
+		{name: "invalid memory", resourcesMemory: "1Gi", wantErr: true},
+		{name: "invalid cpu", resourcesCpu: "10m-x", wantErr: true},
+	}
+	for _, test := range tests {
+		deployStack := &apiv1.DeployStack{}
+		deployStack.Spec.Namespace = "dev"
+		deployStack.Spec.ResourcesMemory = test.resourcesMemory
+		deployStack.Spec.ResourcesCpu = test.resourcesCpu
+		deployStack.Spec.AppsList = map[string]string{"hello": "v1"}
+		object, err := (&DeployStackBuild{Instance: deployStack}).StatefulSet().Build("hello", "v1")
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: Build() error = %v, wantErr %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if resources := object.(*appsv1.StatefulSet).Spec.Template.Spec.Containers[0].Resources; !reflect.DeepEqual(resources, test.want) {
+			t.Errorf("%s: resources = %v, want %v", test.name, resources, test.want)
+		}
+	}
+}
+
+func TestTagDeployed(t *testing.T) {
+	deployment := func(annotations map[string]string, image string) *appsv1.Deployment {
+		deploy := &appsv1.Deployment{}
+		deploy.Annotations = annotations
+		deploy.Spec.Template.Spec.Containers = []corev1.Container{{Name: "hello", Image: image}}
+		return deploy
+	}
+	builder := &DeployStackBuild{Instance: &apiv1.DeployStack{}}
+	image, _ := builder.containerImage("hello", "v1")
+	tests := []struct {
+		name   string
+		deploy *appsv1.Deployment
+		tag    string
+		want   bool
+	}{
+		{"tag annotation", deployment(map[string]string{TagAnnotation: "v1"}, ""), "v1", true},
+		{"other tag annotation", deployment(map[string]string{TagAnnotation: "v0"}, image), "v1", false},
+		{"default tag", deployment(map[string]string{TagAnnotation: defaultTag}, ""), "", true},
+		{"image without annotation", deployment(nil, image), "v1", true},
+		{"other image without annotation", deployment(nil, image), "v2", false},
+	}
+	for _, test := range tests {
+		if got := builder.TagDeployed(test.deploy, "hello", test.tag); got != test.want {
+			t.Errorf("%s: TagDeployed() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPodExtras(t *testing.T) {
+	containers := func(names ...string) []corev1.Container {
+		var containers []corev1.Container
+		for _, name := range names {
+			containers = append(containers, corev1.Container{Name: name, Image: name})
+		}
+		return containers
+	}
+	volumes := func(names ...string) []corev1.Volume {
+		var volumes []corev1.Volume
+		for _, name := range names {
+			volumes = append(volumes, corev1.Volume{Name: name, VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}})
+		}
+		return volumes
+	}
+	templates := map[string]apiv1.SidecarTemplate{
+		"logging": {InitContainers: containers("log-init"), Containers: containers("log-agent"), Volumes: volumes("logs")},
+		"proxy":   {Containers: containers("proxy")},
+	}
+	tests := []struct {
+		name           string
+		app            apiv1.AppsName
+		initContainers []string
+		sidecars       []string
+		volumes        []string
+		wantErr        bool
+	}{
+		{name: "no extras"},
+		{
+			name:           "templates before app containers",
+			app:            apiv1.AppsName{SidecarRefs: []string{"proxy", "logging"}, InitContainers: containers("migrate"), Sidecars: containers("cache"), Volumes: volumes("tmp")},
+			initContainers: []string{"log-init", "migrate"},
+			sidecars:       []string{"proxy", "log-agent", "cache"},
+			volumes:        []string{"logs", "tmp"},
+		},
+		{
+			name:    "storage volumes",
+			app:     apiv1.AppsName{Storage: []apiv1.StorageSpec{{Name: "data", MountPath: "/data"}}},
+			volumes: []string{"data-data"},
+		},
+		{name: "unknown template", app: apiv1.AppsName{SidecarRefs: []string{"missing"}}, wantErr: true},
+		{name: "duplicate sidecar", app: apiv1.AppsName{SidecarRefs: []string{"proxy"}, Sidecars: containers("proxy")}, wantErr: true},
+		{name: "sidecar named after app", app: apiv1.AppsName{Sidecars: containers("hello")}, wantErr: true},
+		{name: "init container and sidecar", app: apiv1.AppsName{InitContainers: containers("setup"), Sidecars: containers("setup")}, wantErr: true},
+		{name: "template referenced twice", app: apiv1.AppsName{SidecarRefs: []string{"logging", "logging"}}, wantErr: true},
+		{name: "duplicate volume", app: apiv1.AppsName{SidecarRefs: []string{"logging"}, Volumes: volumes("logs")}, wantErr: true},
+		{name: "volume named like config", app: apiv1.AppsName{Volumes: volumes("hello-config")}, wantErr: true},
+	}
+	names := func(containers []corev1.Container) []string {
+		var names []string
+		for _, container := range containers {
+			names = append(names, container.Name)
+		}
+		return names
+	}
+	for _, test := range tests {
+		deployStack := &apiv1.DeployStack{}
+		deployStack.Spec.SidecarTemplates = templates
+		deployStack.Spec.Apps = map[string]apiv1.AppsName{"hello": test.app}
+		extras, err := (&DeployStackBuild{Instance: deployStack}).podExtras("hello")
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: podExtras() error = %v, wantErr %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := names(extras.initContainers); !reflect.DeepEqual(got, test.initContainers) {
+			t.Errorf("%s: initContainers = %v, want %v", test.name, got, test.initContainers)
+		}
+		if got := names(extras.sidecars); !reflect.DeepEqual(got, test.sidecars) {
+			t.Errorf("%s: sidecars = %v, want %v", test.name, got, test.sidecars)
+		}
+		var gotVolumes []string
+		for _, volume := range extras.volumes {
+			gotVolumes = append(gotVolumes, volume.Name)
+		}
+		if !reflect.DeepEqual(gotVolumes, test.volumes) {
+			t.Errorf("%s: volumes = %v, want %v", test.name, gotVolumes, test.volumes)
+		}
+	}
+
+	// 模版被多个应用引用时互不影响
+	deployStack := &apiv1.DeployStack{}
+	deployStack.Spec.SidecarTemplates = templates
+	deployStack.Spec.Apps = map[string]apiv1.AppsName{"hello": {SidecarRefs: []string{"logging"}}}
+	extras, err := (&DeployStackBuild{Instance: deployStack}).podExtras("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	extras.sidecars[0].Image = "changed"
+	if image := templates["logging"].Containers[0].Image; image != "log-agent" {
+		t.Errorf("podExtras modified the sidecar template, image = %q", image)
+	}
+}
+
+// TestJobName Job名称带配置hash, 配置不变时名称稳定, 配置变化时生成新的Job
+func TestJobName(t *testing.T) {
+	jobName := func(job apiv1.JobSpec, tag string) string {
+		t.Helper()
+		deployStack := loadDeployStack(t)
+		deployStack.Spec.Jobs = map[string]apiv1.JobSpec{"migrate": job}
+		object, err := (&DeployStackBuild{Instance: deployStack}).Job().Build("migrate", tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return object.GetName()
+	}
+	job := apiv1.JobSpec{Command: []string{"migrate", "up"}}
+	name := jobName(job, "v1")
+	if !strings.HasPrefix(name, "migrate-") || len(name) != len("migrate-")+8 {
+		t.Fatalf("job name = %q, want migrate-<hash>", name)
+	}
+	if again := jobName(apiv1.JobSpec{Command: []string{"migrate", "up"}}, "v1"); again != name {
+		t.Errorf("job name changed without spec change: %q != %q", again, name)
+	}
+	backoffLimit := int32(1)
+	changes := map[string]struct {
+		job apiv1.JobSpec
+		tag string
+	}{
+		"tag":          {job, "v2"},
+		"command":      {apiv1.JobSpec{Command: []string{"migrate", "down"}}, "v1"},
+		"env":          {apiv1.JobSpec{Command: job.Command, Env: []corev1.EnvVar{{Name: "DRY_RUN", Value: "1"}}}, "v1"},
+		"backoffLimit": {apiv1.JobSpec{Command: job.Command, BackoffLimit: &backoffLimit}, "v1"},
+	}
+	for field, change := range changes {
+		if changed := jobName(change.job, change.tag); changed == name {
+			t.Errorf("%s changed but job name stayed %q", field, name)
+		}
+	}
+}
+
+// TestCertificateConflict 多个ingress名称签发同一个证书时报错, 避免Certificate所有者互相覆盖
+func TestCertificateConflict(t *testing.T) {
+	letsencrypt := &apiv1.IssuerRef{Name: "letsencrypt"}
+	tests := []struct {
+		name    string
+		ingress []apiv1.IngressSpec
+		wantErr bool
+	}{
+		{"different hosts", []apiv1.IngressSpec{
+			{Name: "web", Host: "www.example.com", TLS: &apiv1.IngressTLS{Issuer: letsencrypt}},
+			{Name: "api", Host: "api.example.com", TLS: &apiv1.IngressTLS{Issuer: letsencrypt}},
+		}, false},
+		{"same host in one ingress", []apiv1.IngressSpec{
+			{Name: "web", Host: "www.example.com", TLS: &apiv1.IngressTLS{Issuer: letsencrypt}},
+			{Name: "web", Host: "www.example.com", TLS: &apiv1.IngressTLS{Issuer: letsencrypt}},
+		}, false},
+		{"same host in two ingresses", []apiv1.IngressSpec{
+			{Name: "web", Host: "www.example.com", TLS: &apiv1.IngressTLS{Issuer: letsencrypt}},
+			{Name: "api", Host: "www.example.com", TLS: &apiv1.IngressTLS{Issuer: letsencrypt}},
+		}, true},
+		{"same secret in two ingresses", []apiv1.IngressSpec{
+			{Name: "web", Host: "www.example.com", TLS: &apiv1.IngressTLS{SecretName: "example-tls", Issuer: letsencrypt}},
+			{Name: "api", Host: "api.example.com", TLS: &apiv1.IngressTLS{SecretName: "example-tls", Issuer: letsencrypt}},
+		}, true},
+		{"issuer in one ingress, secret referenced in the other", []apiv1.IngressSpec{
+			{Name: "web", Host: "www.example.com", TLS: &apiv1.IngressTLS{Issuer: letsencrypt}},
+			{Name: "api", Host: "www.example.com", TLS: &apiv1.IngressTLS{SecretName: "www-example-com-tls"}},
+		}, false},
+	}
+	for _, test := range tests {
+		for _, certManager := range []bool{true, false} {
+			deployStack := &apiv1.DeployStack{}
+			deployStack.Namespace, deployStack.Spec.Namespace = "default", "dev"
+			deployStack.Spec.Ingress = test.ingress
+			builder := &DeployStackBuild{Instance: deployStack, CertManager: certManager}
+			for _, name := range builder.IngressNames() {
+				// 未安装cert-manager时由ingress注解签发, 同样会冲突
+				if _, err := builder.Ingress().Build(name, ""); (err != nil) != test.wantErr {
+					t.Errorf("%s (certManager=%v): Ingress %s error = %v, wantErr %v", test.name, certManager, name, err, test.wantErr)
+				}
+				if !certManager {
+					continue
+				}
+				if _, err := builder.Certificate().BuildList(name, ""); (err != nil) != test.wantErr {
+					t.Errorf("%s: Certificate %s error = %v, wantErr %v", test.name, name, err, test.wantErr)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package resource
+
+import (
+	"fmt"
+	"strings"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	certManagerGroup     = "cert-manager.io"
+	defaultIssuerKind    = "ClusterIssuer"
+	clusterIssuerAnnoKey = "cert-manager.io/cluster-issuer"
+	issuerAnnoKey        = "cert-manager.io/issuer"
+)
+
+// CertificateGVK cert-manager Certificate, 未引入cert-manager依赖, 使用unstructured
+var CertificateGVK = schema.GroupVersionKind{Group: certManagerGroup, Version: "v1", Kind: "Certificate"}
+
+// TLSSecret 使用同一个证书secret的域名
+type TLSSecret struct {
+	SecretName string
+	Hosts      []string
+	Issuer     *apiv1.IssuerRef
+}
+
+// TLSSecrets 按证书secret汇总ingress中开启https的域名, 保持配置中的顺序
+func (builder *DeployStackBuild) TLSSecrets(name string) []TLSSecret {
+	var secrets []TLSSecret
+	index := map[string]int{}
+	for _, ingress := range builder.Instance.Spec.Ingress {
+		if ingress.Name != name || (!ingress.Https && ingress.TLS == nil) {
+			continue
+		}
+		secretName := defaultSSL
+		var issuer *apiv1.IssuerRef
+		if ingress.TLS != nil {
+			issuer = ingress.TLS.Issuer
+			if ingress.TLS.SecretName != "" {
+				secretName = ingress.TLS.SecretName
+			} else if issuer != nil {
+				secretName = StringCombin(strings.ReplaceAll(strings.TrimPrefix(ingress.Host, "*."), ".", "-"), "-", "tls")
+			}
+		}
+		i, ok := index[secretName]
+		if !ok {
+			index[secretName] = len(secrets)
+			secrets = append(secrets, TLSSecret{SecretName: secretName, Issuer: issuer})
+			i = len(secrets) - 1
+		}
+		if secrets[i].Issuer == nil {
+			secrets[i].Issuer = issuer
+		}
+		secrets[i].Hosts = append(secrets[i].Hosts, ingress.Host)
+	}
+	return secrets
+}
+
+// certificateConflict 证书secret只能由一个ingress名称签发, 多个ingress名称使用相同域名及issuer时
+// 会生成同名的Certificate, 所有者互相覆盖
+func (builder *DeployStackBuild) certificateConflict(name string) error {
+	issued := map[string]bool{}
+	for _, secret := range builder.TLSSecrets(name) {
+		if secret.Issuer != nil {
+			issued[secret.SecretName] = true
+		}
+	}
+	for _, other := range builder.IngressNames() {
+		if other == name {
+			continue
+		}
+		for _, secret := range builder.TLSSecrets(other) {
+			if secret.Issuer != nil && issued[secret.SecretName] {
+				return fmt.Errorf("ingress %s and %s both issue certificate %s for %s, configure the issuer in only one of them and set tls.secretName %s in the other",
+					name, other, secret.SecretName, strings.Join(secret.Hosts, ", "), secret.SecretName)
+			}
+		}
+	}
+	return nil
+}
+
+// issuerAnnotations 未安装cert-manager CRD时通过ingress注解签发证书, 注解只能指定一个issuer, 同名ingress规则使用不同issuer时报错
+func (builder *DeployStackBuild) issuerAnnotations(name string) (map[string]string, error) {
+	if err := builder.certificateConflict(name); err != nil {
+		return nil, err
+	}
+	annotations := map[string]string{}
+	if builder.CertManager {
+		return annotations, nil
+	}
+	var current *apiv1.IssuerRef
+	for _, secret := range builder.TLSSecrets(name) {
+		if secret.Issuer == nil {
+			continue
+		}
+		if current != nil && (current.Name != secret.Issuer.Name || issuerKind(current) != issuerKind(secret.Issuer)) {
+			return nil, fmt.Errorf("ingress %s: conflicting issuers %q and %q, install cert-manager CRDs to use different issuers", name, current.Name, secret.Issuer.Name)
+		}
+		current = secret.Issuer
+	}
+	if current == nil {
+		return annotations, nil
+	}
+	if issuerKind(current) == defaultIssuerKind {
+		annotations[clusterIssuerAnnoKey] = current.Name
+	} else {
+		annotations[issuerAnnoKey] = current.Name
+	}
+	return annotations, nil
+}
+
+func issuerKind(issuer *apiv1.IssuerRef) string {
+	if issuer.Kind == "" {
+		return defaultIssuerKind
+	}
+	return issuer.Kind
+}
+
+type CertificateBuild struct {
+	*DeployStackBuild
+}
+
+func (builder *DeployStackBuild) Certificate() *CertificateBuild {
+
+	return &CertificateBuild{builder}
+}
+
+func (builder *CertificateBuild) ExecStrategy() bool {
+	return false
+}
+
+func (builder *CertificateBuild) GetObjectKind() (client.Object, error) {
+	certificate := &unstructured.Unstructured{}
+	certificate.SetGroupVersionKind(CertificateGVK)
+	return certificate, nil
+}
+
+func (builder *CertificateBuild) Names() []string {
+	return builder.IngressNames()
+}
+
+// Build 返回ingress的第一个Certificate, 完整列表见 BuildList
+func (builder *CertificateBuild) Build(name, tag string) (client.Object, error) {
+	certificates, err := builder.BuildList(name, tag)
+	if err != nil || len(certificates) == 0 {
+		return nil, err
+	}
+	return certificates[0], nil
+}
+
+// BuildList 安装了cert-manager CRD时, 为配置了issuer的证书创建Certificate
+func (builder *CertificateBuild) BuildList(name, tag string) ([]client.Object, error) {
+	var certificates []client.Object
+	if !builder.CertManager {
+		return certificates, nil
+	}
+	if err := builder.certificateConflict(name); err != nil {
+		return nil, err
+	}
+	for _, secret := range builder.TLSSecrets(name) {
+		if secret.Issuer == nil {
+			continue
+		}
+		certificate, err := builder.GetObjectKind()
+		if err != nil {
+			return nil, err
+		}
+		certificate.SetName(secret.SecretName)
+		certificate.SetNamespace(builder.Instance.Spec.Namespace)
+		builder.certificate(certificate.(*unstructured.Unstructured), name, secret)
+		if err := builder.setOwner(certificate); err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+	return certificates, nil
+}
+
+func (builder *CertificateBuild) Update(object client.Object, name, tag string) (client.Object, error) {
+	certificate := object.(*unstructured.Unstructured)
+	for _, secret := range builder.TLSSecrets(name) {
+		if secret.SecretName == certificate.GetName() && secret.Issuer != nil {
+			builder.certificate(certificate, name, secret)
+			break
+		}
+	}
+	return certificate, nil
+}
+
+func (builder *CertificateBuild) certificate(certificate *unstructured.Unstructured, name string, secret TLSSecret) {
+	var dnsNames []interface{}
+	for _, host := range secret.Hosts {
+		dnsNames = append(dnsNames, host)
+	}
+	certificateLabels := map[string]string{}
+	for key, value := range Labels(name, builder.Instance.Spec.Namespace) {
+		certificateLabels[key] = value
+	}
+	certificate.SetLabels(certificateLabels)
+	certificate.Object["spec"] = map[string]interface{}{
+		"secretName": secret.SecretName,
+		"dnsNames":   dnsNames,
+		"issuerRef": map[string]interface{}{
+			"name":  secret.Issuer.Name,
+			"kind":  issuerKind(secret.Issuer),
+			"group": certManagerGroup,
+		},
+	}
+}
@@ -48,23 +48,26 @@ func (builder *ConfigMapBuild) Build(name, tag string) (client.Object, error) {
 			Labels:      Labels(name, builder.Instance.Spec.Namespace),
 			Annotations: map[string]string{},
 		},
-		Data: builder.Instance.Spec.Configs,
+		Data: builder.configData(),
 	}
 	return &configMap, nil
 }
 
 func (builder *ConfigMapBuild) Update(object client.Object, name, tag string) (client.Object, error) {
 	configMap := object.(*corev1.ConfigMap)
-	if configMap.Data == nil {
-		configMap.Data = make(map[string]string)
-	}
-	configMap.Data = builder.Instance.Spec.Configs
+	configMap.Data = builder.configData()
 
 	return configMap, nil
 }
 
-// func (builder *ConfigMapBuild) configData() (map[string]string, error) {
-// 	var data map[string]string
-// 	data = builder.Instance.Spec.Configs
-// 	return data, nil
-// }
+// configData 复制spec.configs, 生成的对象不与DeployStack共用map, 避免写入对象时修改spec
+func (builder *ConfigMapBuild) configData() map[string]string {
+	if builder.Instance.Spec.Configs == nil {
+		return nil
+	}
+	data := make(map[string]string, len(builder.Instance.Spec.Configs))
+	for key, value := range builder.Instance.Spec.Configs {
+		data[key] = value
+	}
+	return data
+}
@@ -0,0 +1,66 @@
+package resource
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// podExtras 应用附加的initContainers、sidecars及存储卷
+type podExtras struct {
+	initContainers []corev1.Container
+	sidecars       []corev1.Container
+	volumes        []corev1.Volume
+	volumeMounts   []corev1.VolumeMount
+}
+
+// 先按sidecarRefs顺序展开引用的模版, 再追加apps[name]中直接定义的容器、存储卷及PVC
+func (builder *DeployStackBuild) podExtras(name string) (podExtras, error) {
+	var extras podExtras
+	apps, ok := builder.Instance.Spec.Apps[name]
+	if !ok {
+		return extras, nil
+	}
+	for _, ref := range apps.SidecarRefs {
+		template, ok := builder.Instance.Spec.SidecarTemplates[ref]
+		if !ok {
+			return extras, fmt.Errorf("app %s: sidecar template %q not found", name, ref)
+		}
+		template = *template.DeepCopy()
+		extras.initContainers = append(extras.initContainers, template.InitContainers...)
+		extras.sidecars = append(extras.sidecars, template.Containers...)
+		extras.volumes = append(extras.volumes, template.Volumes...)
+	}
+	apps = *apps.DeepCopy()
+	extras.initContainers = append(extras.initContainers, apps.InitContainers...)
+	extras.sidecars = append(extras.sidecars, apps.Sidecars...)
+	extras.volumes = append(extras.volumes, apps.Volumes...)
+	extras.volumeMounts = append(extras.volumeMounts, apps.VolumeMounts...)
+	storageVolumes, storageVolumeMounts := builder.storageVolumes(name)
+	extras.volumes = append(extras.volumes, storageVolumes...)
+	extras.volumeMounts = append(extras.volumeMounts, storageVolumeMounts...)
+
+	if err := checkDuplicates(name, extras); err != nil {
+		return extras, err
+	}
+	return extras, nil
+}
+
+// 同名容器或存储卷会被apiserver拒绝, 提前报错
+func checkDuplicates(name string, extras podExtras) error {
+	containers := map[string]bool{name: true}
+	for _, container := range append(extras.initContainers, extras.sidecars...) {
+		if containers[container.Name] {
+			return fmt.Errorf("app %s: duplicate container name %q", name, container.Name)
+		}
+		containers[container.Name] = true
+	}
+	volumes := map[string]bool{StringCombin(name, "-", "config"): true}
+	for _, volume := range extras.volumes {
+		if volumes[volume.Name] {
+			return fmt.Errorf("app %s: duplicate volume name %q", name, volume.Name)
+		}
+		volumes[volume.Name] = true
+	}
+	return nil
+}
@@ -30,6 +30,13 @@ func (builder *DeployStackBuild) Deployment() *DeploymentBuild {
 
 	return &DeploymentBuild{builder}
 }
+
+// Suspended 应用是否已暂停, 暂停时副本数为0, 保留其余配置
+func (builder *DeployStackBuild) Suspended(name string) bool {
+	apps, ok := builder.Instance.Spec.Apps[name]
+	return ok && apps.Suspended
+}
+
 func (builder *DeploymentBuild) GetObjectKind() (client.Object, error) {
 	return &appsv1.Deployment{}, nil
 }
@@ -45,7 +52,10 @@ func (builder *DeploymentBuild) Build(name, tag string) (client.Object, error) {
 	namespace = builder.Instance.Spec.Namespace
 	replicas = builder.Instance.Spec.Replicas
 
-	podTemplateSpec := builder.podTemplateSpec(name, tag)
+	podTemplateSpec, err := builder.podTemplateSpec(name, tag)
+	if err != nil {
+		return nil, err
+	}
 
 	appsName := builder.Instance.Spec.Apps
 	if apps, ok := appsName[name]; ok {
@@ -60,12 +70,15 @@ func (builder *DeploymentBuild) Build(name, tag string) (client.Object, error) {
 			replicas = builder.Instance.Spec.Replicas
 		}
 	}
+	if builder.Suspended(name) {
+		replicas = new(int32)
+	}
 
 	deployment := appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        name,
 			Namespace:   namespace,
-			Annotations: map[string]string{},
+			Annotations: map[string]string{TagAnnotation: tag},
 			Labels:      Labels(name, namespace),
 		},
 		Spec: appsv1.DeploymentSpec{
@@ -109,11 +122,22 @@ func (builder *DeploymentBuild) Update(object client.Object, name, tag string) (
 		deploy.Spec.Replicas = builder.Instance.Spec.Replicas
 		deploy.Namespace = builder.Instance.Spec.Namespace
 	}
+	if builder.Suspended(name) {
+		deploy.Spec.Replicas = new(int32)
+	}
 	//pod template
 	//标签字段不可变，不能更新
 	deploy.Labels = Labels(name, builder.Instance.Spec.Namespace)
+	if deploy.Annotations == nil {
+		deploy.Annotations = map[string]string{}
+	}
+	deploy.Annotations[TagAnnotation] = tag
 	// deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: LabelsSelector(name, builder.Instance.Spec.Namespace)}
-	deploy.Spec.Template = builder.podTemplateSpec(name, tag)
+	podTemplateSpec, err := builder.podTemplateSpec(name, tag)
+	if err != nil {
+		return nil, err
+	}
+	deploy.Spec.Template = podTemplateSpec
 	return deploy, nil
 }
 
@@ -124,10 +148,19 @@ func (builder *DeploymentBuild) ExecStrategy() bool {
 func (builder *DeploymentBuild) containerPorts(name string, containerPorts []ContainerPorts) []corev1.ContainerPort {
 	var ports []corev1.ContainerPort
 	for _, containerPort := range containerPorts {
-		ports = append(ports, corev1.ContainerPort{
+		port := corev1.ContainerPort{
 			Name:          StringCombin(containerPort.Name, "-", name),
 			ContainerPort: containerPort.Port,
-		})
+			Protocol:      containerPort.Protocol,
+		}
+		if port.Protocol == "" {
+			port.Protocol = corev1.ProtocolTCP
+		}
+		// targetPort为端口号时, 容器监听targetPort
+		if containerPort.TargetPort != nil && containerPort.TargetPort.Type == intstr.Int && containerPort.TargetPort.IntVal != 0 {
+			port.ContainerPort = containerPort.TargetPort.IntVal
+		}
+		ports = append(ports, port)
 	}
 
 	return ports
@@ -152,13 +185,11 @@ func (builder *DeploymentBuild) containerPort(name string) []corev1.ContainerPor
 	return ports
 }
 
-func (builder *DeploymentBuild) podTemplateSpec(name, tag string) corev1.PodTemplateSpec {
+func (builder *DeploymentBuild) podTemplateSpec(name, tag string) (corev1.PodTemplateSpec, error) {
 	var (
-		image           string
-		registrySecret  string
-		ports           []corev1.ContainerPort
-		resources       corev1.ResourceRequirements
-		imagePullPolicy corev1.PullPolicy
+		ports     []corev1.ContainerPort
+		resources corev1.ResourceRequirements
+		err       error
 	)
 	var (
 		configSuffix string = "config"
@@ -167,18 +198,6 @@ func (builder *DeploymentBuild) podTemplateSpec(name, tag string) corev1.PodTemp
 	namespace := builder.Instance.Spec.Namespace
 	env := envVarObject(namespace, name)
 	envFrom := envVarFrom()
-	requestMem, limitMem := stringsSplit(builder.Instance.Spec.ResourcesMemory)
-	requestCpu, limitCpu := stringsSplit(builder.Instance.Spec.ResourcesCpu)
-	defaultResources := corev1.ResourceRequirements{
-		Requests: corev1.ResourceList{
-			"memory": resource.MustParse(requestMem),
-			"cpu":    resource.MustParse(requestCpu),
-		},
-		Limits: corev1.ResourceList{
-			"memory": resource.MustParse(limitMem),
-			"cpu":    resource.MustParse(limitCpu),
-		},
-	}
 	affinity := corev1.Affinity{
 		PodAntiAffinity: &corev1.PodAntiAffinity{
 			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
@@ -241,63 +260,37 @@ func (builder *DeploymentBuild) podTemplateSpec(name, tag string) corev1.PodTemp
 	}
 
 	//image
-	if tag == defaultTag {
-		imagePullPolicy = "Always"
-	} else {
-		imagePullPolicy = defaultImagePullPolicy
-	}
-	//registry secret
-	if builder.Instance.Spec.RegistrySecrets != "" {
-		registrySecret = builder.Instance.Spec.RegistrySecrets
-	} else {
-		registrySecret = defaultImagePullSecrets
-	}
-	//container port
-	if builder.Instance.Spec.Ports != nil {
-		ports = builder.containerPorts(name, builder.Instance.Spec.Ports)
-	} else {
-		if builder.Instance.Spec.PortForGrpc != 0 {
-			ports = []corev1.ContainerPort{{
-				Name:          StringCombin("grpc", "-", name),
-				ContainerPort: builder.Instance.Spec.PortForGrpc,
-			}}
-		}
-	}
-	if builder.Instance.Spec.ImageRegistry != "" {
-		image = fmt.Sprintf("%s/%s_%s:%s", builder.Instance.Spec.ImageRegistry, builder.Instance.Namespace, name, tag)
-	} else {
-		image = fmt.Sprintf("%s/%s:%s", defaultImageRegistry, name, tag)
-	}
+	image, imagePullPolicy := builder.containerImage(name, tag)
 	if builder.Instance.Spec.Resources != nil {
 		resources = *builder.Instance.Spec.Resources
-	} else {
-		resources = defaultResources
+	} else if resources, err = builder.defaultResources(); err != nil {
+		return corev1.PodTemplateSpec{}, fmt.Errorf("app %s: %w", name, err)
 	}
+	ports = builder.containerPorts(name, builder.appPorts(name))
 
-	appsName := builder.Instance.Spec.Apps
-	if apps, ok := appsName[name]; ok {
-		if apps.RegistrySecrets != "" {
-			registrySecret = apps.RegistrySecrets
-		}
-		if apps.Ports != nil {
-			ports = append(ports, builder.containerPorts(name, apps.Ports)...)
-		}
-		if apps.ImageRegistry != "" {
-			image = fmt.Sprintf("%s/%s:%s", apps.ImageRegistry, name, tag)
-		}
-		// resources = defaultResources
-		//
+	extras, err := builder.podExtras(name)
+	if err != nil {
+		return corev1.PodTemplateSpec{}, err
+	}
+	volumes = append(volumes, extras.volumes...)
+	volumeMounts = append(volumeMounts, extras.volumeMounts...)
+
+	annotations, err := builder.scrapeAnnotations(name)
+	if err != nil {
+		return corev1.PodTemplateSpec{}, err
 	}
 
 	podTemplateSpec := corev1.PodTemplateSpec{
 		ObjectMeta: metav1.ObjectMeta{
-			Annotations: map[string]string{},
+			Annotations: annotations,
 			Labels:      LabelsSelector(name, namespace),
 		},
 		Spec: corev1.PodSpec{
-			NodeSelector: map[string]string{},
-			Affinity:     &affinity,
-			Containers: []corev1.Container{{
+			NodeSelector:              builder.nodeSelector(name),
+			Affinity:                  builder.affinity(name, &affinity),
+			Tolerations:               builder.tolerations(name),
+			TopologySpreadConstraints: builder.topologySpreadConstraints(name, namespace),
+			Containers: append([]corev1.Container{{
 				Name:            name,
 				Image:           image,
 				ImagePullPolicy: imagePullPolicy,
@@ -311,16 +304,32 @@ func (builder *DeploymentBuild) podTemplateSpec(name, tag string) corev1.PodTemp
 				LivenessProbe:  &livenessProbe,
 				ReadinessProbe: &readinessProbe,
 				Lifecycle:      &lifecycle,
-			}},
+			}}, extras.sidecars...),
+			InitContainers:                extras.initContainers,
 			TerminationGracePeriodSeconds: int64Ptr(30),
 			Volumes:                       volumes,
-			ImagePullSecrets: []corev1.LocalObjectReference{{
-				Name: registrySecret,
-			}},
+			ImagePullSecrets:              builder.ImagePullSecrets(name),
 		},
 	}
 
-	return podTemplateSpec
+	return podTemplateSpec, nil
+}
+
+// appPorts 应用的容器端口, 与apps[name].ports同名的端口以应用中的配置为准
+func (builder *DeployStackBuild) appPorts(name string) []ContainerPorts {
+	var containerPorts []ContainerPorts
+	if builder.Instance.Spec.Ports != nil {
+		containerPorts = builder.Instance.Spec.Ports
+	} else if builder.Instance.Spec.PortForGrpc != 0 {
+		containerPorts = []ContainerPorts{{
+			Name: "grpc",
+			Port: builder.Instance.Spec.PortForGrpc,
+		}}
+	}
+	if apps, ok := builder.Instance.Spec.Apps[name]; ok {
+		containerPorts = mergePorts(containerPorts, apps.Ports)
+	}
+	return containerPorts
 }
 
 // func (builder *DeploymentBuild) containerVolumeMounts(name string, obj []client.Object) []corev1.VolumeMount {
@@ -336,6 +345,68 @@ func (builder *DeploymentBuild) podTemplateSpec(name, tag string) corev1.PodTemp
 //		return volumeMounts
 //	}
 
+// containerImage 镜像地址及拉取策略, apps[name].imageRegistry 优先
+func (builder *DeployStackBuild) containerImage(name, tag string) (string, corev1.PullPolicy) {
+	var (
+		image           string
+		imagePullPolicy corev1.PullPolicy
+	)
+	if tag == "" {
+		tag = defaultTag
+	}
+	if tag == defaultTag {
+		imagePullPolicy = "Always"
+	} else {
+		imagePullPolicy = defaultImagePullPolicy
+	}
+	image = fmt.Sprintf("%s:%s", builder.ImageRepository(name), tag)
+	if digest, ok := builder.Digests[image]; ok {
+		return fmt.Sprintf("%s@%s", builder.ImageRepository(name), digest), defaultImagePullPolicy
+	}
+	return image, imagePullPolicy
+}
+
+// ImageTag 应用镜像地址(含tag)及tag, 用于解析digest
+func (builder *DeployStackBuild) ImageTag(name, tag string) (string, string) {
+	if tag == "" {
+		tag = defaultTag
+	}
+	return fmt.Sprintf("%s:%s", builder.ImageRepository(name), tag), tag
+}
+
+// PinDigest 应用是否按digest发布, apps[name].pinDigest 优先
+func (builder *DeployStackBuild) PinDigest(name string) bool {
+	if apps, ok := builder.Instance.Spec.Apps[name]; ok && apps.PinDigest != nil {
+		return *apps.PinDigest
+	}
+	return builder.Instance.Spec.PinDigest
+}
+
+// ImageRepository 应用的镜像地址, 不含tag
+func (builder *DeployStackBuild) ImageRepository(name string) string {
+	if apps, ok := builder.Instance.Spec.Apps[name]; ok && apps.ImageRegistry != "" {
+		return fmt.Sprintf("%s/%s", apps.ImageRegistry, name)
+	}
+	if builder.Instance.Spec.ImageRegistry != "" {
+		return fmt.Sprintf("%s/%s_%s", builder.Instance.Spec.ImageRegistry, builder.Instance.Namespace, name)
+	}
+	return fmt.Sprintf("%s/%s", defaultImageRegistry, name)
+}
+
+// ImagePullSecrets 镜像仓库凭证, apps[name].registrySecrets 优先
+func (builder *DeployStackBuild) ImagePullSecrets(name string) []corev1.LocalObjectReference {
+	registrySecret := defaultImagePullSecrets
+	if builder.Instance.Spec.RegistrySecrets != "" {
+		registrySecret = builder.Instance.Spec.RegistrySecrets
+	}
+	if apps, ok := builder.Instance.Spec.Apps[name]; ok && apps.RegistrySecrets != "" {
+		registrySecret = apps.RegistrySecrets
+	}
+	return []corev1.LocalObjectReference{{
+		Name: registrySecret,
+	}}
+}
+
 func envVarObject(namespace, name string) []corev1.EnvVar {
 	env := []corev1.EnvVar{
 		{Name: "CONFIG_ENV", Value: namespace},
@@ -360,6 +431,38 @@ func envVarFrom() []corev1.EnvFromSource {
 	return envFrom
 }
 
+// defaultResources 未设置spec.resources时按resourcesMemory、resourcesCpu(格式为 <request>-<limit>)生成, 均未设置时不限制资源
+func (builder *DeployStackBuild) defaultResources() (corev1.ResourceRequirements, error) {
+	var resources corev1.ResourceRequirements
+	for _, quantity := range []struct {
+		field string
+		name  corev1.ResourceName
+		value string
+	}{
+		{"resourcesMemory", corev1.ResourceMemory, builder.Instance.Spec.ResourcesMemory},
+		{"resourcesCpu", corev1.ResourceCPU, builder.Instance.Spec.ResourcesCpu},
+	} {
+		if strings.TrimSpace(quantity.value) == "" {
+			continue
+		}
+		request, limit := stringsSplit(quantity.value)
+		requestQuantity, err := resource.ParseQuantity(request)
+		if err != nil {
+			return resources, fmt.Errorf("invalid %s %q: %w", quantity.field, quantity.value, err)
+		}
+		limitQuantity, err := resource.ParseQuantity(limit)
+		if err != nil {
+			return resources, fmt.Errorf("invalid %s %q: %w", quantity.field, quantity.value, err)
+		}
+		if resources.Requests == nil {
+			resources.Requests, resources.Limits = corev1.ResourceList{}, corev1.ResourceList{}
+		}
+		resources.Requests[quantity.name] = requestQuantity
+		resources.Limits[quantity.name] = limitQuantity
+	}
+	return resources, nil
+}
+
 // 字符串切割
 func stringsSplit(name string) (request string, limit string) {
 	trimmed := strings.TrimSpace(name)
@@ -370,3 +473,12 @@ func stringsSplit(name string) (request string, limit string) {
 	}
 	return request, limit
 }
+
+// TemplateHash 应用Pod模版的hash, 用于记录发布版本
+func (builder *DeploymentBuild) TemplateHash(name, tag string) (string, error) {
+	deploy, err := builder.Build(name, tag)
+	if err != nil {
+		return "", err
+	}
+	return specHash(deploy.(*appsv1.Deployment).Spec.Template)
+}
@@ -0,0 +1,103 @@
+package resource
+
+import (
+	"fmt"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ComponentPreDeployHook  = "pre-deploy-hook"
+	ComponentPostDeployHook = "post-deploy-hook"
+	// TagAnnotation 记录Deployment当前发布的tag
+	TagAnnotation = "gopron.online/tag"
+
+	defaultHookActiveDeadlineSeconds int64 = 600
+)
+
+type HookBuild struct {
+	*DeployStackBuild
+}
+
+func (builder *DeployStackBuild) Hook() *HookBuild {
+
+	return &HookBuild{builder}
+}
+
+// Build 返回应用发布钩子的Job, 未配置该钩子时返回nil
+// Job名称带上配置的hash, 同一个tag只执行一次
+func (builder *HookBuild) Build(name, tag, component string) (*batchv1.Job, error) {
+	var hook *apiv1.JobSpec
+	apps, ok := builder.Instance.Spec.Apps[name]
+	if !ok || apps.Hooks == nil {
+		return nil, nil
+	}
+	switch component {
+	case ComponentPreDeployHook:
+		hook = apps.Hooks.PreDeploy
+	case ComponentPostDeployHook:
+		hook = apps.Hooks.PostDeploy
+	default:
+		return nil, fmt.Errorf("unknown hook %s", component)
+	}
+	if hook == nil {
+		return nil, nil
+	}
+	jobSpec := *hook.DeepCopy()
+	if jobSpec.Image == "" {
+		jobSpec.Image = name
+	}
+	if jobSpec.Tag == "" {
+		jobSpec.Tag = tag
+	}
+	if jobSpec.Tag == "" {
+		jobSpec.Tag = defaultTag
+	}
+	activeDeadlineSeconds := jobSpec.ActiveDeadlineSeconds
+	if activeDeadlineSeconds == nil {
+		activeDeadlineSeconds = int64Ptr(defaultHookActiveDeadlineSeconds)
+	}
+	namespace := builder.Instance.Spec.Namespace
+	spec := batchv1.JobSpec{
+		BackoffLimit:          jobSpec.BackoffLimit,
+		ActiveDeadlineSeconds: activeDeadlineSeconds,
+		Template:              builder.jobPodTemplateSpec(name, jobSpec, component),
+	}
+	hash, err := specHash(spec)
+	if err != nil {
+		return nil, err
+	}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        StringCombin(StringCombin(name, "-", component), "-", hash),
+			Namespace:   namespace,
+			Labels:      jobLabels(name, namespace, component),
+			Annotations: map[string]string{TagAnnotation: jobSpec.Tag},
+		},
+		Spec: spec,
+	}
+	if err := builder.setOwner(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// TagDeployed Deployment是否已发布该tag, 没有tag注解时比较镜像地址
+func (builder *DeployStackBuild) TagDeployed(deploy *appsv1.Deployment, name, tag string) bool {
+	if tag == "" {
+		tag = defaultTag
+	}
+	if deployedTag, ok := deploy.Annotations[TagAnnotation]; ok {
+		return deployedTag == tag
+	}
+	image, _ := builder.containerImage(name, tag)
+	for _, container := range deploy.Spec.Template.Spec.Containers {
+		if container.Name == name {
+			return container.Image == image
+		}
+	}
+	return false
+}
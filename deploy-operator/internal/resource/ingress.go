@@ -2,9 +2,11 @@ package resource
 
 import (
 	"fmt"
+	"reflect"
 	"strconv"
 	"strings"
 
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
 	v1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -15,8 +17,7 @@ const (
 )
 
 var (
-	defaultPathType  = v1.PathTypeImplementationSpecific
-	ingressClassName = "nginx"
+	defaultPathType = v1.PathTypeImplementationSpecific
 )
 
 type IngressBuild struct {
@@ -36,167 +37,262 @@ func (builder *IngressBuild) GetObjectKind() (client.Object, error) {
 	return &v1.Ingress{}, nil
 
 }
-func (builder *IngressBuild) Build(name, tag string) (client.Object, error) {
 
-	var (
-		rules       []v1.IngressRule  = builder.ingressRules(name)
-		tls         []v1.IngressTLS   = builder.tlsStrategy(name)
-		annotations map[string]string = builder.getAnnotations(name)
-	)
+// Names 需要创建Ingress的名称, gateway模式下由HTTPRoute、GRPCRoute代替
+func (builder *IngressBuild) Names() []string {
+	var names []string
+	if builder.GatewayMode() {
+		return names
+	}
+	for _, name := range builder.IngressNames() {
+		// 配置错误时保留, 由Build返回错误
+		if rules, err := builder.ingressRules(name); err != nil || len(rules) > 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
 
-	ingress := v1.Ingress{
+func (builder *IngressBuild) Build(name, tag string) (client.Object, error) {
+	ingress := &v1.Ingress{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Ingress",
 			APIVersion: "networking.k8s.io/v1",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:        StringCombin(name, "-", "ingress"),
-			Namespace:   builder.Instance.Spec.Namespace,
-			Annotations: annotations,
-		},
-		Spec: v1.IngressSpec{
-			IngressClassName: &ingressClassName,
-			TLS:              tls,
-			Rules:            rules,
+			Name:      IngressName(name),
+			Namespace: builder.Instance.Spec.Namespace,
 		},
 	}
-	return &ingress, nil
+	return builder.Update(ingress, name, tag)
 }
 
-func (builder *IngressBuild) stringsSplit(name string) (string, int32) {
-	var (
-		svcName string
-		port32  int32
-	)
-	trimmed := strings.TrimSpace(name)
-	str := strings.Fields(trimmed)
-	if len(str) >= 2 {
-		svcName = str[0]
-		port, err := strconv.Atoi(str[1])
+// IngressName ingress[].name对应的Ingress名称
+func IngressName(name string) string {
+	return StringCombin(name, "-", "ingress")
+}
+
+// ingressPath 解析后端后的ingress路径
+type ingressPath struct {
+	path     string
+	pathType v1.PathType
+	backend  v1.IngressServiceBackend
+}
+
+// ingressPaths 先按配置顺序生成paths中的路径, 再按Match、Prefix、Exact的顺序生成"service port"写法的路径, 同类路径按字典序排列
+func (builder *DeployStackBuild) ingressPaths(ingress apiv1.IngressSpec) ([]ingressPath, error) {
+	var paths []ingressPath
+	for _, path := range ingress.Paths {
+		pathType := path.PathType
+		if pathType == "" {
+			pathType = v1.PathTypePrefix
+		}
+		backend, err := builder.serviceBackend(path.Backend)
 		if err != nil {
-			fmt.Println("Invalid port")
+			return nil, fmt.Errorf("ingress %s: path %s%s: %w", ingress.Name, ingress.Host, path.Path, err)
 		}
-		port32 = int32(port)
-	} else if len(str) == 1 {
-		svcName = str[0]
-		port32 = builder.Instance.Spec.PortForHttp
+		paths = append(paths, ingressPath{path: path.Path, pathType: pathType, backend: backend})
+	}
+	pathTypes := []struct {
+		paths    map[string]string
+		pathType v1.PathType
+	}{
+		{ingress.Match, v1.PathTypeImplementationSpecific},
+		{ingress.Prefix, v1.PathTypePrefix},
+		{ingress.Exact, v1.PathTypeExact},
 	}
+	for _, pathType := range pathTypes {
+		for _, path := range SortedKeys(pathType.paths) {
+			backend, err := legacyBackend(pathType.paths[path])
+			if err != nil {
+				return nil, fmt.Errorf("ingress %s: path %s%s: %w", ingress.Name, ingress.Host, path, err)
+			}
+			serviceBackend, err := builder.serviceBackend(backend)
+			if err != nil {
+				return nil, fmt.Errorf("ingress %s: path %s%s: %w", ingress.Name, ingress.Host, path, err)
+			}
+			paths = append(paths, ingressPath{path: path, pathType: pathType.pathType, backend: serviceBackend})
+		}
+	}
+	return paths, nil
+}
 
-	return svcName, port32
+// legacyBackend 解析"service port"写法的后端, 未配置端口时使用portForHttp
+func legacyBackend(value string) (apiv1.IngressBackend, error) {
+	var backend apiv1.IngressBackend
+	str := strings.Fields(value)
+	switch len(str) {
+	case 1:
+		backend.Service = str[0]
+	case 2:
+		port, err := strconv.ParseInt(str[1], 10, 32)
+		if err != nil || port <= 0 {
+			return backend, fmt.Errorf("invalid port %q", str[1])
+		}
+		backend.Service = str[0]
+		backend.Port.Number = int32(port)
+	default:
+		return backend, fmt.Errorf("invalid backend %q, expected \"service [port]\"", value)
+	}
+	return backend, nil
+}
+
+// serviceBackend 将后端解析为Service名称及端口
+// app后端对应该应用生成的Service, 端口名称与Service中的端口一样以应用名称为后缀
+func (builder *DeployStackBuild) serviceBackend(backend apiv1.IngressBackend) (v1.IngressServiceBackend, error) {
+	var serviceBackend v1.IngressServiceBackend
+	switch {
+	case backend.Service != "" && backend.App != "":
+		return serviceBackend, fmt.Errorf("backend service and app are mutually exclusive")
+	case backend.Service == "" && backend.App == "":
+		return serviceBackend, fmt.Errorf("backend service or app is required")
+	case backend.Port.Number != 0 && backend.Port.Name != "":
+		return serviceBackend, fmt.Errorf("backend port number and name are mutually exclusive")
+	}
+	serviceBackend.Name = backend.Service
+	serviceBackend.Port = v1.ServiceBackendPort{
+		Number: backend.Port.Number,
+		Name:   backend.Port.Name,
+	}
+	if backend.App != "" {
+		if _, ok := builder.Instance.Spec.AppsList[backend.App]; !ok {
+			return serviceBackend, fmt.Errorf("backend app %q is not in appsList", backend.App)
+		}
+		serviceBackend.Name = backend.App
+		if backend.Port.Name != "" {
+			serviceBackend.Port.Name = StringCombin(backend.Port.Name, "-", backend.App)
+		}
+	}
+	// 未指定端口时使用spec.portForHttp, 也未设置时拒绝生成端口为0的后端
+	if serviceBackend.Port.Number == 0 && serviceBackend.Port.Name == "" {
+		if builder.Instance.Spec.PortForHttp == 0 {
+			return serviceBackend, fmt.Errorf("backend port is required")
+		}
+		serviceBackend.Port.Number = builder.Instance.Spec.PortForHttp
+	}
+	return serviceBackend, nil
 }
 func (builder *IngressBuild) tlsStrategy(name string) []v1.IngressTLS {
-	var (
-		hosts []string
-		tls   []v1.IngressTLS
-	)
-	if builder.Instance.Spec.Ingress != nil {
-		for _, ingress := range builder.Instance.Spec.Ingress {
-			if ingress.Name == name && ingress.Https {
-				hosts = append(hosts, ingress.Host)
-			}
+	tls := []v1.IngressTLS{}
+	for _, secret := range builder.TLSSecrets(name) {
+		tls = append(tls, v1.IngressTLS{
+			Hosts:      secret.Hosts,
+			SecretName: secret.SecretName,
+		})
+	}
+	return tls
+}
+
+// ingressRules 汇总同名ingress[]的规则, 同一域名的路径合并为一条规则, 域名按配置中的顺序
+func (builder *IngressBuild) ingressRules(name string) ([]v1.IngressRule, error) {
+	var rules []v1.IngressRule
+	index := map[string]int{}
+	for _, ingress := range builder.Instance.Spec.Ingress {
+		if name != ingress.Name {
+			continue
 		}
-		if hosts != nil {
-			tls = append(tls, v1.IngressTLS{
-				Hosts:      hosts,
-				SecretName: defaultSSL,
+		paths, err := builder.ingressPaths(ingress)
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) == 0 {
+			continue
+		}
+		i, ok := index[ingress.Host]
+		if !ok {
+			index[ingress.Host] = len(rules)
+			rules = append(rules, v1.IngressRule{
+				Host: ingress.Host,
+				IngressRuleValue: v1.IngressRuleValue{
+					HTTP: &v1.HTTPIngressRuleValue{},
+				},
 			})
-		} else {
-			tls = []v1.IngressTLS{}
+			i = len(rules) - 1
+		}
+		for _, path := range paths {
+			rules[i].HTTP.Paths = append(rules[i].HTTP.Paths, builder.httpIngressPath(path))
 		}
-
 	}
-
-	return tls
+	return rules, nil
 }
 
-func (builder *IngressBuild) ingressRules(name string) []v1.IngressRule {
-	var (
-		rules []v1.IngressRule
-	)
-	if builder.Instance.Spec.Ingress != nil {
-		for _, ingress := range builder.Instance.Spec.Ingress {
-			if name == ingress.Name {
-				var paths []v1.HTTPIngressPath
-				if ingress.Match != nil {
-					pathType := v1.PathTypeImplementationSpecific
-					for path, service := range ingress.Match {
-						svcName, svcPort := builder.stringsSplit(service)
-						paths = append(paths, builder.httpIngressPath(path, pathType, svcName, svcPort))
-					}
-
-				}
-				if ingress.Prefix != nil {
-					pathType := v1.PathTypePrefix
-					for path, service := range ingress.Prefix {
-						svcName, svcPort := builder.stringsSplit(service)
-						paths = append(paths, builder.httpIngressPath(path, pathType, svcName, svcPort))
-					}
-
-				}
-				if ingress.Exact != nil {
-					pathType := v1.PathTypeExact
-					for path, service := range ingress.Exact {
-						svcName, svcPort := builder.stringsSplit(service)
-						paths = append(paths, builder.httpIngressPath(path, pathType, svcName, svcPort))
-					}
-
-				}
-
-				if ingress.Match == nil && ingress.Prefix == nil && ingress.Exact == nil {
-					return rules
-				}
-				rules = append(rules, v1.IngressRule{
-					Host: ingress.Host,
-					IngressRuleValue: v1.IngressRuleValue{
-						HTTP: &v1.HTTPIngressRuleValue{
-							Paths: paths,
-						},
-					},
-				})
+// checkRules 同一域名下相同路径指向不同后端时无法合并
+func checkRules(name string, rules []v1.IngressRule) error {
+	for _, rule := range rules {
+		backends := map[string]v1.IngressBackend{}
+		for _, path := range rule.HTTP.Paths {
+			key := StringCombin(string(*path.PathType), " ", path.Path)
+			if backend, ok := backends[key]; ok && !reflect.DeepEqual(backend, path.Backend) {
+				return fmt.Errorf("ingress %s: conflicting backends for %s%s", name, rule.Host, path.Path)
 			}
-
+			backends[key] = path.Backend
 		}
-	} else {
-		rules = []v1.IngressRule{}
 	}
-	return rules
+	return nil
 }
 
-func (builder *IngressBuild) httpIngressPath(path string, pathType v1.PathType, svcName string, svcPort int32) v1.HTTPIngressPath {
+func (builder *IngressBuild) httpIngressPath(path ingressPath) v1.HTTPIngressPath {
+	pathType := path.pathType
+	backend := path.backend
 	return v1.HTTPIngressPath{
-		Path:     path,
+		Path:     path.path,
 		PathType: &pathType,
 		Backend: v1.IngressBackend{
-			Service: &v1.IngressServiceBackend{
-				Name: svcName,
-				Port: v1.ServiceBackendPort{
-					Number: svcPort,
-				},
-			},
+			Service: &backend,
 		},
 	}
 }
-func (builder *IngressBuild) getAnnotations(name string) map[string]string {
-	var annotations map[string]string
-	if builder.Instance.Spec.Ingress != nil {
-		for _, ingress := range builder.Instance.Spec.Ingress {
-			if name == ingress.Name && ingress.Annotations != nil {
-				annotations = ingress.Annotations
-				break
-			} else {
-				annotations = map[string]string{}
+
+// getAnnotations 合并同名ingress[]中的注解, 同一注解配置了不同的值时报错
+func (builder *IngressBuild) getAnnotations(name string) (map[string]string, error) {
+	annotations := map[string]string{}
+	for _, ingress := range builder.Instance.Spec.Ingress {
+		if name != ingress.Name {
+			continue
+		}
+		for key, value := range ingress.Annotations {
+			if current, ok := annotations[key]; ok && current != value {
+				return nil, fmt.Errorf("ingress %s: conflicting values for annotation %q", name, key)
 			}
+			annotations[key] = value
 		}
 	}
-	return annotations
+	// 控制器默认注解 < ingress[]中的注解 < cert-manager注解
+	merged := builder.ingressPresetAnnotations(name)
+	for key, value := range annotations {
+		merged[key] = value
+	}
+	issuerAnnotations, err := builder.issuerAnnotations(name)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range issuerAnnotations {
+		merged[key] = value
+	}
+	return merged, nil
 }
+
 func (builder *IngressBuild) Update(object client.Object, name, tag string) (client.Object, error) {
 	ingress := object.(*v1.Ingress)
-	if builder.ingressRules(name) != nil {
-		ingress.Spec.Rules = builder.ingressRules(name)
-		ingress.Spec.TLS = builder.tlsStrategy(name)
-		ingress.ObjectMeta.Annotations = builder.getAnnotations(name)
-
+	rules, err := builder.ingressRules(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRules(name, rules); err != nil {
+		return nil, err
+	}
+	annotations, err := builder.getAnnotations(name)
+	if err != nil {
+		return nil, err
+	}
+	ingressClassName, _, err := builder.ingressClassName(name)
+	if err != nil {
+		return nil, err
 	}
+	ingress.ObjectMeta.Labels = Labels(name, builder.Instance.Spec.Namespace)
+	ingress.ObjectMeta.Annotations = annotations
+	ingress.Spec.IngressClassName = &ingressClassName
+	ingress.Spec.TLS = builder.tlsStrategy(name)
+	ingress.Spec.Rules = rules
 	return ingress, nil
 }
@@ -0,0 +1,127 @@
+package resource
+
+import (
+	"fmt"
+	"strconv"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+)
+
+const (
+	defaultIngressClassName = "nginx"
+)
+
+// ingressControllers IngressClass的spec.controller对应的控制器类型
+var ingressControllers = map[string]apiv1.IngressController{
+	"k8s.io/ingress-nginx":          apiv1.IngressControllerNginx,
+	"traefik.io/ingress-controller": apiv1.IngressControllerTraefik,
+	"ingress.k8s.aws/alb":           apiv1.IngressControllerALB,
+}
+
+// ingressClass 返回ingress使用的ingressClass及控制器类型
+// 优先使用ingress[]中的配置, 其次spec中的配置; ingress[]中的ingressClass与spec中相同时与未配置一致
+func (builder *DeployStackBuild) ingressClass(ingress apiv1.IngressSpec) (string, apiv1.IngressController) {
+	stackClassName := builder.Instance.Spec.IngressClassName
+	if stackClassName == "" {
+		stackClassName = defaultIngressClassName
+	}
+	className := ingress.IngressClassName
+	if className == "" {
+		className = stackClassName
+	}
+	controller := ingress.Controller
+	if controller == "" && className == stackClassName {
+		controller = builder.Instance.Spec.IngressController
+	}
+	if controller == "" {
+		controller = builder.ingressControllerFromClass(className)
+	}
+	return className, controller
+}
+
+// ingressControllerFromClass 根据集群中IngressClass的spec.controller确定控制器类型
+// IngressClass不存在(如离线渲染)时ingressClass名称与控制器类型相同才匹配, 默认为nginx
+func (builder *DeployStackBuild) ingressControllerFromClass(className string) apiv1.IngressController {
+	if controllerName, ok := builder.IngressClassControllers[className]; ok {
+		if controller, ok := ingressControllers[controllerName]; ok {
+			return controller
+		}
+		return apiv1.IngressControllerNginx
+	}
+	switch controller := apiv1.IngressController(className); controller {
+	case apiv1.IngressControllerTraefik, apiv1.IngressControllerALB:
+		return controller
+	default:
+		return apiv1.IngressControllerNginx
+	}
+}
+
+// ingressClassName 同名ingress规则的ingressClass, 规则间配置不一致时报错
+func (builder *IngressBuild) ingressClassName(name string) (string, apiv1.IngressController, error) {
+	var (
+		className  string
+		controller apiv1.IngressController
+		found      bool
+	)
+	for _, ingress := range builder.Instance.Spec.Ingress {
+		if ingress.Name != name {
+			continue
+		}
+		ingressClassName, ingressController := builder.ingressClass(ingress)
+		if found && (ingressClassName != className || ingressController != controller) {
+			return "", "", fmt.Errorf("ingress %s: conflicting ingressClassName %q and %q", name, className, ingressClassName)
+		}
+		className, controller, found = ingressClassName, ingressController, true
+	}
+	if !found {
+		className, controller = builder.ingressClass(apiv1.IngressSpec{})
+	}
+	return className, controller, nil
+}
+
+// ingressPresetAnnotations 同名规则中任意一条开启https或内网访问时生效
+func (builder *IngressBuild) ingressPresetAnnotations(name string) map[string]string {
+	var https, internal bool
+	for _, ingress := range builder.Instance.Spec.Ingress {
+		if ingress.Name == name {
+			https = https || ingress.Https || ingress.TLS != nil
+			internal = internal || ingress.Internal
+		}
+	}
+	_, controller, _ := builder.ingressClassName(name)
+	return presetAnnotations(controller, https, internal)
+}
+
+// presetAnnotations 控制器相关的默认注解, ingress[]中配置的注解优先
+func presetAnnotations(controller apiv1.IngressController, https, internal bool) map[string]string {
+	switch controller {
+	case apiv1.IngressControllerTraefik:
+		if https {
+			return map[string]string{
+				"traefik.ingress.kubernetes.io/router.entrypoints": "websecure",
+				"traefik.ingress.kubernetes.io/router.tls":         "true",
+			}
+		}
+		return map[string]string{
+			"traefik.ingress.kubernetes.io/router.entrypoints": "web",
+		}
+	case apiv1.IngressControllerALB:
+		annotations := map[string]string{
+			"alb.ingress.kubernetes.io/scheme":       "internet-facing",
+			"alb.ingress.kubernetes.io/target-type":  "ip",
+			"alb.ingress.kubernetes.io/listen-ports": `[{"HTTP":80}]`,
+		}
+		if internal {
+			annotations["alb.ingress.kubernetes.io/scheme"] = "internal"
+		}
+		if https {
+			annotations["alb.ingress.kubernetes.io/listen-ports"] = `[{"HTTP":80},{"HTTPS":443}]`
+			annotations["alb.ingress.kubernetes.io/ssl-redirect"] = "443"
+		}
+		return annotations
+	default:
+		return map[string]string{
+			"nginx.ingress.kubernetes.io/ssl-redirect": strconv.FormatBool(https),
+		}
+	}
+}
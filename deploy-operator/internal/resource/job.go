@@ -0,0 +1,231 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	ComponentLabel   = "app.kubernetes.io/component"
+	ComponentJob     = "job"
+	ComponentCronJob = "cronjob"
+)
+
+type JobBuild struct {
+	*DeployStackBuild
+}
+
+func (builder *DeployStackBuild) Job() *JobBuild {
+
+	return &JobBuild{builder}
+}
+
+func (builder *JobBuild) ExecStrategy() bool {
+	return true
+}
+
+func (builder *JobBuild) GetObjectKind() (client.Object, error) {
+	return &batchv1.Job{}, nil
+}
+
+func (builder *JobBuild) Tasks() map[string]string {
+	tasks := map[string]string{}
+	for name, job := range builder.Instance.Spec.Jobs {
+		tasks[name] = job.Tag
+	}
+	return tasks
+}
+
+// Build Job的pod模版不可变, 名称带上配置的hash, 配置变化时创建新的Job重新执行
+func (builder *JobBuild) Build(name, tag string) (client.Object, error) {
+	jobSpec, ok := builder.Instance.Spec.Jobs[name]
+	if !ok {
+		return nil, fmt.Errorf("job %s not found", name)
+	}
+	jobSpec.Tag = tag
+	namespace := builder.Instance.Spec.Namespace
+	spec := batchv1.JobSpec{
+		BackoffLimit:          jobSpec.BackoffLimit,
+		ActiveDeadlineSeconds: jobSpec.ActiveDeadlineSeconds,
+		Template:              builder.jobPodTemplateSpec(name, jobSpec, ComponentJob),
+	}
+	hash, err := specHash(spec)
+	if err != nil {
+		return nil, err
+	}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        StringCombin(name, "-", hash),
+			Namespace:   namespace,
+			Labels:      jobLabels(name, namespace, ComponentJob),
+			Annotations: map[string]string{},
+		},
+		Spec: spec,
+	}
+	if err := builder.setOwner(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Update Job创建后不可修改, 只更新标签
+func (builder *JobBuild) Update(object client.Object, name, tag string) (client.Object, error) {
+	job := object.(*batchv1.Job)
+	for key, value := range jobLabels(name, builder.Instance.Spec.Namespace, ComponentJob) {
+		if job.Labels == nil {
+			job.Labels = map[string]string{}
+		}
+		job.Labels[key] = value
+	}
+	return job, nil
+}
+
+type CronJobBuild struct {
+	*DeployStackBuild
+}
+
+func (builder *DeployStackBuild) CronJob() *CronJobBuild {
+
+	return &CronJobBuild{builder}
+}
+
+func (builder *CronJobBuild) ExecStrategy() bool {
+	return true
+}
+
+func (builder *CronJobBuild) GetObjectKind() (client.Object, error) {
+	return &batchv1.CronJob{}, nil
+}
+
+func (builder *CronJobBuild) Tasks() map[string]string {
+	tasks := map[string]string{}
+	for name, cronJob := range builder.Instance.Spec.CronJobs {
+		tasks[name] = cronJob.Tag
+	}
+	return tasks
+}
+
+func (builder *CronJobBuild) Build(name, tag string) (client.Object, error) {
+	namespace := builder.Instance.Spec.Namespace
+	spec, err := builder.cronJobSpec(name, tag)
+	if err != nil {
+		return nil, err
+	}
+	cronJob := &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      jobLabels(name, namespace, ComponentCronJob),
+			Annotations: map[string]string{},
+		},
+		Spec: spec,
+	}
+	if err := builder.setOwner(cronJob); err != nil {
+		return nil, err
+	}
+	return cronJob, nil
+}
+
+func (builder *CronJobBuild) Update(object client.Object, name, tag string) (client.Object, error) {
+	cronJob := object.(*batchv1.CronJob)
+	spec, err := builder.cronJobSpec(name, tag)
+	if err != nil {
+		return nil, err
+	}
+	cronJob.Labels = jobLabels(name, builder.Instance.Spec.Namespace, ComponentCronJob)
+	cronJob.Spec = spec
+	return cronJob, nil
+}
+
+func (builder *CronJobBuild) cronJobSpec(name, tag string) (batchv1.CronJobSpec, error) {
+	cronJobSpec, ok := builder.Instance.Spec.CronJobs[name]
+	if !ok {
+		return batchv1.CronJobSpec{}, fmt.Errorf("cronjob %s not found", name)
+	}
+	if cronJobSpec.Schedule == "" {
+		return batchv1.CronJobSpec{}, fmt.Errorf("cronjob %s: schedule is required", name)
+	}
+	cronJobSpec.Tag = tag
+	concurrencyPolicy := cronJobSpec.ConcurrencyPolicy
+	if concurrencyPolicy == "" {
+		concurrencyPolicy = batchv1.ForbidConcurrent
+	}
+	return batchv1.CronJobSpec{
+		Schedule:                   cronJobSpec.Schedule,
+		TimeZone:                   cronJobSpec.TimeZone,
+		ConcurrencyPolicy:          concurrencyPolicy,
+		Suspend:                    cronJobSpec.Suspend,
+		SuccessfulJobsHistoryLimit: cronJobSpec.SuccessfulJobsHistoryLimit,
+		FailedJobsHistoryLimit:     cronJobSpec.FailedJobsHistoryLimit,
+		JobTemplate: batchv1.JobTemplateSpec{
+			Spec: batchv1.JobSpec{
+				BackoffLimit:          cronJobSpec.BackoffLimit,
+				ActiveDeadlineSeconds: cronJobSpec.ActiveDeadlineSeconds,
+				Template:              builder.jobPodTemplateSpec(name, cronJobSpec.JobSpec, ComponentCronJob),
+			},
+		},
+	}, nil
+}
+
+// jobPodTemplateSpec 与应用使用相同的镜像地址、envFrom及镜像仓库凭证, 不包含探针和sidecar
+func (builder *DeployStackBuild) jobPodTemplateSpec(name string, job apiv1.JobSpec, component string) corev1.PodTemplateSpec {
+	var resources corev1.ResourceRequirements
+	imageName := job.Image
+	if imageName == "" {
+		imageName = name
+	}
+	namespace := builder.Instance.Spec.Namespace
+	image, imagePullPolicy := builder.containerImage(imageName, job.Tag)
+	if job.Resources != nil {
+		resources = *job.Resources
+	} else if builder.Instance.Spec.Resources != nil {
+		resources = *builder.Instance.Spec.Resources
+	}
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{},
+			Labels:      jobLabels(name, namespace, component),
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			NodeSelector:  builder.nodeSelector(imageName),
+			Affinity:      builder.affinity(imageName, nil),
+			Tolerations:   builder.tolerations(imageName),
+			Containers: []corev1.Container{{
+				Name:            name,
+				Image:           image,
+				ImagePullPolicy: imagePullPolicy,
+				Command:         job.Command,
+				Args:            job.Args,
+				Env:             append(envVarObject(namespace, name), job.Env...),
+				EnvFrom:         envVarFrom(),
+				Resources:       resources,
+			}},
+			ImagePullSecrets: builder.ImagePullSecrets(imageName),
+		},
+	}
+}
+
+func jobLabels(name, namespace, component string) labels {
+	jobLabels := Labels(name, namespace)
+	jobLabels[ComponentLabel] = component
+	return jobLabels
+}
+
+// specHash 计算配置的短hash, 用于资源命名
+func specHash(spec interface{}) (string, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	hash := fnv.New32a()
+	hash.Write(data)
+	return fmt.Sprintf("%08x", hash.Sum32()), nil
+}
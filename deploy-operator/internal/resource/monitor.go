@@ -0,0 +1,239 @@
+package resource
+
+import (
+	"fmt"
+	"strconv"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	monitoringGroup    = "monitoring.coreos.com"
+	defaultMetricsPath = "/metrics"
+	// 未安装prometheus-operator时按注解采集
+	scrapeAnnoKey = "prometheus.io/scrape"
+	portAnnoKey   = "prometheus.io/port"
+	pathAnnoKey   = "prometheus.io/path"
+)
+
+// prometheus-operator 资源, 未引入prometheus-operator依赖, 使用unstructured
+var (
+	ServiceMonitorGVK = schema.GroupVersionKind{Group: monitoringGroup, Version: "v1", Kind: "ServiceMonitor"}
+	PodMonitorGVK     = schema.GroupVersionKind{Group: monitoringGroup, Version: "v1", Kind: "PodMonitor"}
+)
+
+// metrics 应用的指标配置, 未配置时返回nil
+func (builder *DeployStackBuild) metrics(name string) *apiv1.AppMetricsSpec {
+	metrics := builder.Instance.Spec.Apps[name].Metrics
+	if metrics == nil || metrics.Port == "" {
+		return nil
+	}
+	return metrics
+}
+
+func monitorKind(metrics *apiv1.AppMetricsSpec) apiv1.MonitorKind {
+	if metrics.Kind == "" {
+		return apiv1.MonitorKindServiceMonitor
+	}
+	return metrics.Kind
+}
+
+func metricsPath(metrics *apiv1.AppMetricsSpec) string {
+	if metrics.Path == "" {
+		return defaultMetricsPath
+	}
+	return metrics.Path
+}
+
+// metricsContainerPort 指标端口对应的容器端口号
+func (builder *DeployStackBuild) metricsContainerPort(name string, metrics *apiv1.AppMetricsSpec) (int32, error) {
+	portName := StringCombin(metrics.Port, "-", name)
+	for _, port := range builder.Deployment().containerPorts(name, builder.appPorts(name)) {
+		if port.Name == portName {
+			return port.ContainerPort, nil
+		}
+	}
+	return 0, fmt.Errorf("app %s: metrics port %q not found in ports", name, metrics.Port)
+}
+
+// scrapeAnnotations 未安装prometheus-operator时在Pod上添加的采集注解, 没有时返回nil, 与apiserver返回的对象一致
+func (builder *DeployStackBuild) scrapeAnnotations(name string) (map[string]string, error) {
+	metrics := builder.metrics(name)
+	if metrics == nil || builder.PrometheusOperator {
+		return nil, nil
+	}
+	port, err := builder.metricsContainerPort(name, metrics)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		scrapeAnnoKey: "true",
+		portAnnoKey:   strconv.Itoa(int(port)),
+		pathAnnoKey:   metricsPath(metrics),
+	}, nil
+}
+
+type ServiceMonitorBuild struct {
+	*DeployStackBuild
+}
+
+func (builder *DeployStackBuild) ServiceMonitor() *ServiceMonitorBuild {
+
+	return &ServiceMonitorBuild{builder}
+}
+
+func (builder *ServiceMonitorBuild) ExecStrategy() bool {
+	return false
+}
+
+func (builder *ServiceMonitorBuild) GetObjectKind() (client.Object, error) {
+	monitor := &unstructured.Unstructured{}
+	monitor.SetGroupVersionKind(ServiceMonitorGVK)
+	return monitor, nil
+}
+
+// Build 返回应用的ServiceMonitor, 未配置时为nil
+func (builder *ServiceMonitorBuild) Build(name, tag string) (client.Object, error) {
+	monitors, err := builder.BuildList(name, tag)
+	if err != nil || len(monitors) == 0 {
+		return nil, err
+	}
+	return monitors[0], nil
+}
+
+// BuildList 安装了prometheus-operator CRD且apps[name].metrics.kind为ServiceMonitor时, 生成采集应用Service的ServiceMonitor
+func (builder *ServiceMonitorBuild) BuildList(name, tag string) ([]client.Object, error) {
+	var monitors []client.Object
+	metrics := builder.metrics(name)
+	if !builder.PrometheusOperator || metrics == nil || monitorKind(metrics) != apiv1.MonitorKindServiceMonitor {
+		return monitors, nil
+	}
+	specs, err := builder.Service().serviceSpecs(name)
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, port := range specs[0].Ports {
+		found = found || port.Name == metrics.Port
+	}
+	if !found {
+		return nil, fmt.Errorf("app %s: metrics port %q not found in service ports", name, metrics.Port)
+	}
+	namespace := builder.Instance.Spec.Namespace
+	selector := map[string]interface{}{}
+	for key, value := range Labels(name, namespace) {
+		selector[key] = value
+	}
+	// 额外的Service与主Service端口相同, 只采集主Service, 避免重复采集
+	selector[ServiceRoleLabel] = ServiceRoleMain
+	monitor, err := builder.monitor(ServiceMonitorGVK, name, map[string]interface{}{
+		"selector":  map[string]interface{}{"matchLabels": selector},
+		"endpoints": []interface{}{metricsEndpoint(name, metrics)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(monitors, monitor), nil
+}
+
+func (builder *ServiceMonitorBuild) Update(object client.Object, name, tag string) (client.Object, error) {
+	return builder.updateMonitor(object, name, tag, builder)
+}
+
+type PodMonitorBuild struct {
+	*DeployStackBuild
+}
+
+func (builder *DeployStackBuild) PodMonitor() *PodMonitorBuild {
+
+	return &PodMonitorBuild{builder}
+}
+
+func (builder *PodMonitorBuild) ExecStrategy() bool {
+	return false
+}
+
+func (builder *PodMonitorBuild) GetObjectKind() (client.Object, error) {
+	monitor := &unstructured.Unstructured{}
+	monitor.SetGroupVersionKind(PodMonitorGVK)
+	return monitor, nil
+}
+
+// Build 返回应用的PodMonitor, 未配置时为nil
+func (builder *PodMonitorBuild) Build(name, tag string) (client.Object, error) {
+	monitors, err := builder.BuildList(name, tag)
+	if err != nil || len(monitors) == 0 {
+		return nil, err
+	}
+	return monitors[0], nil
+}
+
+// BuildList 安装了prometheus-operator CRD且apps[name].metrics.kind为PodMonitor时, 生成采集应用Pod的PodMonitor
+func (builder *PodMonitorBuild) BuildList(name, tag string) ([]client.Object, error) {
+	var monitors []client.Object
+	metrics := builder.metrics(name)
+	if !builder.PrometheusOperator || metrics == nil || monitorKind(metrics) != apiv1.MonitorKindPodMonitor {
+		return monitors, nil
+	}
+	if _, err := builder.metricsContainerPort(name, metrics); err != nil {
+		return nil, err
+	}
+	selector := map[string]interface{}{}
+	for key, value := range LabelsSelector(name, builder.Instance.Spec.Namespace) {
+		selector[key] = value
+	}
+	monitor, err := builder.monitor(PodMonitorGVK, name, map[string]interface{}{
+		"selector":            map[string]interface{}{"matchLabels": selector},
+		"podMetricsEndpoints": []interface{}{metricsEndpoint(name, metrics)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(monitors, monitor), nil
+}
+
+func (builder *PodMonitorBuild) Update(object client.Object, name, tag string) (client.Object, error) {
+	return builder.updateMonitor(object, name, tag, builder)
+}
+
+// metricsEndpoint 端口名称与Service、容器端口一致, 为 <port>-<app>
+func metricsEndpoint(name string, metrics *apiv1.AppMetricsSpec) map[string]interface{} {
+	endpoint := map[string]interface{}{
+		"port": StringCombin(metrics.Port, "-", name),
+		"path": metricsPath(metrics),
+	}
+	if metrics.Interval != nil && metrics.Interval.Duration > 0 {
+		endpoint["interval"] = metrics.Interval.Duration.String()
+	}
+	return endpoint
+}
+
+// monitor 生成与应用同名的ServiceMonitor或PodMonitor
+func (builder *DeployStackBuild) monitor(gvk schema.GroupVersionKind, name string, spec map[string]interface{}) (*unstructured.Unstructured, error) {
+	monitor := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	monitor.SetGroupVersionKind(gvk)
+	monitor.SetName(name)
+	monitor.SetNamespace(builder.Instance.Spec.Namespace)
+	monitor.SetLabels(Labels(name, builder.Instance.Spec.Namespace))
+	if err := builder.setOwner(monitor); err != nil {
+		return nil, err
+	}
+	return monitor, nil
+}
+
+// updateMonitor 用新生成的ServiceMonitor或PodMonitor替换spec及标签
+func (builder *DeployStackBuild) updateMonitor(object client.Object, name, tag string, listBuilder ResourceListBuilder) (client.Object, error) {
+	monitor := object.(*unstructured.Unstructured)
+	monitors, err := listBuilder.BuildList(name, tag)
+	if err != nil {
+		return nil, err
+	}
+	for _, desired := range monitors {
+		monitor.Object["spec"] = desired.(*unstructured.Unstructured).Object["spec"]
+		monitor.SetLabels(desired.GetLabels())
+	}
+	return monitor, nil
+}
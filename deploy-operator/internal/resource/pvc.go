@@ -0,0 +1,184 @@
+package resource
+
+import (
+	"fmt"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// RetainAnnotation 标记应用移除后需要保留的PVC
+	RetainAnnotation = "gopron.online/retain-on-delete"
+	// StackNameLabel、StackNamespaceLabel 标记PVC所属的DeployStack, 多个DeployStack部署到同一namespace时按此区分
+	StackNameLabel      = "gopron.online/deploystack"
+	StackNamespaceLabel = "gopron.online/deploystack-namespace"
+)
+
+// StackLabels 所属DeployStack的标签, 删除PVC时按此筛选
+func StackLabels(deployStack *apiv1.DeployStack) map[string]string {
+	return map[string]string{
+		StackNameLabel:      deployStack.Name,
+		StackNamespaceLabel: deployStack.Namespace,
+	}
+}
+
+// persistentVolumeClaimLabels 应用标签及所属DeployStack的标签
+func (builder *PersistentVolumeClaimBuild) persistentVolumeClaimLabels(name string) labels {
+	pvcLabels := Labels(name, builder.Instance.Spec.Namespace)
+	for key, value := range StackLabels(builder.Instance) {
+		pvcLabels[key] = value
+	}
+	return pvcLabels
+}
+
+type PersistentVolumeClaimBuild struct {
+	*DeployStackBuild
+}
+
+func (builder *DeployStackBuild) PersistentVolumeClaim() *PersistentVolumeClaimBuild {
+
+	return &PersistentVolumeClaimBuild{builder}
+}
+
+func (builder *PersistentVolumeClaimBuild) ExecStrategy() bool {
+	return true
+}
+
+func (builder *PersistentVolumeClaimBuild) GetObjectKind() (client.Object, error) {
+	return &corev1.PersistentVolumeClaim{}, nil
+}
+
+// Build 返回应用的第一个PVC, 完整列表见 BuildList
+func (builder *PersistentVolumeClaimBuild) Build(name, tag string) (client.Object, error) {
+	pvcs, err := builder.BuildList(name, tag)
+	if err != nil {
+		return nil, err
+	}
+	if len(pvcs) == 0 {
+		return nil, fmt.Errorf("app %s has no storage", name)
+	}
+	return pvcs[0], nil
+}
+
+func (builder *PersistentVolumeClaimBuild) BuildList(name, tag string) ([]client.Object, error) {
+	var pvcs []client.Object
+	apps, ok := builder.Instance.Spec.Apps[name]
+	if !ok {
+		return pvcs, nil
+	}
+	for _, storage := range apps.Storage {
+		pvc, err := builder.persistentVolumeClaim(name, storage)
+		if err != nil {
+			return nil, err
+		}
+		pvcs = append(pvcs, pvc)
+	}
+	return pvcs, nil
+}
+
+func (builder *PersistentVolumeClaimBuild) persistentVolumeClaim(name string, storage apiv1.StorageSpec) (*corev1.PersistentVolumeClaim, error) {
+	if storage.Name == "" || storage.MountPath == "" {
+		return nil, fmt.Errorf("app %s: storage name and mountPath are required", name)
+	}
+	if storage.Size.IsZero() {
+		return nil, fmt.Errorf("app %s: storage %s size is required", name, storage.Name)
+	}
+	namespace := builder.Instance.Spec.Namespace
+	accessModes := storage.AccessModes
+	if len(accessModes) == 0 {
+		accessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	}
+	annotations := map[string]string{}
+	if storage.RetainOnDelete {
+		annotations[RetainAnnotation] = "true"
+	}
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        PersistentVolumeClaimName(name, storage.Name),
+			Namespace:   namespace,
+			Labels:      builder.persistentVolumeClaimLabels(name),
+			Annotations: annotations,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      accessModes,
+			StorageClassName: storage.StorageClass,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: storage.Size,
+				},
+			},
+		},
+	}
+	// 保留的PVC不设置OwnerReference, 避免随DeployStack一起被回收
+	if !storage.RetainOnDelete {
+		if err := builder.setOwner(pvc); err != nil {
+			return nil, err
+		}
+	}
+	return pvc, nil
+}
+
+// Update PVC 创建后只允许扩容, 其余字段不可变
+func (builder *PersistentVolumeClaimBuild) Update(object client.Object, name, tag string) (client.Object, error) {
+	pvc := object.(*corev1.PersistentVolumeClaim)
+	apps := builder.Instance.Spec.Apps[name]
+	for _, storage := range apps.Storage {
+		if PersistentVolumeClaimName(name, storage.Name) != pvc.Name {
+			continue
+		}
+		pvc.Labels = builder.persistentVolumeClaimLabels(name)
+		if pvc.Annotations == nil {
+			pvc.Annotations = map[string]string{}
+		}
+		if storage.RetainOnDelete {
+			pvc.Annotations[RetainAnnotation] = "true"
+			pvc.OwnerReferences = nil
+		} else {
+			delete(pvc.Annotations, RetainAnnotation)
+			if err := builder.setOwner(pvc); err != nil {
+				return nil, err
+			}
+		}
+		current := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		if storage.Size.Cmp(current) > 0 {
+			if pvc.Spec.Resources.Requests == nil {
+				pvc.Spec.Resources.Requests = corev1.ResourceList{}
+			}
+			pvc.Spec.Resources.Requests[corev1.ResourceStorage] = storage.Size
+		}
+		return pvc, nil
+	}
+	return nil, fmt.Errorf("app %s: no storage for pvc %s", name, pvc.Name)
+}
+
+func PersistentVolumeClaimName(name, storageName string) string {
+	return StringCombin(name, "-", storageName)
+}
+
+// 挂载应用的PVC
+func (builder *DeployStackBuild) storageVolumes(name string) ([]corev1.Volume, []corev1.VolumeMount) {
+	var (
+		volumes      []corev1.Volume
+		volumeMounts []corev1.VolumeMount
+	)
+	apps, ok := builder.Instance.Spec.Apps[name]
+	if !ok {
+		return volumes, volumeMounts
+	}
+	for _, storage := range apps.Storage {
+		volumeName := StringCombin(storage.Name, "-", "data")
+		volumes = append(volumes, corev1.Volume{
+			Name: volumeName,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: PersistentVolumeClaimName(name, storage.Name),
+				},
+			},
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{Name: volumeName, MountPath: storage.MountPath})
+	}
+	return volumes, volumeMounts
+}
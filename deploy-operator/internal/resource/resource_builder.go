@@ -2,10 +2,12 @@ package resource
 
 import (
 	"fmt"
+	"sort"
 
 	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 const (
@@ -16,7 +18,27 @@ const (
 type DeployStackBuild struct {
 	Instance *apiv1.DeployStack
 	Scheme   *runtime.Scheme
+	// 集群中已安装cert-manager的Certificate CRD
+	CertManager bool
+	// 集群中已安装prometheus-operator的ServiceMonitor、PodMonitor CRD
+	PrometheusOperator bool
+	// 镜像地址(含tag)解析得到的digest, 存在时按digest发布
+	Digests map[string]string
+	// 集群中IngressClass名称及其spec.controller, 用于确定ingress控制器类型
+	IngressClassControllers map[string]string
 }
+
+// SharedResource 所有应用共用的global-config、global-secret, 不属于某个应用, 不随应用删除
+func SharedResource(name string) bool {
+	return name == defaultConfigMapName || name == defaultSecretName
+}
+
+// AppResourceOrphaned 与应用同名的Deployment、ConfigMap、Secret在应用从appsList中移除后才删除, 共用资源不删除
+func AppResourceOrphaned(deployStack *apiv1.DeployStack, name string) bool {
+	_, ok := deployStack.Spec.AppsList[name]
+	return !ok && !SharedResource(name)
+}
+
 type ContainerPorts = apiv1.DefaultPorts
 type ServicePorts = apiv1.DefaultPorts
 
@@ -26,6 +48,27 @@ type ResourceBuilder interface {
 	ExecStrategy() bool
 	GetObjectKind() (client.Object, error)
 }
+
+// ResourceListBuilder 每个应用对应多个同类资源的builder, 如多个PVC
+// Update 根据 object 的名称找到对应的配置
+type ResourceListBuilder interface {
+	ResourceBuilder
+	BuildList(name, tag string) ([]client.Object, error)
+}
+
+// BatchBuilder 批处理任务的builder, 任务与appsList无关
+// Tasks 返回任务名称及镜像tag, 对应appsList中的应用名称及tag
+type BatchBuilder interface {
+	ResourceBuilder
+	Tasks() map[string]string
+}
+
+// IngressBuilder 按ingress[].name生成资源的builder, 名称与appsList中的应用无关
+// Names 返回需要生成资源的ingress名称
+type IngressBuilder interface {
+	ResourceBuilder
+	Names() []string
+}
 type labels map[string]string
 
 // DeployStackBuild 上的方法ResourceBuilds，返回接口ResourceBuilder 类型
@@ -35,12 +78,57 @@ func (builder *DeployStackBuild) ResourceBuilds() []ResourceBuilder {
 		builder.Service(),
 		builder.ConfigMap(),
 		builder.Secret(),
+		builder.PersistentVolumeClaim(),
+		builder.ServiceMonitor(),
+		builder.PodMonitor(),
+	}
+	return builders
+}
+
+// IngressBuilds 返回由spec.ingress生成资源的builder
+func (builder *DeployStackBuild) IngressBuilds() []IngressBuilder {
+	builders := []IngressBuilder{
 		builder.Ingress(),
+		builder.Certificate(),
+		builder.HTTPRoute(),
+		builder.GRPCRoute(),
+	}
+	return builders
+}
+
+// IngressNames spec.ingress中不重复的名称, 按字典序排列
+func (builder *DeployStackBuild) IngressNames() []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, ingress := range builder.Instance.Spec.Ingress {
+		if seen[ingress.Name] {
+			continue
+		}
+		seen[ingress.Name] = true
+		names = append(names, ingress.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BatchBuilds 返回spec.jobs、spec.cronJobs对应的builder
+func (builder *DeployStackBuild) BatchBuilds() []BatchBuilder {
+	builders := []BatchBuilder{
+		builder.Job(),
+		builder.CronJob(),
 	}
 	return builders
 }
 func int64Ptr(i int64) *int64 { return &i }
 
+// setOwner 资源与DeployStack在同一namespace时设置OwnerReference, 跨namespace的OwnerReference无效
+func (builder *DeployStackBuild) setOwner(object client.Object) error {
+	if object.GetNamespace() != builder.Instance.Namespace {
+		return nil
+	}
+	return controllerutil.SetControllerReference(builder.Instance, object, builder.Scheme)
+}
+
 func int32Ptr(i int32) *int32 { return &i }
 
 func Labels(name, env string) labels {
@@ -59,6 +147,16 @@ func LabelsSelector(name, env string) labels {
 	}
 }
 
+// SortedKeys 按字典序返回map的key, 保证生成的资源及调谐顺序稳定
+func SortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // string combination字符串组合
 func StringCombin(prefix, modifier, suffix string) string {
 	return fmt.Sprintf("%s%s%s", prefix, modifier, suffix)
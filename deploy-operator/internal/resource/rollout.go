@@ -0,0 +1,141 @@
+package resource
+
+import "sort"
+
+// DependsOn 应用在apps[name].dependsOn中配置的依赖, 去重并按名称排序
+func (builder *DeployStackBuild) DependsOn(name string) []string {
+	var dependencies []string
+	seen := map[string]bool{}
+	for _, dependency := range builder.Instance.Spec.Apps[name].DependsOn {
+		if dependency == "" || seen[dependency] {
+			continue
+		}
+		seen[dependency] = true
+		dependencies = append(dependencies, dependency)
+	}
+	sort.Strings(dependencies)
+	return dependencies
+}
+
+// RolloutOrder 按依赖关系排列appsList中的应用, 依赖在前, 没有先后关系的应用按名称排序
+// 依赖成环的应用及依赖它们的应用排在最后, cycle 为其中第一个应用所在或依赖的环, 如 [a b a]
+func (builder *DeployStackBuild) RolloutOrder() (order []string, cycle []string) {
+	appList := builder.Instance.Spec.AppsList
+	names := SortedKeys(appList)
+	pending := map[string]int{}
+	dependents := map[string][]string{}
+	for _, name := range names {
+		for _, dependency := range builder.DependsOn(name) {
+			// 不在appsList中的依赖无法排序, 发布时按未就绪处理
+			if _, ok := appList[dependency]; !ok {
+				continue
+			}
+			pending[name]++
+			dependents[dependency] = append(dependents[dependency], name)
+		}
+	}
+	var ready []string
+	for _, name := range names {
+		if pending[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+		for _, dependent := range dependents[name] {
+			if pending[dependent]--; pending[dependent] == 0 {
+				ready = append(ready, dependent)
+				sort.Strings(ready)
+			}
+		}
+	}
+	if len(order) == len(names) {
+		return order, nil
+	}
+	// 剩余的应用在环中或依赖环中的应用, 除环内无法排序的依赖外仍按依赖在前排列
+	var blocked []string
+	placed := map[string]bool{}
+	var place func(name string)
+	place = func(name string) {
+		if placed[name] {
+			return
+		}
+		placed[name] = true
+		for _, dependency := range builder.DependsOn(name) {
+			if pending[dependency] > 0 {
+				place(dependency)
+			}
+		}
+		blocked = append(blocked, name)
+	}
+	for _, name := range names {
+		if pending[name] > 0 {
+			place(name)
+		}
+	}
+	for _, name := range names {
+		if pending[name] > 0 {
+			return append(order, blocked...), builder.DependencyCycle(name)
+		}
+	}
+	return append(order, blocked...), nil
+}
+
+// DependencyCycle 沿依赖(含间接依赖)查找应用所在或依赖的环, 如 [a b a], 没有时返回nil; 不在appsList中的依赖忽略
+func (builder *DeployStackBuild) DependencyCycle(name string) []string {
+	appList := builder.Instance.Spec.AppsList
+	var path []string
+	onPath := map[string]int{}
+	done := map[string]bool{}
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		if i, ok := onPath[name]; ok {
+			return append(append([]string{}, path[i:]...), name)
+		}
+		if done[name] {
+			return nil
+		}
+		onPath[name] = len(path)
+		path = append(path, name)
+		for _, dependency := range builder.DependsOn(name) {
+			if _, ok := appList[dependency]; !ok {
+				continue
+			}
+			if cycle := visit(dependency); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		delete(onPath, name)
+		done[name] = true
+		return nil
+	}
+	return visit(name)
+}
+
+// RolloutWave 应用所在波次的序号, 未在spec.rolloutPolicy.waves中列出的应用在最后一波
+func (builder *DeployStackBuild) RolloutWave(name string) int {
+	policy := builder.Instance.Spec.RolloutPolicy
+	if policy == nil {
+		return 0
+	}
+	for i, wave := range policy.Waves {
+		for _, app := range wave.Apps {
+			if app == name {
+				return i
+			}
+		}
+	}
+	return len(policy.Waves)
+}
+
+// RolloutWaveName 波次的名称, 最后一波未列出的应用名称为空
+func (builder *DeployStackBuild) RolloutWaveName(wave int) string {
+	policy := builder.Instance.Spec.RolloutPolicy
+	if policy == nil || wave >= len(policy.Waves) {
+		return ""
+	}
+	return policy.Waves[wave].Name
+}
@@ -0,0 +1,322 @@
+package resource
+
+import (
+	"fmt"
+	"strings"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	gatewayGroup = "gateway.networking.k8s.io"
+)
+
+// Gateway API 资源, 未引入gateway-api依赖, 使用unstructured
+var (
+	HTTPRouteGVK = schema.GroupVersionKind{Group: gatewayGroup, Version: "v1", Kind: "HTTPRoute"}
+	GRPCRouteGVK = schema.GroupVersionKind{Group: gatewayGroup, Version: "v1", Kind: "GRPCRoute"}
+)
+
+// GatewayMode 是否使用Gateway API代替Ingress
+func (builder *DeployStackBuild) GatewayMode() bool {
+	routing := builder.Instance.Spec.Routing
+	return routing != nil && routing.Mode == apiv1.RoutingModeGateway
+}
+
+type HTTPRouteBuild struct {
+	*DeployStackBuild
+}
+
+func (builder *DeployStackBuild) HTTPRoute() *HTTPRouteBuild {
+
+	return &HTTPRouteBuild{builder}
+}
+
+func (builder *HTTPRouteBuild) ExecStrategy() bool {
+	return false
+}
+
+func (builder *HTTPRouteBuild) GetObjectKind() (client.Object, error) {
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(HTTPRouteGVK)
+	return route, nil
+}
+
+// Names gateway模式下需要生成路由的ingress名称
+func (builder *HTTPRouteBuild) Names() []string {
+	var names []string
+	if !builder.GatewayMode() {
+		return names
+	}
+	return builder.IngressNames()
+}
+
+// Build 返回ingress的第一个HTTPRoute, 完整列表见 BuildList
+func (builder *HTTPRouteBuild) Build(name, tag string) (client.Object, error) {
+	routes, err := builder.BuildList(name, tag)
+	if err != nil || len(routes) == 0 {
+		return nil, err
+	}
+	return routes[0], nil
+}
+
+// BuildList gateway模式下每个域名生成一个HTTPRoute
+func (builder *HTTPRouteBuild) BuildList(name, tag string) ([]client.Object, error) {
+	if !builder.GatewayMode() {
+		return nil, nil
+	}
+	return builder.hostRoutes(HTTPRouteGVK, name, builder.httpRouteRules)
+}
+
+func (builder *HTTPRouteBuild) Update(object client.Object, name, tag string) (client.Object, error) {
+	return builder.updateRoute(object, name, tag, builder)
+}
+
+func (builder *HTTPRouteBuild) httpRouteRules(ingress apiv1.IngressSpec) ([]interface{}, error) {
+	var rules []interface{}
+	paths, err := builder.ingressPaths(ingress)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		backends := ingress.WeightedBackends[path.path]
+		if backends == nil {
+			// HTTPRoute的backendRef只支持端口号
+			if path.backend.Port.Name != "" {
+				return nil, fmt.Errorf("ingress %s: path %s%s: named port %q is not supported in gateway mode", ingress.Name, ingress.Host, path.path, path.backend.Port.Name)
+			}
+			backends = []apiv1.WeightedBackend{{Service: path.backend.Name, Port: path.backend.Port.Number}}
+		}
+		backendRefs, err := builder.backendRefs(backends, builder.Instance.Spec.PortForHttp)
+		if err != nil {
+			return nil, fmt.Errorf("ingress %s: path %s%s: %w", ingress.Name, ingress.Host, path.path, err)
+		}
+		matchType := "PathPrefix"
+		if path.pathType == networkingv1.PathTypeExact {
+			matchType = "Exact"
+		}
+		rules = append(rules, map[string]interface{}{
+			"matches": []interface{}{
+				map[string]interface{}{
+					"path": map[string]interface{}{
+						"type":  matchType,
+						"value": gatewayPath(path.path),
+					},
+				},
+			},
+			"backendRefs": backendRefs,
+		})
+	}
+	return rules, nil
+}
+
+type GRPCRouteBuild struct {
+	*DeployStackBuild
+}
+
+func (builder *DeployStackBuild) GRPCRoute() *GRPCRouteBuild {
+
+	return &GRPCRouteBuild{builder}
+}
+
+func (builder *GRPCRouteBuild) ExecStrategy() bool {
+	return false
+}
+
+func (builder *GRPCRouteBuild) GetObjectKind() (client.Object, error) {
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(GRPCRouteGVK)
+	return route, nil
+}
+
+// Names gateway模式下需要生成路由的ingress名称
+func (builder *GRPCRouteBuild) Names() []string {
+	var names []string
+	if !builder.GatewayMode() {
+		return names
+	}
+	return builder.IngressNames()
+}
+
+// Build 返回ingress的第一个GRPCRoute, 完整列表见 BuildList
+func (builder *GRPCRouteBuild) Build(name, tag string) (client.Object, error) {
+	routes, err := builder.BuildList(name, tag)
+	if err != nil || len(routes) == 0 {
+		return nil, err
+	}
+	return routes[0], nil
+}
+
+// BuildList gateway模式下为配置了grpc的域名生成GRPCRoute, 每个域名一个
+func (builder *GRPCRouteBuild) BuildList(name, tag string) ([]client.Object, error) {
+	if !builder.GatewayMode() {
+		return nil, nil
+	}
+	return builder.hostRoutes(GRPCRouteGVK, name, builder.grpcRouteRules)
+}
+
+func (builder *GRPCRouteBuild) Update(object client.Object, name, tag string) (client.Object, error) {
+	return builder.updateRoute(object, name, tag, builder)
+}
+
+func (builder *GRPCRouteBuild) grpcRouteRules(ingress apiv1.IngressSpec) ([]interface{}, error) {
+	var rules []interface{}
+	portForGrpc := builder.Instance.Spec.PortForGrpc
+	if portForGrpc == 0 {
+		portForGrpc = portForGrpcDefault
+	}
+	for i, grpc := range ingress.Grpc {
+		if len(grpc.Backends) == 0 {
+			return nil, fmt.Errorf("ingress %s: grpc[%d] on %s: backends are required", ingress.Name, i, ingress.Host)
+		}
+		backendRefs, err := builder.backendRefs(grpc.Backends, portForGrpc)
+		if err != nil {
+			return nil, fmt.Errorf("ingress %s: grpc[%d] on %s: %w", ingress.Name, i, ingress.Host, err)
+		}
+		rule := map[string]interface{}{
+			"backendRefs": backendRefs,
+		}
+		if grpc.Service != "" || grpc.Method != "" {
+			method := map[string]interface{}{}
+			if grpc.Service != "" {
+				method["service"] = grpc.Service
+			}
+			if grpc.Method != "" {
+				method["method"] = grpc.Method
+			}
+			rule["matches"] = []interface{}{
+				map[string]interface{}{"method": method},
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// hostRoutes 汇总同名ingress[]的路由规则, 同一域名的规则合并为一个路由, 域名按配置中的顺序
+func (builder *DeployStackBuild) hostRoutes(gvk schema.GroupVersionKind, name string, ingressRules func(apiv1.IngressSpec) ([]interface{}, error)) ([]client.Object, error) {
+	var hosts []string
+	hostRules := map[string][]interface{}{}
+	for _, ingress := range builder.Instance.Spec.Ingress {
+		if ingress.Name != name {
+			continue
+		}
+		rules, err := ingressRules(ingress)
+		if err != nil {
+			return nil, err
+		}
+		if len(rules) == 0 {
+			continue
+		}
+		if _, ok := hostRules[ingress.Host]; !ok {
+			hosts = append(hosts, ingress.Host)
+		}
+		hostRules[ingress.Host] = append(hostRules[ingress.Host], rules...)
+	}
+	var routes []client.Object
+	for _, host := range hosts {
+		route, err := builder.route(gvk, name, host, hostRules[host])
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// route 生成挂载到spec.routing.gateway的路由, 名称为 <app>-<host>
+func (builder *DeployStackBuild) route(gvk schema.GroupVersionKind, name, host string, rules []interface{}) (*unstructured.Unstructured, error) {
+	routing := builder.Instance.Spec.Routing
+	if routing == nil || routing.Gateway == nil || routing.Gateway.Name == "" {
+		return nil, fmt.Errorf("routing.gateway is required in gateway mode")
+	}
+	parentRef := map[string]interface{}{
+		"name": routing.Gateway.Name,
+	}
+	if routing.Gateway.Namespace != "" {
+		parentRef["namespace"] = routing.Gateway.Namespace
+	}
+	if routing.Gateway.SectionName != "" {
+		parentRef["sectionName"] = routing.Gateway.SectionName
+	}
+	spec := map[string]interface{}{
+		"parentRefs": []interface{}{parentRef},
+		"rules":      rules,
+	}
+	if host != "" {
+		spec["hostnames"] = []interface{}{host}
+	}
+	route := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	route.SetGroupVersionKind(gvk)
+	route.SetName(routeName(name, host))
+	route.SetNamespace(builder.Instance.Spec.Namespace)
+	route.SetLabels(Labels(name, builder.Instance.Spec.Namespace))
+	if err := builder.setOwner(route); err != nil {
+		return nil, err
+	}
+	return route, nil
+}
+
+// updateRoute 用新生成的同名路由替换spec及标签
+func (builder *DeployStackBuild) updateRoute(object client.Object, name, tag string, listBuilder ResourceListBuilder) (client.Object, error) {
+	route := object.(*unstructured.Unstructured)
+	routes, err := listBuilder.BuildList(name, tag)
+	if err != nil {
+		return nil, err
+	}
+	for _, desired := range routes {
+		if desired.GetName() != route.GetName() {
+			continue
+		}
+		route.Object["spec"] = desired.(*unstructured.Unstructured).Object["spec"]
+		route.SetLabels(desired.GetLabels())
+		return route, nil
+	}
+	return route, nil
+}
+
+// backendRefs 未指定端口的后端使用defaultPort, 也未设置时拒绝生成端口为0的后端
+func (builder *DeployStackBuild) backendRefs(backends []apiv1.WeightedBackend, defaultPort int32) ([]interface{}, error) {
+	var backendRefs []interface{}
+	for _, backend := range backends {
+		port := backend.Port
+		if port == 0 {
+			port = defaultPort
+		}
+		if port == 0 {
+			return nil, fmt.Errorf("backend port is required")
+		}
+		backendRef := map[string]interface{}{
+			"name": backend.Service,
+			"port": int64(port),
+		}
+		if backend.Weight != nil {
+			backendRef["weight"] = int64(*backend.Weight)
+		}
+		backendRefs = append(backendRefs, backendRef)
+	}
+	return backendRefs, nil
+}
+
+func routeName(name, host string) string {
+	if host == "" {
+		return name
+	}
+	return StringCombin(name, "-", strings.ReplaceAll(strings.TrimPrefix(host, "*."), ".", "-"))
+}
+
+// gatewayPath ingress中 /hello/* 形式的路径转换为前缀匹配 /hello
+func gatewayPath(path string) string {
+	path = strings.TrimSuffix(path, "*")
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
+	}
+	if path == "" {
+		path = "/"
+	}
+	return path
+}
@@ -0,0 +1,100 @@
+package resource
+
+import (
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	topologyKeyZone     = "topology.kubernetes.io/zone"
+	topologyKeyHostname = "kubernetes.io/hostname"
+)
+
+// 调度配置: tolerations、nodeSelector、affinity、topologySpreadConstraints
+// 优先使用apps[name]中的配置, 未配置时使用spec中的全局配置
+
+func (builder *DeployStackBuild) tolerations(name string) []corev1.Toleration {
+	var tolerations []corev1.Toleration
+	if builder.Instance.Spec.Toleration != nil {
+		tolerations = append(tolerations, *builder.Instance.Spec.Toleration)
+	}
+	tolerations = append(tolerations, builder.Instance.Spec.Tolerations...)
+	if apps, ok := builder.Instance.Spec.Apps[name]; ok {
+		tolerations = append(tolerations, apps.Tolerations...)
+	}
+	return tolerations
+}
+
+func (builder *DeployStackBuild) nodeSelector(name string) map[string]string {
+	nodeSelector := map[string]string{}
+	for key, value := range builder.Instance.Spec.NodeSelector {
+		nodeSelector[key] = value
+	}
+	if apps, ok := builder.Instance.Spec.Apps[name]; ok {
+		for key, value := range apps.NodeSelector {
+			nodeSelector[key] = value
+		}
+	}
+	if len(nodeSelector) == 0 {
+		return nil
+	}
+	return nodeSelector
+}
+
+// affinity 未配置时使用各workload默认的亲和性
+func (builder *DeployStackBuild) affinity(name string, defaultAffinity *corev1.Affinity) *corev1.Affinity {
+	if apps, ok := builder.Instance.Spec.Apps[name]; ok && apps.Affinity != nil {
+		return apps.Affinity.DeepCopy()
+	}
+	if builder.Instance.Spec.Affinity != nil {
+		return builder.Instance.Spec.Affinity.DeepCopy()
+	}
+	return defaultAffinity
+}
+
+func (builder *DeployStackBuild) topologySpreadConstraints(name, namespace string) []corev1.TopologySpreadConstraint {
+	var (
+		constraints = builder.Instance.Spec.TopologySpreadConstraints
+		preset      = builder.Instance.Spec.TopologySpreadPreset
+	)
+	if apps, ok := builder.Instance.Spec.Apps[name]; ok {
+		if apps.TopologySpreadConstraints != nil {
+			constraints = apps.TopologySpreadConstraints
+		}
+		if apps.TopologySpreadPreset != "" {
+			preset = apps.TopologySpreadPreset
+		}
+	}
+	var result []corev1.TopologySpreadConstraint
+	topologyKeys := map[string]bool{}
+	for _, constraint := range constraints {
+		constraint := *constraint.DeepCopy()
+		// 未指定labelSelector时默认选择本应用的pod
+		if constraint.LabelSelector == nil {
+			constraint.LabelSelector = &metav1.LabelSelector{MatchLabels: LabelsSelector(name, namespace)}
+		}
+		result = append(result, constraint)
+		topologyKeys[constraint.TopologyKey] = true
+	}
+	// 已配置相同topologyKey的约束时以配置为准, 不再添加预置约束
+	if preset != "" {
+		if constraint := topologySpreadPreset(preset, name, namespace); !topologyKeys[constraint.TopologyKey] {
+			result = append(result, constraint)
+		}
+	}
+	return result
+}
+
+func topologySpreadPreset(preset apiv1.TopologySpreadPreset, name, namespace string) corev1.TopologySpreadConstraint {
+	topologyKey := topologyKeyZone
+	if preset == apiv1.TopologySpreadHostname {
+		topologyKey = topologyKeyHostname
+	}
+	return corev1.TopologySpreadConstraint{
+		MaxSkew:           1,
+		TopologyKey:       topologyKey,
+		WhenUnsatisfiable: corev1.ScheduleAnyway,
+		LabelSelector:     &metav1.LabelSelector{MatchLabels: LabelsSelector(name, namespace)},
+	}
+}
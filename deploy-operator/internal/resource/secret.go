@@ -9,15 +9,16 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-// 定义全局secret配置
-var (
-	defaultSecretName string = "global-secret"
-	defaultEnvData           = map[string]string{
+const defaultSecretName string = "global-secret"
+
+// defaultEnvData 全局secret的默认配置, 每次返回新的map, 并发生成资源时不共用
+func defaultEnvData() map[string]string {
+	return map[string]string{
 		"CONFIG_DB_USERNAME":    "cm9vdAo=",
 		"CONFIG_DB_PASSWORD":    "MTIzNDU2Cg==",
 		"CONFIG_REDIS_PASSWORD": "MTIzNDU2Cg==",
 	}
-)
+}
 
 type SecretBuild struct {
 	*DeployStackBuild
@@ -62,15 +63,12 @@ func (builder *SecretBuild) Update(object client.Object, name, tag string) (clie
 func (builder *SecretBuild) convertString() map[string][]byte {
 	var (
 		data      = make(map[string][]byte)
-		secretObj = make(map[string]string)
+		secretObj = defaultEnvData()
 	)
-	if builder.Instance.Spec.Secret != nil {
-		secretObj = builder.Instance.Spec.Secret
-		for key, value := range secretObj {
-			defaultEnvData[key] = value
-		}
+	// 默认配置合并spec.secret
+	for key, value := range builder.Instance.Spec.Secret {
+		secretObj[key] = value
 	}
-	secretObj = defaultEnvData
 
 	for key, value := range secretObj {
 		//base64 Decode
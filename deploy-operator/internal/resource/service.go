@@ -1,11 +1,22 @@
 package resource
 
 import (
+	"fmt"
+
+	apiv1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+const (
+	// ServiceRoleLabel 区分应用的主Service及额外的Service, ServiceMonitor只采集主Service
+	ServiceRoleLabel = "gopron.online/service-role"
+	ServiceRoleMain  = "main"
+	ServiceRoleExtra = "extra"
+)
+
 type ServiceBuild struct {
 	*DeployStackBuild
 }
@@ -22,78 +33,256 @@ func (builder *ServiceBuild) GetObjectKind() (client.Object, error) {
 	return &corev1.Service{}, nil
 }
 
+// Build 返回应用的主Service, 完整列表见 BuildList
 func (builder *ServiceBuild) Build(name, tag string) (client.Object, error) {
-	//container port
-	var ports []corev1.ServicePort
-	defaultPorts := []corev1.ServicePort{{
-		Name: StringCombin("grpc", "-", name),
-		Port: portForGrpcDefault,
-	}}
-	namespace := builder.Instance.Spec.Namespace
-
-	if builder.Instance.Spec.Ports != nil {
-		ports = builder.servicePorts(name, builder.Instance.Spec.Ports)
-	} else {
-		if builder.Instance.Spec.PortForGrpc != 0 {
-			ports = []corev1.ServicePort{{
-				Name: StringCombin("grpc", "-", name),
-				Port: builder.Instance.Spec.PortForGrpc,
-			}}
-		} else {
-			ports = defaultPorts
-		}
-	}
-	service := corev1.Service{
-		TypeMeta: metav1.TypeMeta{
-			Kind: "Service",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
-			Labels:    Labels(name, namespace),
-		},
-		Spec: corev1.ServiceSpec{
-			Selector: LabelsSelector(name, namespace),
-			Ports:    ports,
-			Type:     builder.Instance.Spec.Service.Type,
-		},
-	}
-	return &service, nil
+	services, err := builder.BuildList(name, tag)
+	if err != nil || len(services) == 0 {
+		return nil, err
+	}
+	return services[0], nil
 }
 
-func (builder *ServiceBuild) servicePorts(name string, servicePorts []ServicePorts) []corev1.ServicePort {
-	var ports []corev1.ServicePort
-	// servicePorts := builder.Instance.Spec.Ports
-	for _, svcPort := range servicePorts {
-		ports = append(ports, corev1.ServicePort{
-			Name: StringCombin(svcPort.Name, "-", name),
-			Port: svcPort.Port,
-		})
+// BuildList 应用的主Service及apps[name].services中的额外Service
+func (builder *ServiceBuild) BuildList(name, tag string) ([]client.Object, error) {
+	specs, err := builder.serviceSpecs(name)
+	if err != nil {
+		return nil, err
 	}
-	return ports
+	var services []client.Object
+	for _, spec := range specs {
+		service := &corev1.Service{
+			TypeMeta: metav1.TypeMeta{
+				Kind: "Service",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ServiceName(name, spec.Name),
+				Namespace: builder.Instance.Spec.Namespace,
+			},
+		}
+		if err := builder.service(service, name, spec); err != nil {
+			return nil, err
+		}
+		services = append(services, service)
+	}
+	return services, nil
 }
 
 func (builder *ServiceBuild) Update(object client.Object, name, tag string) (client.Object, error) {
 	service := object.(*corev1.Service)
-	service.Labels = Labels(name, builder.Instance.Spec.Namespace)
-	service.Spec.Type = builder.Instance.Spec.Service.Type
+	specs, err := builder.serviceSpecs(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, spec := range specs {
+		if ServiceName(name, spec.Name) != service.Name {
+			continue
+		}
+		if err := builder.service(service, name, spec); err != nil {
+			return nil, err
+		}
+		break
+	}
+	return service, nil
+}
+
+// ServiceName 应用的主Service与应用同名, 额外的Service名称为 <app>-<name>
+func ServiceName(name, serviceName string) string {
+	if serviceName == "" {
+		return name
+	}
+	return StringCombin(name, "-", serviceName)
+}
+
+// serviceSpecs 合并spec.service与apps[name]中的配置, 第一项为应用的主Service
+func (builder *ServiceBuild) serviceSpecs(name string) ([]apiv1.AppServiceSpec, error) {
+	stack := builder.Instance.Spec.Service
+	headless := stack.Headless
+	base := apiv1.AppServiceSpec{
+		Type:                  stack.Type,
+		Headless:              &headless,
+		SessionAffinity:       stack.SessionAffinity,
+		SessionAffinityConfig: stack.SessionAffinityConfig,
+		ExternalTrafficPolicy: stack.ExternalTrafficPolicy,
+		Annotations:           stack.Annotations,
+	}
+	defaultPorts := builder.defaultServicePorts(name)
+	apps := builder.Instance.Spec.Apps[name]
+
+	main := base
+	if apps.Service != nil {
+		main = mergeServiceSpec(base, *apps.Service)
+	}
+	main.Name = ""
+	if len(main.Ports) == 0 {
+		main.Ports = defaultPorts
+	}
+	specs := []apiv1.AppServiceSpec{main}
+	names := map[string]bool{}
+	for _, extra := range apps.Services {
+		if extra.Name == "" {
+			return nil, fmt.Errorf("app %s: services[].name is required", name)
+		}
+		if names[extra.Name] {
+			return nil, fmt.Errorf("app %s: duplicate service name %q", name, extra.Name)
+		}
+		names[extra.Name] = true
+		spec := mergeServiceSpec(base, extra)
+		if len(spec.Ports) == 0 {
+			spec.Ports = defaultPorts
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// mergeServiceSpec override中配置的字段覆盖base, 注解合并
+func mergeServiceSpec(base, override apiv1.AppServiceSpec) apiv1.AppServiceSpec {
+	spec := base
+	spec.Name = override.Name
+	spec.Ports = override.Ports
+	if override.Type != "" {
+		spec.Type = override.Type
+	}
+	if override.Headless != nil {
+		spec.Headless = override.Headless
+	}
+	if override.SessionAffinity != "" {
+		spec.SessionAffinity = override.SessionAffinity
+	}
+	if override.SessionAffinityConfig != nil {
+		spec.SessionAffinityConfig = override.SessionAffinityConfig
+	}
+	if override.ExternalTrafficPolicy != "" {
+		spec.ExternalTrafficPolicy = override.ExternalTrafficPolicy
+	}
+	if len(override.Annotations) > 0 {
+		annotations := map[string]string{}
+		for key, value := range base.Annotations {
+			annotations[key] = value
+		}
+		for key, value := range override.Annotations {
+			annotations[key] = value
+		}
+		spec.Annotations = annotations
+	}
+	return spec
+}
+
+// defaultServicePorts spec.ports(未配置时为grpc端口)与apps[name].ports合并, 同名端口以应用中的配置为准
+func (builder *ServiceBuild) defaultServicePorts(name string) []ServicePorts {
+	var ports []ServicePorts
 	if builder.Instance.Spec.Ports != nil {
-		service.Spec.Ports = builder.servicePorts(name, builder.Instance.Spec.Ports)
+		ports = append(ports, builder.Instance.Spec.Ports...)
 	} else {
-		if builder.Instance.Spec.PortForGrpc != 0 {
-			service.Spec.Ports = []corev1.ServicePort{{
-				Name: StringCombin("grpc", "-", name),
-				Port: builder.Instance.Spec.PortForGrpc,
-			}}
+		portForGrpc := builder.Instance.Spec.PortForGrpc
+		if portForGrpc == 0 {
+			portForGrpc = portForGrpcDefault
 		}
+		ports = append(ports, ServicePorts{Name: "grpc", Port: portForGrpc})
 	}
+	if port := builder.Instance.Spec.Service.Ports; port != nil {
+		servicePort := ServicePorts{
+			Name:     port.Name,
+			Port:     port.Port,
+			Protocol: port.Protocol,
+			NodePort: port.NodePort,
+		}
+		if port.TargetPort != (intstr.IntOrString{}) {
+			targetPort := port.TargetPort
+			servicePort.TargetPort = &targetPort
+		}
+		ports = mergePorts(ports, []ServicePorts{servicePort})
+	}
+	if apps, ok := builder.Instance.Spec.Apps[name]; ok {
+		ports = mergePorts(ports, apps.Ports)
+	}
+	return ports
+}
 
-	appsName := builder.Instance.Spec.Apps
-	if apps, ok := appsName[name]; ok {
-		if apps.Ports != nil {
-			service.Spec.Ports = append(service.Spec.Ports, builder.servicePorts(name, apps.Ports)...)
+// mergePorts overrides中与base同名的端口替换base中的端口, 其余追加到末尾
+func mergePorts(base, overrides []ServicePorts) []ServicePorts {
+	ports := append([]ServicePorts{}, base...)
+	for _, override := range overrides {
+		replaced := false
+		for i := range ports {
+			if ports[i].Name == override.Name {
+				ports[i] = override
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			ports = append(ports, override)
 		}
 	}
+	return ports
+}
 
-	return service, nil
+func (builder *ServiceBuild) service(service *corev1.Service, name string, spec apiv1.AppServiceSpec) error {
+	namespace := builder.Instance.Spec.Namespace
+	headless := spec.Headless != nil && *spec.Headless
+	// clusterIP不可变, 切换headless需要删除Service重建
+	switch {
+	case service.Spec.ClusterIP == "":
+		if headless {
+			service.Spec.ClusterIP = corev1.ClusterIPNone
+		}
+	case headless != (service.Spec.ClusterIP == corev1.ClusterIPNone):
+		return fmt.Errorf("service %s: clusterIP is immutable, delete the Service to change headless", service.Name)
+	}
+	service.Labels = Labels(name, namespace)
+	service.Labels[ServiceRoleLabel] = ServiceRoleMain
+	if spec.Name != "" {
+		service.Labels[ServiceRoleLabel] = ServiceRoleExtra
+	}
+	// 保留云厂商控制器写入的注解
+	if len(spec.Annotations) > 0 && service.Annotations == nil {
+		service.Annotations = map[string]string{}
+	}
+	for key, value := range spec.Annotations {
+		service.Annotations[key] = value
+	}
+	service.Spec.Selector = LabelsSelector(name, namespace)
+	service.Spec.Type = spec.Type
+	current := service.Spec.Ports
+	if spec.Type != corev1.ServiceTypeNodePort && spec.Type != corev1.ServiceTypeLoadBalancer {
+		current = nil
+	}
+	service.Spec.Ports = builder.servicePorts(name, spec.Ports, current)
+	service.Spec.SessionAffinity = spec.SessionAffinity
+	if service.Spec.SessionAffinity == "" {
+		service.Spec.SessionAffinity = corev1.ServiceAffinityNone
+	}
+	service.Spec.SessionAffinityConfig = spec.SessionAffinityConfig
+	service.Spec.ExternalTrafficPolicy = spec.ExternalTrafficPolicy
+	return nil
+}
+
+// servicePorts 端口名称为 <name>-<app>, 未指定nodePort时保留已分配的nodePort
+func (builder *ServiceBuild) servicePorts(name string, servicePorts []ServicePorts, current []corev1.ServicePort) []corev1.ServicePort {
+	nodePorts := map[string]int32{}
+	for _, port := range current {
+		nodePorts[port.Name] = port.NodePort
+	}
+	var ports []corev1.ServicePort
+	for _, svcPort := range servicePorts {
+		port := corev1.ServicePort{
+			Name:       StringCombin(svcPort.Name, "-", name),
+			Protocol:   svcPort.Protocol,
+			Port:       svcPort.Port,
+			TargetPort: intstr.FromInt(int(svcPort.Port)),
+			NodePort:   svcPort.NodePort,
+		}
+		if port.Protocol == "" {
+			port.Protocol = corev1.ProtocolTCP
+		}
+		if svcPort.TargetPort != nil {
+			port.TargetPort = *svcPort.TargetPort
+		}
+		if port.NodePort == 0 {
+			port.NodePort = nodePorts[port.Name]
+		}
+		ports = append(ports, port)
+	}
+	return ports
 }
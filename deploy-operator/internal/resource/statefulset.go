@@ -7,7 +7,6 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
-	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	intstr "k8s.io/apimachinery/pkg/util/intstr"
 )
@@ -26,7 +25,10 @@ func (builder *StatefulSetBuild) GetObjectKind() (client.Object, error) {
 
 func (builder *StatefulSetBuild) Build(name, tag string) (client.Object, error) {
 
-	podTemplateSpec := builder.podTemplateSpec(name, tag)
+	podTemplateSpec, err := builder.podTemplateSpec(name, tag)
+	if err != nil {
+		return nil, err
+	}
 
 	sts := appsv1.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
@@ -51,13 +53,14 @@ func (builder *StatefulSetBuild) Build(name, tag string) (client.Object, error)
 	return &sts, nil
 }
 
-func (builder *StatefulSetBuild) podTemplateSpec(name, tag string) corev1.PodTemplateSpec {
+func (builder *StatefulSetBuild) podTemplateSpec(name, tag string) (corev1.PodTemplateSpec, error) {
 	var (
 		image           string
 		registrySecret  string
 		ports           []corev1.ContainerPort
 		resources       corev1.ResourceRequirements
 		imagePullPolicy corev1.PullPolicy
+		err             error
 	)
 	var (
 		configSuffix string = "config"
@@ -65,18 +68,6 @@ func (builder *StatefulSetBuild) podTemplateSpec(name, tag string) corev1.PodTem
 	)
 	namespace := builder.Instance.Spec.Namespace
 
-	requestMem, limitMem := stringsSplit(builder.Instance.Spec.ResourcesMemory)
-	requestCpu, limitCpu := stringsSplit(builder.Instance.Spec.ResourcesCpu)
-	defaultResources := corev1.ResourceRequirements{
-		Requests: corev1.ResourceList{
-			"memory": resource.MustParse(requestMem),
-			"cpu":    resource.MustParse(requestCpu),
-		},
-		Limits: corev1.ResourceList{
-			"memory": resource.MustParse(limitMem),
-			"cpu":    resource.MustParse(limitCpu),
-		},
-	}
 	affinity := corev1.Affinity{
 		PodAffinity: &corev1.PodAffinity{
 			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
@@ -168,8 +159,8 @@ func (builder *StatefulSetBuild) podTemplateSpec(name, tag string) corev1.PodTem
 	}
 	if builder.Instance.Spec.Resources != nil {
 		resources = *builder.Instance.Spec.Resources
-	} else {
-		resources = defaultResources
+	} else if resources, err = builder.defaultResources(); err != nil {
+		return corev1.PodTemplateSpec{}, fmt.Errorf("app %s: %w", name, err)
 	}
 
 	appsName := builder.Instance.Spec.Apps
@@ -187,14 +178,24 @@ func (builder *StatefulSetBuild) podTemplateSpec(name, tag string) corev1.PodTem
 		//
 	}
 
+	extras, err := builder.podExtras(name)
+	if err != nil {
+		return corev1.PodTemplateSpec{}, err
+	}
+	volumes = append(volumes, extras.volumes...)
+	volumeMounts = append(volumeMounts, extras.volumeMounts...)
+
 	podTemplateSpec := corev1.PodTemplateSpec{
 		ObjectMeta: metav1.ObjectMeta{
 			Annotations: map[string]string{},
 			Labels:      LabelsSelector(name, namespace),
 		},
 		Spec: corev1.PodSpec{
-			Affinity: &affinity,
-			Containers: []corev1.Container{{
+			NodeSelector:              builder.nodeSelector(name),
+			Affinity:                  builder.affinity(name, &affinity),
+			Tolerations:               builder.tolerations(name),
+			TopologySpreadConstraints: builder.topologySpreadConstraints(name, namespace),
+			Containers: append([]corev1.Container{{
 				Name:            name,
 				Image:           image,
 				ImagePullPolicy: imagePullPolicy,
@@ -207,7 +208,8 @@ func (builder *StatefulSetBuild) podTemplateSpec(name, tag string) corev1.PodTem
 				LivenessProbe:  &livenessProbe,
 				ReadinessProbe: &readinessProbe,
 				Lifecycle:      &lifecycle,
-			}},
+			}}, extras.sidecars...),
+			InitContainers:                extras.initContainers,
 			TerminationGracePeriodSeconds: int64Ptr(30),
 			Volumes:                       volumes,
 			ImagePullSecrets: []corev1.LocalObjectReference{{
@@ -216,7 +218,7 @@ func (builder *StatefulSetBuild) podTemplateSpec(name, tag string) corev1.PodTem
 		},
 	}
 
-	return podTemplateSpec
+	return podTemplateSpec, nil
 }
 
 func (builder *StatefulSetBuild) containerPorts(name string, containerPorts []ContainerPorts) []corev1.ContainerPort {
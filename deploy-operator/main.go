@@ -33,6 +33,7 @@ import (
 
 	goprononlinev1 "github.com/tiamxu/k8s-operator/deploy-operator/api/v1"
 	"github.com/tiamxu/k8s-operator/deploy-operator/controllers"
+	"github.com/tiamxu/k8s-operator/deploy-operator/internal/registry"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -52,11 +53,15 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var maxConcurrentReconciles int
+	var appWorkers int
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1, "Number of DeployStacks reconciled in parallel.")
+	flag.IntVar(&appWorkers, "app-workers", 4, "Number of apps of a DeployStack reconciled in parallel.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -90,14 +95,33 @@ func main() {
 	}
 
 	if err = (&controllers.DeployStackReconciler{
+		Client:                  mgr.GetClient(),
+		Log:                     ctrl.Log.WithName("controller").WithName("DeployStack"),
+		Scheme:                  mgr.GetScheme(),
+		Recorder:                mgr.GetEventRecorderFor("DeployStack-Controller"),
+		Registry:                registry.NewOCIClient(),
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		AppWorkers:              appWorkers,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "DeployStack")
+		os.Exit(1)
+	}
+	if err = (&controllers.DeployStackPromotionReconciler{
 		Client:   mgr.GetClient(),
-		Log:      ctrl.Log.WithName("controller").WithName("DeployStack"),
+		Log:      ctrl.Log.WithName("controller").WithName("DeployStackPromotion"),
 		Scheme:   mgr.GetScheme(),
-		Recorder: mgr.GetEventRecorderFor("DeployStack-Controller"),
+		Recorder: mgr.GetEventRecorderFor("DeployStackPromotion-Controller"),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "DeployStack")
+		setupLog.Error(err, "unable to create controller", "controller", "DeployStackPromotion")
 		os.Exit(1)
 	}
+	// 本地运行时可通过ENABLE_WEBHOOKS=false关闭webhook
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&goprononlinev1.PromotionApprovalWebhook{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "DeployStackPromotion")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {